@@ -0,0 +1,231 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/almondoo/wire/internal/wire"
+)
+
+// Rules of the diagnostics reported by check, diff and unused.
+const (
+	// errorRule is an error found while loading packages or generating
+	// injectors.
+	errorRule = "wire-error"
+	// outOfDateRule is a wire_gen.go file that differs from what gen
+	// would generate.
+	outOfDateRule = "wire-out-of-date"
+	// unusedRule is an item of a provider set that no injector uses.
+	unusedRule = "wire-unused"
+)
+
+// A diagnostic is a problem reported by check, diff or unused.
+type diagnostic struct {
+	rule    string
+	pos     token.Position
+	message string
+}
+
+// errorDiagnostics returns the diagnostics for errors returned by wire.Load
+// or wire.Generate.
+func errorDiagnostics(errs []error) []diagnostic {
+	diags := make([]diagnostic, 0, len(errs))
+	for _, err := range errs {
+		pos, err := wire.ErrorPosition(err)
+		diags = append(diags, diagnostic{rule: errorRule, pos: pos, message: err.Error()})
+	}
+	return diags
+}
+
+// writeDiagnostics writes diagnostics in the given format, which must be
+// sarif or github. Paths are written relative to wd when possible.
+func writeDiagnostics(w io.Writer, format, wd string, diags []diagnostic) error {
+	switch format {
+	case "sarif":
+		return writeSARIF(w, wd, diags)
+	case "github":
+		return writeGitHub(w, wd, diags)
+	default:
+		panic("unknown diagnostics format " + format)
+	}
+}
+
+// SARIF 2.1.0 log, with only the properties Wire uses. See
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html.
+type (
+	sarifLog struct {
+		Schema  string     `json:"$schema"`
+		Version string     `json:"version"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool               sarifTool                        `json:"tool"`
+		OriginalURIBaseIDs map[string]sarifArtifactLocation `json:"originalUriBaseIds,omitempty"`
+		Results            []sarifResult                    `json:"results"`
+	}
+	sarifTool struct {
+		Driver sarifDriver `json:"driver"`
+	}
+	sarifDriver struct {
+		Name           string      `json:"name"`
+		InformationURI string      `json:"informationUri"`
+		Rules          []sarifRule `json:"rules"`
+	}
+	sarifRule struct {
+		ID               string       `json:"id"`
+		ShortDescription sarifMessage `json:"shortDescription"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifResult struct {
+		RuleID    string          `json:"ruleId"`
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations,omitempty"`
+	}
+	sarifLocation struct {
+		PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+	}
+	sarifPhysicalLocation struct {
+		ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+		Region           *sarifRegion          `json:"region,omitempty"`
+	}
+	sarifArtifactLocation struct {
+		URI       string `json:"uri"`
+		URIBaseID string `json:"uriBaseId,omitempty"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn,omitempty"`
+	}
+)
+
+// srcRoot is the SARIF URI base ID of the working directory.
+const srcRoot = "%SRCROOT%"
+
+func writeSARIF(w io.Writer, wd string, diags []diagnostic) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "wire",
+			InformationURI: "https://github.com/almondoo/wire",
+			Rules: []sarifRule{
+				{ID: errorRule, ShortDescription: sarifMessage{Text: "Wire could not load a package or generate an injector."}},
+				{ID: outOfDateRule, ShortDescription: sarifMessage{Text: "A wire_gen.go file is out of date. Run wire gen to update it."}},
+				{ID: unusedRule, ShortDescription: sarifMessage{Text: "No injector uses a provider, value, binding, field or decorator of a provider set."}},
+			},
+		}},
+		OriginalURIBaseIDs: map[string]sarifArtifactLocation{
+			srcRoot: {URI: fileURI(wd) + "/"},
+		},
+		Results: make([]sarifResult, 0, len(diags)),
+	}
+	for _, d := range diags {
+		res := sarifResult{
+			RuleID:  d.rule,
+			Level:   "error",
+			Message: sarifMessage{Text: d.message},
+		}
+		if d.pos.Filename != "" {
+			loc := sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: fileURI(d.pos.Filename)}}
+			if rel, ok := relPath(wd, d.pos.Filename); ok {
+				loc.ArtifactLocation = sarifArtifactLocation{URI: (&url.URL{Path: rel}).String(), URIBaseID: srcRoot}
+			}
+			if d.pos.Line > 0 {
+				loc.Region = &sarifRegion{StartLine: d.pos.Line, StartColumn: d.pos.Column}
+			}
+			res.Locations = []sarifLocation{{PhysicalLocation: loc}}
+		}
+		run.Results = append(run.Results, res)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
+
+// writeGitHub writes diagnostics as GitHub Actions workflow commands, which
+// annotate the files of a pull request. See
+// https://docs.github.com/actions/using-workflows/workflow-commands-for-github-actions.
+//
+// GitHub resolves the file of an annotation relative to the root of the
+// repository, which is GITHUB_WORKSPACE in a workflow, so paths are written
+// relative to it when it is set, and to wd otherwise.
+func writeGitHub(w io.Writer, wd string, diags []diagnostic) error {
+	root := wd
+	if ws := os.Getenv("GITHUB_WORKSPACE"); ws != "" {
+		root = ws
+	}
+	for _, d := range diags {
+		var props []string
+		if d.pos.Filename != "" {
+			file := d.pos.Filename
+			if rel, ok := relPath(root, file); ok {
+				file = rel
+			}
+			props = append(props, "file="+escapeGitHubProperty(file))
+			if d.pos.Line > 0 {
+				props = append(props, fmt.Sprintf("line=%d", d.pos.Line))
+			}
+			if d.pos.Column > 0 {
+				props = append(props, fmt.Sprintf("col=%d", d.pos.Column))
+			}
+		}
+		props = append(props, "title="+escapeGitHubProperty(d.rule))
+		if _, err := fmt.Fprintf(w, "::error %s::%s\n", strings.Join(props, ","), escapeGitHubData(d.message)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func escapeGitHubData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+func escapeGitHubProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// relPath returns path relative to wd with forward slashes, if path is in
+// wd.
+func relPath(wd, path string) (string, bool) {
+	rel, err := filepath.Rel(wd, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// fileURI returns the file URI of an absolute path.
+func fileURI(path string) string {
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		// Windows paths start with a drive letter.
+		path = "/" + path
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
@@ -0,0 +1,317 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"go/token"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testWD is the working directory the diagnostics of these tests are
+// relative to.
+const testWD = "/src/app"
+
+func TestWriteSARIF(t *testing.T) {
+	tests := []struct {
+		name string
+		diag diagnostic
+		// location is the JSON of the result's locations, or "" if there
+		// are none.
+		location string
+	}{
+		{
+			name: "Position",
+			diag: diagnostic{
+				rule:    errorRule,
+				pos:     token.Position{Filename: "/src/app/wire.go", Line: 12, Column: 3},
+				message: "inject InitializeServer: no provider found for *app.DB",
+			},
+			location: `[{"physicalLocation":{"artifactLocation":{"uri":"wire.go","uriBaseId":"%SRCROOT%"},"region":{"startLine":12,"startColumn":3}}}]`,
+		},
+		{
+			name: "LineOnly",
+			diag: diagnostic{
+				rule:    outOfDateRule,
+				pos:     token.Position{Filename: "/src/app/sub dir/wire_gen.go", Line: 1},
+				message: "wire_gen.go is out of date",
+			},
+			location: `[{"physicalLocation":{"artifactLocation":{"uri":"sub%20dir/wire_gen.go","uriBaseId":"%SRCROOT%"},"region":{"startLine":1}}}]`,
+		},
+		{
+			name: "FileOnly",
+			diag: diagnostic{
+				rule:    errorRule,
+				pos:     token.Position{Filename: "/src/app/wire.go"},
+				message: "wire.go: cannot read file",
+			},
+			location: `[{"physicalLocation":{"artifactLocation":{"uri":"wire.go","uriBaseId":"%SRCROOT%"}}}]`,
+		},
+		{
+			name: "OutsideWorkingDirectory",
+			diag: diagnostic{
+				rule:    unusedRule,
+				pos:     token.Position{Filename: "/src/lib/set.go", Line: 4, Column: 2},
+				message: "unused provider \"lib.NewDB\"",
+			},
+			location: `[{"physicalLocation":{"artifactLocation":{"uri":"file:///src/lib/set.go"},"region":{"startLine":4,"startColumn":2}}}]`,
+		},
+		{
+			name: "NoPosition",
+			diag: diagnostic{
+				rule:    errorRule,
+				message: "first line\nsecond line",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var sb strings.Builder
+			if err := writeSARIF(&sb, testWD, []diagnostic{test.diag}); err != nil {
+				t.Fatal(err)
+			}
+			var log struct {
+				Schema  string `json:"$schema"`
+				Version string `json:"version"`
+				Runs    []struct {
+					Tool struct {
+						Driver struct {
+							Name  string `json:"name"`
+							Rules []struct {
+								ID string `json:"id"`
+							} `json:"rules"`
+						} `json:"driver"`
+					} `json:"tool"`
+					OriginalURIBaseIDs map[string]struct {
+						URI string `json:"uri"`
+					} `json:"originalUriBaseIds"`
+					Results []struct {
+						RuleID  string `json:"ruleId"`
+						Level   string `json:"level"`
+						Message struct {
+							Text string `json:"text"`
+						} `json:"message"`
+						Locations json.RawMessage `json:"locations"`
+					} `json:"results"`
+				} `json:"runs"`
+			}
+			if err := json.Unmarshal([]byte(sb.String()), &log); err != nil {
+				t.Fatalf("output is not JSON: %v\n%s", err, sb.String())
+			}
+			if log.Version != "2.1.0" {
+				t.Errorf("version = %q; want 2.1.0", log.Version)
+			}
+			if log.Schema != "https://json.schemastore.org/sarif-2.1.0.json" {
+				t.Errorf("$schema = %q; want the SARIF 2.1.0 schema", log.Schema)
+			}
+			if len(log.Runs) != 1 {
+				t.Fatalf("got %d runs; want 1", len(log.Runs))
+			}
+			run := log.Runs[0]
+			if run.Tool.Driver.Name != "wire" {
+				t.Errorf("tool.driver.name = %q; want wire", run.Tool.Driver.Name)
+			}
+			var rules []string
+			for _, r := range run.Tool.Driver.Rules {
+				rules = append(rules, r.ID)
+			}
+			if want := []string{errorRule, outOfDateRule, unusedRule}; !reflect.DeepEqual(rules, want) {
+				t.Errorf("rules = %q; want %q", rules, want)
+			}
+			if got := run.OriginalURIBaseIDs[srcRoot].URI; got != "file:///src/app/" {
+				t.Errorf("originalUriBaseIds[%s].uri = %q; want file:///src/app/", srcRoot, got)
+			}
+			if len(run.Results) != 1 {
+				t.Fatalf("got %d results; want 1", len(run.Results))
+			}
+			res := run.Results[0]
+			if res.RuleID != test.diag.rule || res.Level != "error" || res.Message.Text != test.diag.message {
+				t.Errorf("result = %s %s %q; want %s error %q", res.RuleID, res.Level, res.Message.Text, test.diag.rule, test.diag.message)
+			}
+			var locations bytes.Buffer
+			if len(res.Locations) > 0 {
+				if err := json.Compact(&locations, res.Locations); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := locations.String(); got != test.location {
+				t.Errorf("locations = %s; want %s", got, test.location)
+			}
+		})
+	}
+}
+
+func TestWriteSARIFNoDiagnostics(t *testing.T) {
+	var sb strings.Builder
+	if err := writeSARIF(&sb, testWD, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Code scanning tools require results, even if it is empty.
+	if !strings.Contains(sb.String(), `"results": []`) {
+		t.Errorf("output has no empty results:\n%s", sb.String())
+	}
+}
+
+func TestWriteGitHub(t *testing.T) {
+	tests := []struct {
+		name string
+		// workspace is the value of GITHUB_WORKSPACE.
+		workspace string
+		diag      diagnostic
+		want      string
+	}{
+		{
+			name: "Position",
+			diag: diagnostic{
+				rule:    errorRule,
+				pos:     token.Position{Filename: "/src/app/sub/wire.go", Line: 12, Column: 3},
+				message: "inject InitializeServer: no provider found for *app.DB",
+			},
+			// Slashes separate the directories of file, so they are not
+			// escaped.
+			want: "::error file=sub/wire.go,line=12,col=3,title=wire-error::inject InitializeServer: no provider found for *app.DB\n",
+		},
+		{
+			name: "LineOnly",
+			diag: diagnostic{
+				rule:    outOfDateRule,
+				pos:     token.Position{Filename: "/src/app/wire_gen.go", Line: 1},
+				message: "wire_gen.go is out of date",
+			},
+			want: "::error file=wire_gen.go,line=1,title=wire-out-of-date::wire_gen.go is out of date\n",
+		},
+		{
+			name: "OutsideWorkingDirectory",
+			diag: diagnostic{
+				rule:    unusedRule,
+				pos:     token.Position{Filename: "/src/lib/set.go", Line: 4, Column: 2},
+				message: `unused provider "lib.NewDB"`,
+			},
+			want: "::error file=/src/lib/set.go,line=4,col=2,title=wire-unused::unused provider \"lib.NewDB\"\n",
+		},
+		{
+			// The working directory is a subdirectory of the repository,
+			// whose root GitHub resolves the files relative to.
+			name:      "Workspace",
+			workspace: "/src",
+			diag: diagnostic{
+				rule:    errorRule,
+				pos:     token.Position{Filename: "/src/app/sub/wire.go", Line: 12, Column: 3},
+				message: "inject InitializeServer: no provider found for *app.DB",
+			},
+			want: "::error file=app/sub/wire.go,line=12,col=3,title=wire-error::inject InitializeServer: no provider found for *app.DB\n",
+		},
+		{
+			name:      "OutsideWorkspace",
+			workspace: "/src/app",
+			diag: diagnostic{
+				rule:    unusedRule,
+				pos:     token.Position{Filename: "/src/lib/set.go", Line: 4, Column: 2},
+				message: `unused provider "lib.NewDB"`,
+			},
+			want: "::error file=/src/lib/set.go,line=4,col=2,title=wire-unused::unused provider \"lib.NewDB\"\n",
+		},
+		{
+			name: "NoPosition",
+			diag: diagnostic{
+				rule:    errorRule,
+				message: "no packages",
+			},
+			want: "::error title=wire-error::no packages\n",
+		},
+		{
+			name: "MultiLineMessage",
+			diag: diagnostic{
+				rule:    errorRule,
+				pos:     token.Position{Filename: "/src/app/wire.go", Line: 3, Column: 1},
+				message: "no provider found for 100% *app.DB\r\nneeded by *app.Server: see wire.go:7",
+			},
+			// The message escapes only %, CR and LF.
+			want: "::error file=wire.go,line=3,col=1,title=wire-error::no provider found for 100%25 *app.DB%0D%0Aneeded by *app.Server: see wire.go:7\n",
+		},
+		{
+			name: "PropertyEscaping",
+			diag: diagnostic{
+				rule:    errorRule,
+				pos:     token.Position{Filename: "/src/app/a,b:c%d\ne/wire.go", Line: 2},
+				message: "bad file name",
+			},
+			want: "::error file=a%2Cb%3Ac%25d%0Ae/wire.go,line=2,title=wire-error::bad file name\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("GITHUB_WORKSPACE", test.workspace)
+			var sb strings.Builder
+			if err := writeGitHub(&sb, testWD, []diagnostic{test.diag}); err != nil {
+				t.Fatal(err)
+			}
+			if got := sb.String(); got != test.want {
+				t.Errorf("got  %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEscapeGitHub(t *testing.T) {
+	tests := []struct {
+		in, data, property string
+	}{
+		{"plain", "plain", "plain"},
+		{"100%", "100%25", "100%25"},
+		{"a\rb", "a%0Db", "a%0Db"},
+		{"a\nb", "a%0Ab", "a%0Ab"},
+		{"a,b", "a,b", "a%2Cb"},
+		{"a:b", "a:b", "a%3Ab"},
+		{"a/b", "a/b", "a/b"},
+		// % is escaped first, so escapes are not escaped again.
+		{"%0A\n", "%250A%0A", "%250A%0A"},
+	}
+	for _, test := range tests {
+		if got := escapeGitHubData(test.in); got != test.data {
+			t.Errorf("escapeGitHubData(%q) = %q; want %q", test.in, got, test.data)
+		}
+		if got := escapeGitHubProperty(test.in); got != test.property {
+			t.Errorf("escapeGitHubProperty(%q) = %q; want %q", test.in, got, test.property)
+		}
+	}
+}
+
+func TestErrorDiagnostics(t *testing.T) {
+	dir := writeTestModule(t, checkFiles)
+	_, errs := loadTestModule(t, dir)
+	if len(errs) != 2 {
+		t.Fatalf("Load returned %d errors; want 2: %v", len(errs), errs)
+	}
+	diags := errorDiagnostics(errs)
+	for i, d := range diags {
+		if d.rule != errorRule {
+			t.Errorf("diags[%d].rule = %q; want %q", i, d.rule, errorRule)
+		}
+		if d.pos.Filename == "" || d.pos.Line == 0 {
+			t.Errorf("diags[%d].pos = %v; want the position of the injector", i, d.pos)
+		}
+		if strings.HasPrefix(d.message, d.pos.Filename) {
+			t.Errorf("diags[%d].message = %q; want the message without the position", i, d.message)
+		}
+	}
+	// The first error spans several lines.
+	if !strings.Contains(diags[0].message, "\nneeded by") {
+		t.Errorf("diags[0].message = %q; want several lines", diags[0].message)
+	}
+}
@@ -0,0 +1,517 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/almondoo/wire/internal/wire"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// nodeKind is the kind of source of a node in a dependency graph.
+type nodeKind int
+
+const (
+	providerNode nodeKind = iota
+	valueNode
+	fieldNode
+	bindingNode
+	argNode
+	multibindingNode
+	optionalNode
+	decoratorNode
+	// inputNode is a type that is not provided by the provider set.
+	inputNode
+	injectorNode
+	// packageNode is a package whose nodes were collapsed.
+	packageNode
+)
+
+// edgeKind is the relationship between the nodes of an edge.
+type edgeKind int
+
+const (
+	// dependsEdge passes a value to a provider, multibinding or injector.
+	dependsEdge edgeKind = iota
+	// bindsEdge binds a concrete type to an interface.
+	bindsEdge
+	// fieldEdge selects a field from a struct.
+	fieldEdge
+	// decoratesEdge passes a value to a decorator that wraps it.
+	decoratesEdge
+)
+
+type graphNode struct {
+	id    string
+	kind  nodeKind
+	label string
+	// pkg is the import path of the package the node belongs to when
+	// nodes are collapsed by package.
+	pkg         string
+	highlighted bool
+}
+
+type graphEdge struct {
+	from, to    *graphNode
+	kind        edgeKind
+	label       string
+	highlighted bool
+}
+
+// depGraph is the dependency graph of a provider set or an injector. Edges
+// go from a value to the nodes that use it.
+type depGraph struct {
+	nodes []*graphNode
+	edges []*graphEdge
+	// out maps a type to the node whose value is used for the type, after
+	// all its decorators are applied.
+	out *typeutil.Map
+}
+
+// graphBuilder builds the depGraph of a provider set.
+type graphBuilder struct {
+	g   *depGraph
+	set *wire.ProviderSet
+	// pkg is the import path of the package that declares the set.
+	pkg string
+	// sources maps a provider, value, field, multibinding, optional binding,
+	// decorator, injector argument index or interface binding to its node.
+	sources map[interface{}]*graphNode
+}
+
+// newGraph returns the graph of the values needed to create the given types
+// from set. Types that set cannot provide become input nodes.
+func newGraph(set *wire.ProviderSet, pkg string, roots []types.Type) *depGraph {
+	b := &graphBuilder{
+		g:       &depGraph{out: new(typeutil.Map)},
+		set:     set,
+		pkg:     pkg,
+		sources: make(map[interface{}]*graphNode),
+	}
+	for _, t := range roots {
+		b.node(t)
+	}
+	return b.g
+}
+
+// setGraph returns the graph of all the types provided by set.
+func setGraph(set *wire.ProviderSet, pkg string) *depGraph {
+	return newGraph(set, pkg, sortedTypes(set.Outputs()))
+}
+
+// injectorGraph returns the graph of the values created by an injector.
+func injectorGraph(in *wire.Injector) *depGraph {
+	var roots []types.Type
+	if !in.Set.For(in.Out).IsNil() {
+		roots = []types.Type{in.Out}
+	} else {
+		// The injector returns a struct with several outputs or implements
+		// an interface with a method for each output.
+		roots = injectorOutputs(in.Out)
+	}
+	g := newGraph(in.Set, in.ImportPath, roots)
+	n := g.addNode(injectorNode, in.FuncName, in.ImportPath)
+	for _, t := range roots {
+		g.addEdge(g.out.At(t).(*graphNode), n, dependsEdge, t)
+	}
+	return g
+}
+
+// injectorOutputs returns the types of the fields of the struct returned by
+// an injector with several outputs, or the results of the methods of the
+// interface it implements.
+func injectorOutputs(out types.Type) []types.Type {
+	if p, ok := out.(*types.Pointer); ok {
+		out = p.Elem()
+	}
+	var ts []types.Type
+	switch u := out.Underlying().(type) {
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			if f := u.Field(i); !f.Embedded() {
+				ts = append(ts, f.Type())
+			}
+		}
+	case *types.Interface:
+		for i := 0; i < u.NumMethods(); i++ {
+			if res := u.Method(i).Type().(*types.Signature).Results(); res.Len() == 1 {
+				ts = append(ts, res.At(0).Type())
+			}
+		}
+	}
+	return ts
+}
+
+func (g *depGraph) addNode(kind nodeKind, label, pkg string) *graphNode {
+	n := &graphNode{id: "n" + strconv.Itoa(len(g.nodes)), kind: kind, label: label, pkg: pkg}
+	g.nodes = append(g.nodes, n)
+	return n
+}
+
+func (g *depGraph) addEdge(from, to *graphNode, kind edgeKind, t types.Type) {
+	g.edges = append(g.edges, &graphEdge{from: from, to: to, kind: kind, label: graphTypeString(t)})
+}
+
+// node returns the node whose value is used for t, adding it and its
+// dependencies to the graph if needed.
+func (b *graphBuilder) node(t types.Type) *graphNode {
+	if n, ok := b.g.out.At(t).(*graphNode); ok {
+		return n
+	}
+	pv := b.set.For(t)
+	n := b.source(t, pv)
+	// Record the node before adding the decorators, which depend on it.
+	b.g.out.Set(t, n)
+	for _, d := range pv.Decorators() {
+		dn := b.sources[d]
+		if dn == nil {
+			dn = b.g.addNode(decoratorNode, providerLabel(d.Provider), d.Provider.Pkg.Path())
+			b.sources[d] = dn
+			for _, arg := range d.Provider.Args {
+				if !types.Identical(arg.Type, d.Decorated()) {
+					b.g.addEdge(b.node(arg.Type), dn, dependsEdge, arg.Type)
+				}
+			}
+		}
+		b.g.addEdge(n, dn, decoratesEdge, t)
+		n = dn
+		b.g.out.Set(t, n)
+	}
+	return n
+}
+
+// source returns the node of the provider, value, or other source of t,
+// before any decorators are applied.
+func (b *graphBuilder) source(t types.Type, pv wire.ProvidedType) *graphNode {
+	if !pv.IsNil() && !types.Identical(pv.Type(), t) {
+		// t is an interface bound to the concrete type pv.Type().
+		key := "bind " + wire.TypeString(t)
+		if n := b.sources[key]; n != nil {
+			return n
+		}
+		n := b.g.addNode(bindingNode, "wire.Bind "+graphTypeString(t), b.typePkg(t))
+		b.sources[key] = n
+		b.g.addEdge(b.undecorated(pv.Type(), pv), n, bindsEdge, pv.Type())
+		return n
+	}
+	return b.undecorated(t, pv)
+}
+
+// undecorated returns the node of the source of t.
+func (b *graphBuilder) undecorated(t types.Type, pv wire.ProvidedType) *graphNode {
+	var key interface{}
+	switch {
+	case pv.IsNil():
+		key = "input " + wire.TypeString(t)
+	case pv.IsProvider():
+		key = pv.Provider()
+	case pv.IsValue():
+		key = pv.Value()
+	case pv.IsArg():
+		key = pv.Arg().Index
+	case pv.IsField():
+		key = pv.Field()
+	case pv.IsMultibinding():
+		key = pv.Multibinding()
+	case pv.IsOptional():
+		key = pv.Optional()
+	default:
+		panic("unreachable")
+	}
+	if n := b.sources[key]; n != nil {
+		return n
+	}
+	var n *graphNode
+	switch {
+	case pv.IsNil():
+		n = b.g.addNode(inputNode, graphTypeString(t), b.typePkg(t))
+		b.sources[key] = n
+	case pv.IsProvider():
+		p := pv.Provider()
+		n = b.g.addNode(providerNode, providerLabel(p), p.Pkg.Path())
+		b.sources[key] = n
+		for _, arg := range p.Args {
+			b.g.addEdge(b.node(arg.Type), n, dependsEdge, arg.Type)
+		}
+	case pv.IsValue():
+		n = b.g.addNode(valueNode, "wire.Value "+graphTypeString(t), b.typePkg(t))
+		b.sources[key] = n
+	case pv.IsArg():
+		a := pv.Arg()
+		label := "arg " + graphTypeString(t)
+		if name := a.Args.Tuple.At(a.Index).Name(); name != "" && name != "_" {
+			label = "arg " + name + " " + graphTypeString(t)
+		}
+		n = b.g.addNode(argNode, label, b.pkg)
+		b.sources[key] = n
+	case pv.IsField():
+		f := pv.Field()
+		parent := f.Parent
+		if p, ok := parent.(*types.Pointer); ok {
+			parent = p.Elem()
+		}
+		n = b.g.addNode(fieldNode, graphTypeString(parent)+"."+f.Name, f.Pkg.Path())
+		b.sources[key] = n
+		b.g.addEdge(b.node(f.Parent), n, fieldEdge, f.Parent)
+	case pv.IsMultibinding():
+		m := pv.Multibinding()
+		n = b.g.addNode(multibindingNode, "multibinding "+graphTypeString(m.Out), b.typePkg(m.Out))
+		b.sources[key] = n
+		for _, e := range m.Elems {
+			b.g.addEdge(b.node(e), n, dependsEdge, e)
+		}
+	case pv.IsOptional():
+		o := pv.Optional()
+		n = b.g.addNode(optionalNode, "wire.Optional "+graphTypeString(o.Out), b.typePkg(o.Out))
+		b.sources[key] = n
+	}
+	return n
+}
+
+// typePkg returns the import path of the package that declares t, or the
+// package of the provider set if t is not a named type.
+func (b *graphBuilder) typePkg(t types.Type) string {
+	for {
+		p, ok := t.(*types.Pointer)
+		if !ok {
+			break
+		}
+		t = p.Elem()
+	}
+	if n, ok := t.(*types.Named); ok && n.Obj().Pkg() != nil {
+		return n.Obj().Pkg().Path()
+	}
+	return b.pkg
+}
+
+func providerLabel(p *wire.Provider) string {
+	if p.IsStruct {
+		return p.Pkg.Name() + "." + p.Name + "{}"
+	}
+	return p.Pkg.Name() + "." + p.Name
+}
+
+// graphTypeString returns the string form of a type in a graph, with
+// packages written by name to keep labels short.
+func graphTypeString(t types.Type) string {
+	return wire.TypeStringQualified(t, (*types.Package).Name)
+}
+
+func sortedTypes(ts []types.Type) []types.Type {
+	sort.Slice(ts, func(i, j int) bool {
+		return wire.TypeString(ts[i]) < wire.TypeString(ts[j])
+	})
+	return ts
+}
+
+// highlight marks the node that provides the type named typ, and all the
+// nodes it depends on, with the edges between them. It returns false if no
+// node provides typ.
+func (g *depGraph) highlight(typ string) bool {
+	var target *graphNode
+	g.out.Iterate(func(t types.Type, n interface{}) {
+		if wire.TypeString(t) == typ || graphTypeString(t) == typ {
+			target = n.(*graphNode)
+		}
+	})
+	if target == nil {
+		return false
+	}
+	in := make(map[*graphNode][]*graphEdge)
+	for _, e := range g.edges {
+		in[e.to] = append(in[e.to], e)
+	}
+	stk := []*graphNode{target}
+	target.highlighted = true
+	for len(stk) > 0 {
+		n := stk[len(stk)-1]
+		stk = stk[:len(stk)-1]
+		for _, e := range in[n] {
+			e.highlighted = true
+			if !e.from.highlighted {
+				e.from.highlighted = true
+				stk = append(stk, e.from)
+			}
+		}
+	}
+	return true
+}
+
+// collapse returns a graph with a node for each package of the nodes of g
+// and an edge between two packages if g has an edge between their nodes.
+func (g *depGraph) collapse() *depGraph {
+	cg := &depGraph{out: new(typeutil.Map)}
+	pkgs := make(map[string]*graphNode)
+	for _, n := range g.nodes {
+		pn := pkgs[n.pkg]
+		if pn == nil {
+			pn = cg.addNode(packageNode, n.pkg, n.pkg)
+			pkgs[n.pkg] = pn
+		}
+		pn.highlighted = pn.highlighted || n.highlighted
+	}
+	type pkgEdge struct{ from, to *graphNode }
+	edges := make(map[pkgEdge]*graphEdge)
+	for _, e := range g.edges {
+		k := pkgEdge{pkgs[e.from.pkg], pkgs[e.to.pkg]}
+		if k.from == k.to {
+			continue
+		}
+		ce := edges[k]
+		if ce == nil {
+			ce = &graphEdge{from: k.from, to: k.to, kind: dependsEdge}
+			edges[k] = ce
+			cg.edges = append(cg.edges, ce)
+		}
+		ce.highlighted = ce.highlighted || e.highlighted
+	}
+	return cg
+}
+
+// writeDOT writes the graph in the Graphviz DOT language.
+func (g *depGraph) writeDOT(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph wire {\n")
+	sb.WriteString("\trankdir=LR;\n")
+	sb.WriteString("\tnode [shape=box];\n")
+	for _, n := range g.nodes {
+		attrs := []string{"label=" + strconv.Quote(n.label)}
+		switch n.kind {
+		case valueNode:
+			attrs = append(attrs, "shape=note")
+		case fieldNode:
+			attrs = append(attrs, "style=rounded")
+		case bindingNode:
+			attrs = append(attrs, "shape=diamond")
+		case argNode:
+			attrs = append(attrs, "shape=ellipse")
+		case multibindingNode:
+			attrs = append(attrs, "shape=folder")
+		case optionalNode:
+			attrs = append(attrs, "style=dashed")
+		case decoratorNode:
+			attrs = append(attrs, "shape=cds")
+		case inputNode:
+			attrs = append(attrs, "shape=ellipse", "style=dashed")
+		case injectorNode:
+			attrs = append(attrs, "shape=doubleoctagon")
+		case packageNode:
+			attrs = append(attrs, "shape=tab")
+		}
+		if n.highlighted {
+			attrs = append(attrs, "color=red", "penwidth=2")
+		}
+		fmt.Fprintf(&sb, "\t%s [%s];\n", n.id, strings.Join(attrs, ", "))
+	}
+	for _, e := range g.edges {
+		var attrs []string
+		if e.label != "" {
+			attrs = append(attrs, "label="+strconv.Quote(e.label))
+		}
+		switch e.kind {
+		case bindsEdge:
+			attrs = append(attrs, "style=dashed")
+		case fieldEdge:
+			attrs = append(attrs, "style=dotted")
+		case decoratesEdge:
+			attrs = append(attrs, "style=bold")
+		}
+		if e.highlighted {
+			attrs = append(attrs, "color=red", "penwidth=2")
+		}
+		if len(attrs) == 0 {
+			fmt.Fprintf(&sb, "\t%s -> %s;\n", e.from.id, e.to.id)
+		} else {
+			fmt.Fprintf(&sb, "\t%s -> %s [%s];\n", e.from.id, e.to.id, strings.Join(attrs, ", "))
+		}
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// writeMermaid writes the graph as a Mermaid flowchart.
+func (g *depGraph) writeMermaid(w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("flowchart LR\n")
+	var highlightedNodes []string
+	for _, n := range g.nodes {
+		label := mermaidQuote(n.label)
+		var shape string
+		switch n.kind {
+		case valueNode:
+			shape = ">" + label + "]"
+		case fieldNode:
+			shape = "(" + label + ")"
+		case bindingNode:
+			shape = "{" + label + "}"
+		case argNode:
+			shape = "([" + label + "])"
+		case multibindingNode, decoratorNode:
+			shape = "[[" + label + "]]"
+		case optionalNode:
+			shape = "[/" + label + "/]"
+		case inputNode:
+			shape = "((" + label + "))"
+		case injectorNode:
+			shape = "{{" + label + "}}"
+		default:
+			shape = "[" + label + "]"
+		}
+		fmt.Fprintf(&sb, "\t%s%s\n", n.id, shape)
+		if n.highlighted {
+			highlightedNodes = append(highlightedNodes, n.id)
+		}
+	}
+	var highlightedEdges []string
+	for i, e := range g.edges {
+		var arrow string
+		switch e.kind {
+		case bindsEdge, fieldEdge:
+			arrow = "-.->"
+		case decoratesEdge:
+			arrow = "==>"
+		default:
+			arrow = "-->"
+		}
+		if e.label != "" {
+			arrow += "|" + mermaidQuote(e.label) + "|"
+		}
+		fmt.Fprintf(&sb, "\t%s %s %s\n", e.from.id, arrow, e.to.id)
+		if e.highlighted {
+			highlightedEdges = append(highlightedEdges, strconv.Itoa(i))
+		}
+	}
+	if len(highlightedNodes) > 0 {
+		sb.WriteString("\tclassDef highlight stroke:#f00,stroke-width:2px\n")
+		fmt.Fprintf(&sb, "\tclass %s highlight\n", strings.Join(highlightedNodes, ","))
+	}
+	if len(highlightedEdges) > 0 {
+		fmt.Fprintf(&sb, "\tlinkStyle %s stroke:#f00,stroke-width:2px\n", strings.Join(highlightedEdges, ","))
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// mermaidQuote returns s as a quoted Mermaid label.
+func mermaidQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
@@ -0,0 +1,205 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/almondoo/wire/internal/wire"
+)
+
+// graphFiles is a module whose provider set has types that need escaping in
+// both formats: a map of pointers to a type of another package, an
+// anonymous struct whose field tag has quotes, and slices, whose brackets
+// are Mermaid syntax.
+var graphFiles = map[string]string{
+	"x/x.go": `package x
+
+type T struct{}
+
+func NewT() *T { return &T{} }
+`,
+	"app.go": `package wiretest
+
+import (
+	"github.com/almondoo/wire"
+	"example.com/wiretest/x"
+)
+
+func NewConfig() struct {
+	Name string ` + "`json:\"name\"`" + `
+} {
+	return struct {
+		Name string ` + "`json:\"name\"`" + `
+	}{}
+}
+
+func NewIndex(t *x.T) map[string]*x.T { return map[string]*x.T{"t": t} }
+
+type Store struct {
+	Log *Logger
+}
+
+func NewStore(idx map[string]*x.T, cfg struct {
+	Name string ` + "`json:\"name\"`" + `
+}) *Store {
+	return &Store{}
+}
+
+type Handler interface{ Handle() }
+
+type Server struct {
+	Names []string
+	Store *Store
+}
+
+func (*Server) Handle() {}
+
+type Logger struct{}
+
+var Set = wire.NewSet(
+	x.NewT,
+	NewIndex,
+	NewConfig,
+	NewStore,
+	wire.Struct(new(Server), "*"),
+	wire.Value([]string{"a"}),
+	wire.Bind(new(Handler), new(*Server)),
+	wire.FieldsOf(new(*Store), "Log"),
+)
+`,
+	"wire.go": `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import "github.com/almondoo/wire"
+
+func InitializeHandler() Handler {
+	wire.Build(Set)
+	return nil
+}
+`,
+}
+
+func TestGraph(t *testing.T) {
+	dir := writeTestModule(t, graphFiles)
+	info, errs := loadTestModule(t, dir)
+	if len(errs) > 0 {
+		t.Fatalf("Load: %v", errs)
+	}
+	var set *wire.ProviderSet
+	for id, s := range info.Sets {
+		if id.VarName == "Set" {
+			set = s
+		}
+	}
+	if set == nil {
+		t.Fatal("Load did not find Set")
+	}
+	if len(info.Injectors) != 1 {
+		t.Fatalf("Load found %d injectors; want 1", len(info.Injectors))
+	}
+	in := info.Injectors[0]
+
+	tests := []struct {
+		name      string
+		graph     func() *depGraph
+		collapse  bool
+		highlight string
+	}{
+		{
+			name:  "set",
+			graph: func() *depGraph { return setGraph(set, "example.com/wiretest") },
+		},
+		{
+			name:  "injector",
+			graph: func() *depGraph { return injectorGraph(in) },
+		},
+		{
+			name:     "collapse",
+			graph:    func() *depGraph { return injectorGraph(in) },
+			collapse: true,
+		},
+		{
+			name:      "highlight",
+			graph:     func() *depGraph { return setGraph(set, "example.com/wiretest") },
+			highlight: "*wiretest.Store",
+		},
+		{
+			name:      "highlight_collapse",
+			graph:     func() *depGraph { return injectorGraph(in) },
+			collapse:  true,
+			highlight: "map[string]*x.T",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, format := range []string{"dot", "mermaid"} {
+				g := test.graph()
+				if test.highlight != "" && !g.highlight(test.highlight) {
+					t.Fatalf("highlight(%q) = false; want true", test.highlight)
+				}
+				if test.collapse {
+					g = g.collapse()
+				}
+				var sb strings.Builder
+				var err error
+				if format == "dot" {
+					err = g.writeDOT(&sb)
+				} else {
+					err = g.writeMermaid(&sb)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkGolden(t, "graph/"+test.name+"."+format, sb.String())
+			}
+		})
+	}
+}
+
+func TestGraphHighlightMissing(t *testing.T) {
+	dir := writeTestModule(t, graphFiles)
+	info, errs := loadTestModule(t, dir)
+	if len(errs) > 0 {
+		t.Fatalf("Load: %v", errs)
+	}
+	g := injectorGraph(info.Injectors[0])
+	if g.highlight("*wiretest.Missing") {
+		t.Error("highlight of a type that is not provided = true; want false")
+	}
+	for _, n := range g.nodes {
+		if n.highlighted {
+			t.Errorf("node %s is highlighted; want no highlighted nodes", n.label)
+		}
+	}
+}
+
+func TestMermaidQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"*x.T", `"*x.T"`},
+		{"map[string]*x.T", `"map[string]*x.T"`},
+		{`struct{Name string "json:\"name\""}`, `"struct{Name string #quot;json:\#quot;name\#quot;#quot;}"`},
+	}
+	for _, test := range tests {
+		if got := mermaidQuote(test.in); got != test.want {
+			t.Errorf("mermaidQuote(%q) = %q; want %q", test.in, got, test.want)
+		}
+	}
+}
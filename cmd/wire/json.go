@@ -0,0 +1,177 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"go/token"
+	"go/types"
+	"io"
+	"sort"
+
+	"github.com/almondoo/wire/internal/wire"
+)
+
+// jsonVersion is the version of the schema of the JSON output of show and
+// check. It changes when a field is removed or changes meaning. New fields
+// may be added without changing it.
+const jsonVersion = 1
+
+// jsonReport is the JSON output of show and check.
+type jsonReport struct {
+	Version     int              `json:"version"`
+	Sets        []jsonSet        `json:"sets,omitempty"`
+	Injectors   []jsonInjector   `json:"injectors,omitempty"`
+	Diagnostics []jsonDiagnostic `json:"diagnostics"`
+}
+
+type jsonPosition struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+type jsonSet struct {
+	// ID is the set's ID as printed by show, as in "example.com/foo".Set.
+	ID         string        `json:"id"`
+	ImportPath string        `json:"importPath"`
+	Name       string        `json:"name"`
+	Pos        *jsonPosition `json:"pos,omitempty"`
+	// Imports are the IDs of the named provider sets the set imports,
+	// directly or indirectly.
+	Imports      []string          `json:"imports"`
+	OutputGroups []jsonOutputGroup `json:"outputGroups"`
+	Overrides    []jsonOverride    `json:"overrides,omitempty"`
+}
+
+// jsonOutputGroup lists the types a set provides given the same inputs.
+type jsonOutputGroup struct {
+	Inputs  []string       `json:"inputs"`
+	Outputs []jsonProvided `json:"outputs"`
+}
+
+type jsonProvided struct {
+	Type string        `json:"type"`
+	Pos  *jsonPosition `json:"pos,omitempty"`
+}
+
+type jsonOverride struct {
+	Type     string        `json:"type"`
+	Replaced *jsonPosition `json:"replaced,omitempty"`
+	By       *jsonPosition `json:"by,omitempty"`
+}
+
+type jsonInjector struct {
+	// ID is the injector's ID as printed by show, as in
+	// "example.com/foo".InitializeFoo.
+	ID         string        `json:"id"`
+	ImportPath string        `json:"importPath"`
+	Name       string        `json:"name"`
+	Pos        *jsonPosition `json:"pos,omitempty"`
+}
+
+// jsonDiagnostic is an error found while loading packages. The position is
+// omitted if the error has none.
+type jsonDiagnostic struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// showReport returns the JSON output of show.
+func showReport(info *wire.Info, errs []error) *jsonReport {
+	r := checkReport(errs)
+	if info == nil {
+		return r
+	}
+	for _, k := range sortedSetIDs(info) {
+		set := info.Sets[k]
+		outGroups, imports := gather(info, k)
+		js := jsonSet{
+			ID:           k.String(),
+			ImportPath:   k.ImportPath,
+			Name:         k.VarName,
+			Pos:          newJSONPosition(info.Fset, set.Pos),
+			Imports:      sortSet(imports),
+			OutputGroups: make([]jsonOutputGroup, 0, len(outGroups)),
+		}
+		for _, g := range outGroups {
+			jg := jsonOutputGroup{Inputs: []string{}}
+			g.inputs.Iterate(func(t types.Type, _ interface{}) {
+				jg.Inputs = append(jg.Inputs, wire.TypeString(t))
+			})
+			sort.Strings(jg.Inputs)
+			g.outputs.Iterate(func(t types.Type, v interface{}) {
+				jg.Outputs = append(jg.Outputs, jsonProvided{
+					Type: wire.TypeString(t),
+					Pos:  newJSONPosition(info.Fset, outputPos(v)),
+				})
+			})
+			sort.Slice(jg.Outputs, func(i, j int) bool {
+				return jg.Outputs[i].Type < jg.Outputs[j].Type
+			})
+			js.OutputGroups = append(js.OutputGroups, jg)
+		}
+		for _, o := range set.Overrides {
+			js.Overrides = append(js.Overrides, jsonOverride{
+				Type:     wire.TypeString(o.Type),
+				Replaced: newJSONPosition(info.Fset, o.Replaced),
+				By:       newJSONPosition(info.Fset, o.By),
+			})
+		}
+		r.Sets = append(r.Sets, js)
+	}
+	for _, in := range sortedInjectors(info) {
+		r.Injectors = append(r.Injectors, jsonInjector{
+			ID:         in.String(),
+			ImportPath: in.ImportPath,
+			Name:       in.FuncName,
+			Pos:        newJSONPosition(info.Fset, in.Pos),
+		})
+	}
+	return r
+}
+
+// checkReport returns the JSON output of check.
+func checkReport(errs []error) *jsonReport {
+	r := &jsonReport{
+		Version:     jsonVersion,
+		Diagnostics: make([]jsonDiagnostic, 0, len(errs)),
+	}
+	for _, d := range errorDiagnostics(errs) {
+		r.Diagnostics = append(r.Diagnostics, jsonDiagnostic{
+			File:    d.pos.Filename,
+			Line:    d.pos.Line,
+			Column:  d.pos.Column,
+			Message: d.message,
+		})
+	}
+	return r
+}
+
+func newJSONPosition(fset *token.FileSet, pos token.Pos) *jsonPosition {
+	if !pos.IsValid() {
+		return nil
+	}
+	p := fset.Position(pos)
+	return &jsonPosition{File: p.Filename, Line: p.Line, Column: p.Column}
+}
+
+func writeJSON(w io.Writer, r *jsonReport) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
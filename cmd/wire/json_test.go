@@ -0,0 +1,154 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The golden files of these tests pin the schema of the JSON output of show
+// and check at jsonVersion. If one of them changes other than by adding
+// fields, jsonVersion must change too.
+
+// showFiles is a module with a set that imports another set, an override
+// and an injector.
+var showFiles = map[string]string{
+	"db/db.go": `package db
+
+import "github.com/almondoo/wire"
+
+type Config struct{ DSN string }
+
+type DB struct{}
+
+func NewDB(cfg Config) *DB { return &DB{} }
+
+var Set = wire.NewSet(NewDB)
+`,
+	"app.go": `package wiretest
+
+import (
+	"github.com/almondoo/wire"
+	"example.com/wiretest/db"
+)
+
+type Server struct{ DB *db.DB }
+
+var Set = wire.NewSet(db.Set, wire.Struct(new(Server), "*"))
+
+func NewFakeDB() *db.DB { return &db.DB{} }
+
+var TestSet = wire.Override(Set, NewFakeDB)
+`,
+	"wire.go": `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import (
+	"github.com/almondoo/wire"
+	"example.com/wiretest/db"
+)
+
+func InitializeServer(cfg db.Config) *Server {
+	wire.Build(Set)
+	return nil
+}
+`,
+}
+
+// checkFiles is a module with Wire errors.
+var checkFiles = map[string]string{
+	"wire.go": `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import "github.com/almondoo/wire"
+
+type DB struct{}
+
+type Server struct{ DB *DB }
+
+func NewServer(db *DB) *Server { return &Server{DB: db} }
+
+func NewUnused() string { return "" }
+
+func InitializeServer() *Server {
+	wire.Build(NewServer)
+	return nil
+}
+
+func InitializeUnused(db *DB) *Server {
+	wire.Build(NewServer, NewUnused)
+	return nil
+}
+`,
+}
+
+// jsonOutput returns the JSON encoding of r with dir, the directory of the
+// test module if not empty, replaced by $DIR, so that it does not depend on
+// where the test runs.
+func jsonOutput(t *testing.T, dir string, r *jsonReport) string {
+	t.Helper()
+	var sb strings.Builder
+	if err := writeJSON(&sb, r); err != nil {
+		t.Fatal(err)
+	}
+	if dir == "" {
+		return sb.String()
+	}
+	return strings.ReplaceAll(sb.String(), filepath.ToSlash(dir), "$DIR")
+}
+
+func TestShowJSON(t *testing.T) {
+	dir := writeTestModule(t, showFiles)
+	info, errs := loadTestModule(t, dir)
+	if len(errs) > 0 {
+		t.Fatalf("Load: %v", errs)
+	}
+	checkGolden(t, "json/show.json", jsonOutput(t, dir, showReport(info, errs)))
+}
+
+func TestCheckJSON(t *testing.T) {
+	t.Run("Errors", func(t *testing.T) {
+		dir := writeTestModule(t, checkFiles)
+		_, errs := loadTestModule(t, dir)
+		if len(errs) == 0 {
+			t.Fatal("Load succeeded; want errors")
+		}
+		checkGolden(t, "json/check.json", jsonOutput(t, dir, checkReport(errs)))
+	})
+	t.Run("NoErrors", func(t *testing.T) {
+		// diagnostics is an empty array rather than null or missing, and
+		// check has no sets or injectors.
+		checkGolden(t, "json/check_ok.json", jsonOutput(t, "", checkReport(nil)))
+	})
+	t.Run("NoPosition", func(t *testing.T) {
+		errs := []error{errors.New("first line\nsecond line")}
+		checkGolden(t, "json/check_nopos.json", jsonOutput(t, "", checkReport(errs)))
+	})
+}
+
+func TestShowJSONWithoutInfo(t *testing.T) {
+	// When no package can be loaded, show reports the errors like check.
+	errs := []error{errors.New("no packages")}
+	if got, want := jsonOutput(t, "", showReport(nil, errs)), jsonOutput(t, "", checkReport(errs)); got != want {
+		t.Errorf("showReport(nil, errs) =\n%s\nwant the checkReport output\n%s", got, want)
+	}
+}
@@ -0,0 +1,583 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/token"
+	"io"
+	"log"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/almondoo/wire/internal/wire"
+)
+
+// This file implements the subset of the Language Server Protocol used by
+// wire lsp. See
+// https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/.
+
+type (
+	lspRequest struct {
+		// ID is empty for notifications.
+		ID     json.RawMessage `json:"id,omitempty"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params,omitempty"`
+	}
+	lspResponse struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  interface{}     `json:"result"`
+	}
+	lspErrorResponse struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Error   *lspError       `json:"error"`
+	}
+	lspNotification struct {
+		JSONRPC string      `json:"jsonrpc"`
+		Method  string      `json:"method"`
+		Params  interface{} `json:"params"`
+	}
+	lspError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	lspPosition struct {
+		Line      int `json:"line"`
+		Character int `json:"character"`
+	}
+	lspRange struct {
+		Start lspPosition `json:"start"`
+		End   lspPosition `json:"end"`
+	}
+	lspLocation struct {
+		URI   string   `json:"uri"`
+		Range lspRange `json:"range"`
+	}
+	lspDiagnostic struct {
+		Range    lspRange `json:"range"`
+		Severity int      `json:"severity"`
+		Source   string   `json:"source"`
+		Message  string   `json:"message"`
+	}
+	lspTextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	}
+	lspTextDocumentParams struct {
+		TextDocument   lspTextDocument `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+	}
+	lspTextDocumentPositionParams struct {
+		TextDocument lspTextDocument `json:"textDocument"`
+		Position     lspPosition     `json:"position"`
+	}
+	lspMarkupContent struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+	}
+	lspHover struct {
+		Contents lspMarkupContent `json:"contents"`
+	}
+)
+
+// LSP error codes and constants.
+const (
+	lspParseError     = -32700
+	lspInvalidParams  = -32602
+	lspMethodNotFound = -32601
+
+	lspSeverityError = 1
+	// lspSyncFull means that the client sends the full text of a document
+	// when it changes.
+	lspSyncFull = 1
+)
+
+// lspCheckDelay is how long the server waits after a change to the files of
+// a package before checking it, so that typing does not load the package
+// for every keystroke.
+var lspCheckDelay = 200 * time.Millisecond
+
+// lspServer is a language server for Wire. It publishes the errors found by
+// loading the package of each open file as diagnostics, and answers
+// definition and hover requests for the types of the parameters and results
+// of injectors and providers.
+//
+// Packages are checked in the background, so that requests are answered
+// while a package loads.
+type lspServer struct {
+	r        *bufio.Reader
+	tags     string
+	shutdown bool
+
+	// writeMu serializes the messages written to w.
+	writeMu sync.Mutex
+	w       io.Writer
+
+	// mu guards the fields below, which the checks update.
+	mu sync.Mutex
+	// files holds the contents of the open files, by absolute path, which
+	// are used instead of the files on disk.
+	files map[string][]byte
+	// infos holds the result of loading the package in each directory with
+	// an open file. It is nil if the package failed to load.
+	infos map[string]*wire.Info
+	// published holds the files that have diagnostics, by the directory of
+	// the package whose errors they are.
+	published map[string][]string
+	// checks holds the functions that cancel the latest check of the
+	// package in each directory.
+	checks map[string]context.CancelFunc
+	// running counts the checks that have not returned.
+	running sync.WaitGroup
+}
+
+func newLSPServer(r io.Reader, w io.Writer, tags string) *lspServer {
+	return &lspServer{
+		r:         bufio.NewReader(r),
+		w:         w,
+		tags:      tags,
+		files:     make(map[string][]byte),
+		infos:     make(map[string]*wire.Info),
+		published: make(map[string][]string),
+		checks:    make(map[string]context.CancelFunc),
+	}
+}
+
+// run serves requests until the client sends an exit notification. It
+// returns an error if the connection fails or the client exits without
+// shutting down the server first. The checks that are pending when it
+// returns are canceled.
+func (s *lspServer) run(ctx context.Context) error {
+	defer s.running.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for {
+		req, err := s.read()
+		if err != nil {
+			return err
+		}
+		if req == nil {
+			// The message could not be parsed.
+			if err := s.write(lspErrorResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &lspError{Code: lspParseError, Message: "invalid message"}}); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit before shutdown")
+			}
+			return nil
+		}
+		result, rerr := s.handle(ctx, req)
+		if len(req.ID) == 0 {
+			// Notifications have no response.
+			continue
+		}
+		if rerr != nil {
+			err = s.write(lspErrorResponse{JSONRPC: "2.0", ID: req.ID, Error: rerr})
+		} else {
+			err = s.write(lspResponse{JSONRPC: "2.0", ID: req.ID, Result: result})
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// read reads a message. It returns a nil request if the message is not
+// valid JSON.
+func (s *lspServer) read() (*lspRequest, error) {
+	header, err := textproto.NewReader(s.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid Content-Length %q", header.Get("Content-Length"))
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(s.r, body); err != nil {
+		return nil, err
+	}
+	req := new(lspRequest)
+	if err := json.Unmarshal(body, req); err != nil {
+		return nil, nil
+	}
+	return req, nil
+}
+
+func (s *lspServer) write(msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	_, err = fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// handle handles a request or notification and returns the result of a
+// request.
+func (s *lspServer) handle(ctx context.Context, req *lspRequest) (interface{}, *lspError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": map[string]interface{}{
+					"openClose": true,
+					"change":    lspSyncFull,
+					"save":      true,
+				},
+				"definitionProvider": true,
+				"hoverProvider":      true,
+			},
+			"serverInfo": map[string]string{"name": "wire"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen", "textDocument/didChange", "textDocument/didSave", "textDocument/didClose":
+		var params lspTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &lspError{Code: lspInvalidParams, Message: err.Error()}
+		}
+		path, ok := uriPath(params.TextDocument.URI)
+		if !ok {
+			return nil, nil
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		dir := filepath.Dir(path)
+		switch req.Method {
+		case "textDocument/didOpen":
+			s.files[path] = []byte(params.TextDocument.Text)
+		case "textDocument/didChange":
+			if n := len(params.ContentChanges); n > 0 {
+				s.files[path] = []byte(params.ContentChanges[n-1].Text)
+			}
+		case "textDocument/didClose":
+			delete(s.files, path)
+			if !s.hasOpenFile(dir) {
+				if err := s.forget(dir, path); err != nil {
+					log.Println(err)
+				}
+				return nil, nil
+			}
+		}
+		s.schedule(ctx, dir)
+		return nil, nil
+	case "textDocument/definition", "textDocument/hover":
+		var params lspTextDocumentPositionParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, &lspError{Code: lspInvalidParams, Message: err.Error()}
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		info, chain := s.providerAt(params.TextDocument.URI, params.Position)
+		if chain == nil {
+			return nil, nil
+		}
+		if req.Method == "textDocument/hover" {
+			return lspHover{Contents: lspMarkupContent{Kind: "markdown", Value: hoverText(chain)}}, nil
+		}
+		p := info.Fset.Position(chain.Steps[len(chain.Steps)-1].Pos)
+		return []lspLocation{{URI: fileURI(p.Filename), Range: s.lspRange(p)}}, nil
+	}
+	if len(req.ID) == 0 {
+		// Unknown notifications, such as $/cancelRequest, are ignored.
+		return nil, nil
+	}
+	return nil, &lspError{Code: lspMethodNotFound, Message: "method not found: " + req.Method}
+}
+
+// hasOpenFile reports whether a file in dir is open. s.mu must be held.
+func (s *lspServer) hasOpenFile(dir string) bool {
+	for path := range s.files {
+		if filepath.Dir(path) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// schedule checks the package in dir after lspCheckDelay, with the current
+// contents of the open files. It cancels the previous check of the package,
+// whose results would be out of date. s.mu must be held.
+func (s *lspServer) schedule(ctx context.Context, dir string) {
+	if cancel := s.checks[dir]; cancel != nil {
+		cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s.checks[dir] = cancel
+	overlay := make(map[string][]byte, len(s.files))
+	for path, content := range s.files {
+		overlay[path] = content
+	}
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer cancel()
+		timer := time.NewTimer(lspCheckDelay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		if err := s.check(ctx, dir, overlay); err != nil {
+			log.Println(err)
+		}
+	}()
+}
+
+// forget cancels the check of the package in dir, whose last open file at
+// path was closed, and clears the diagnostics of its files. s.mu must be
+// held.
+func (s *lspServer) forget(dir, path string) error {
+	if cancel := s.checks[dir]; cancel != nil {
+		cancel()
+	}
+	delete(s.checks, dir)
+	delete(s.infos, dir)
+	paths := s.published[dir]
+	delete(s.published, dir)
+	found := false
+	for _, p := range paths {
+		found = found || p == path
+	}
+	if !found {
+		paths = append(paths, path)
+	}
+	for _, p := range paths {
+		if err := s.publish(p, []lspDiagnostic{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check loads the package in dir with overlay and publishes its errors,
+// unless ctx is canceled first.
+func (s *lspServer) check(ctx context.Context, dir string, overlay map[string][]byte) error {
+	info, errs := wire.Load(ctx, dir, os.Environ(), s.tags, []string{"."}, overlay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ctx.Err() != nil {
+		// A newer check replaces this one, or the server exited.
+		return nil
+	}
+	s.infos[dir] = info
+	diags := make(map[string][]lspDiagnostic)
+	seen := make(map[string]bool)
+	for _, err := range errs {
+		p, err := wire.ErrorPosition(err)
+		if p.Filename == "" {
+			log.Println(err)
+			continue
+		}
+		// Load reports an error in a provider set once for each injector
+		// or set that includes it.
+		if k := p.String() + ": " + err.Error(); !seen[k] {
+			seen[k] = true
+			diags[p.Filename] = append(diags[p.Filename], lspDiagnostic{
+				Range:    s.lspRange(p),
+				Severity: lspSeverityError,
+				Source:   "wire",
+				Message:  err.Error(),
+			})
+		}
+	}
+	// Clear the diagnostics of files that no longer have any.
+	for _, path := range s.published[dir] {
+		if _, ok := diags[path]; !ok {
+			diags[path] = []lspDiagnostic{}
+		}
+	}
+	paths := make([]string, 0, len(diags))
+	for path := range diags {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	s.published[dir] = s.published[dir][:0]
+	for _, path := range paths {
+		if len(diags[path]) > 0 {
+			s.published[dir] = append(s.published[dir], path)
+		}
+		if err := s.publish(path, diags[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// publish publishes the diagnostics of the file at path.
+func (s *lspServer) publish(path string, diags []lspDiagnostic) error {
+	return s.write(lspNotification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params: map[string]interface{}{
+			"uri":         fileURI(path),
+			"diagnostics": diags,
+		},
+	})
+}
+
+// providerAt returns how the type at an LSP position is provided, along with
+// the loaded package it is in, or nil. s.mu must be held.
+func (s *lspServer) providerAt(uri string, pos lspPosition) (*wire.Info, *wire.ProviderChain) {
+	path, ok := uriPath(uri)
+	if !ok {
+		return nil, nil
+	}
+	info := s.infos[filepath.Dir(path)]
+	if info == nil {
+		return nil, nil
+	}
+	content, err := s.content(path)
+	if err != nil {
+		return nil, nil
+	}
+	return info, info.ProviderAt(path, lspOffset(content, pos))
+}
+
+// content returns the contents of the open file at path, or else of the file
+// on disk. s.mu must be held.
+func (s *lspServer) content(path string) ([]byte, error) {
+	if content, ok := s.files[path]; ok {
+		return content, nil
+	}
+	return os.ReadFile(path)
+}
+
+// lspRange returns the range of the identifier or other word at p, or an
+// empty range at p if there is none. s.mu must be held.
+func (s *lspServer) lspRange(p token.Position) lspRange {
+	content, err := s.content(p.Filename)
+	if err != nil {
+		pos := lspPosition{Line: p.Line - 1, Character: p.Column - 1}
+		return lspRange{Start: pos, End: pos}
+	}
+	line := lineAt(content, p.Line-1)
+	col := p.Column - 1
+	if col < 0 || col > len(line) {
+		col = 0
+	}
+	end := col
+	for end < len(line) {
+		r, size := utf8.DecodeRune(line[end:])
+		if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		end += size
+	}
+	return lspRange{
+		Start: lspPosition{Line: p.Line - 1, Character: utf16Len(line[:col])},
+		End:   lspPosition{Line: p.Line - 1, Character: utf16Len(line[:end])},
+	}
+}
+
+// lineAt returns the nth line of content, counting from zero, without its
+// line terminator.
+func lineAt(content []byte, n int) []byte {
+	for ; n > 0; n-- {
+		i := bytes.IndexByte(content, '\n')
+		if i == -1 {
+			return nil
+		}
+		content = content[i+1:]
+	}
+	if i := bytes.IndexByte(content, '\n'); i != -1 {
+		content = content[:i]
+	}
+	return bytes.TrimSuffix(content, []byte("\r"))
+}
+
+// lspOffset returns the byte offset in content of an LSP position, whose
+// character is counted in UTF-16 code units.
+func lspOffset(content []byte, pos lspPosition) int {
+	offset := 0
+	for n := pos.Line; n > 0; n-- {
+		i := bytes.IndexByte(content[offset:], '\n')
+		if i == -1 {
+			return len(content)
+		}
+		offset += i + 1
+	}
+	for units := 0; units < pos.Character && offset < len(content) && content[offset] != '\n'; {
+		r, size := utf8.DecodeRune(content[offset:])
+		units += len(utf16.Encode([]rune{r}))
+		offset += size
+	}
+	return offset
+}
+
+func utf16Len(b []byte) int {
+	return len(utf16.Encode([]rune(string(b))))
+}
+
+// uriPath returns the path of a file URI.
+func uriPath(uri string) (string, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	path := u.Path
+	if len(path) >= 3 && path[0] == '/' && path[2] == ':' {
+		// Windows paths start with a drive letter.
+		path = path[1:]
+	}
+	return filepath.FromSlash(path), true
+}
+
+// hoverText describes chain in Markdown.
+func hoverText(chain *wire.ProviderChain) string {
+	sb := new(strings.Builder)
+	write := func(verb string, step wire.WhyStep) {
+		fmt.Fprintf(sb, "`%s` %s %s", wire.TypeString(step.Type), verb, step.Trace[0])
+		for _, imp := range step.Trace[1:] {
+			fmt.Fprintf(sb, "  \nimported through %s", imp)
+		}
+		sb.WriteString("\n\n")
+	}
+	for _, step := range chain.Steps {
+		write("from", step)
+	}
+	for _, step := range chain.Decorators {
+		write("decorated by", step)
+	}
+	return strings.TrimSuffix(sb.String(), "\n\n")
+}
@@ -0,0 +1,435 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lspClient talks to an lspServer through in-memory pipes.
+type lspClient struct {
+	t    *testing.T
+	w    *io.PipeWriter
+	r    *bufio.Reader
+	done chan error
+}
+
+// lspMessage is a message sent by the server: a response or a notification.
+type lspMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *lspError       `json:"error"`
+}
+
+// startLSP runs an lspServer until the client sends exit or the test ends.
+func startLSP(t *testing.T) *lspClient {
+	t.Helper()
+	// Load runs the go command, which must not download modules.
+	t.Setenv("GOPROXY", "off")
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &lspClient{t: t, w: inW, r: bufio.NewReader(outR), done: make(chan error, 1)}
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	go func() {
+		err := newLSPServer(inR, outW, "").run(ctx)
+		outW.Close()
+		c.done <- err
+	}()
+	t.Cleanup(func() {
+		cancel()
+		inW.Close()
+		outR.Close()
+	})
+	return c
+}
+
+// sendRaw writes body framed by a Content-Length header.
+func (c *lspClient) sendRaw(body string) {
+	c.t.Helper()
+	// A Content-Type header may precede the body, and is ignored.
+	msg := fmt.Sprintf("Content-Length: %d\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n%s", len(body), body)
+	if _, err := io.WriteString(c.w, msg); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+}
+
+// send sends a request, or a notification if id is 0.
+func (c *lspClient) send(id int, method string, params interface{}) {
+	c.t.Helper()
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method}
+	if id != 0 {
+		msg["id"] = id
+	}
+	if params != nil {
+		msg["params"] = params
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.sendRaw(string(body))
+}
+
+// read reads the next message from the server, checking its framing.
+func (c *lspClient) read() *lspMessage {
+	c.t.Helper()
+	header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatalf("read header: %v", err)
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		c.t.Fatalf("Content-Length = %q: %v", header.Get("Content-Length"), err)
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		c.t.Fatalf("read body of %d bytes: %v", n, err)
+	}
+	msg := new(lspMessage)
+	if err := json.Unmarshal(body, msg); err != nil {
+		c.t.Fatalf("body is not the JSON of a single message: %v\n%s", err, body)
+	}
+	return msg
+}
+
+// call sends a request and returns its response.
+func (c *lspClient) call(id int, method string, params interface{}) *lspMessage {
+	c.t.Helper()
+	c.send(id, method, params)
+	msg := c.read()
+	if string(msg.ID) != strconv.Itoa(id) {
+		c.t.Fatalf("got message %+v; want the response to request %d", msg, id)
+	}
+	return msg
+}
+
+// diagnostics reads the diagnostics published for the file at path.
+func (c *lspClient) diagnostics(path string) []lspDiagnostic {
+	c.t.Helper()
+	msg := c.read()
+	if msg.Method != "textDocument/publishDiagnostics" {
+		c.t.Fatalf("got message %+v; want publishDiagnostics", msg)
+	}
+	var params struct {
+		URI         string          `json:"uri"`
+		Diagnostics []lspDiagnostic `json:"diagnostics"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		c.t.Fatal(err)
+	}
+	if params.URI != fileURI(path) {
+		c.t.Fatalf("diagnostics published for %s; want %s", params.URI, fileURI(path))
+	}
+	if params.Diagnostics == nil {
+		c.t.Fatalf("diagnostics of %s are null; want an array", params.URI)
+	}
+	return params.Diagnostics
+}
+
+// exit shuts the server down and waits for it to return.
+func (c *lspClient) exit(id int) {
+	c.t.Helper()
+	if msg := c.call(id, "shutdown", nil); msg.Error != nil || string(msg.Result) != "null" {
+		c.t.Errorf("shutdown returned %s, %v; want null", msg.Result, msg.Error)
+	}
+	c.send(0, "exit", nil)
+	if err := <-c.done; err != nil {
+		c.t.Errorf("run returned %v after exit", err)
+	}
+}
+
+// positionOf returns the LSP position of the first occurrence of substr in
+// content, moved by offset bytes.
+func positionOf(t *testing.T, content, substr string, offset int) lspPosition {
+	t.Helper()
+	i := strings.Index(content, substr)
+	if i == -1 {
+		t.Fatalf("%q not found", substr)
+	}
+	i += offset
+	line := strings.Count(content[:i], "\n")
+	return lspPosition{Line: line, Character: i - strings.LastIndex(content[:i], "\n") - 1}
+}
+
+const (
+	lspProviders = `package wiretest
+
+type DB struct{}
+
+func NewDB() *DB { return &DB{} }
+
+type Server struct{ DB *DB }
+
+func NewServer(db *DB) *Server { return &Server{DB: db} }
+`
+	lspInjector = `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import "github.com/almondoo/wire"
+
+func InitializeServer() *Server {
+	wire.Build(NewDB, NewServer)
+	return nil
+}
+`
+	// lspBrokenInjector is the injector after an edit that is not saved,
+	// which leaves out the provider of *DB.
+	lspBrokenInjector = `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import "github.com/almondoo/wire"
+
+func InitializeServer() *Server {
+	wire.Build(NewServer)
+	return nil
+}
+`
+)
+
+func TestLSP(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		"providers.go": lspProviders,
+		"wire.go":      lspInjector,
+	})
+	wirePath := filepath.Join(dir, "wire.go")
+	providersPath := filepath.Join(dir, "providers.go")
+	c := startLSP(t)
+
+	msg := c.call(1, "initialize", map[string]interface{}{"processId": nil, "rootUri": fileURI(dir)})
+	var init struct {
+		Capabilities struct {
+			TextDocumentSync struct {
+				OpenClose bool `json:"openClose"`
+				Change    int  `json:"change"`
+			} `json:"textDocumentSync"`
+			DefinitionProvider bool `json:"definitionProvider"`
+			HoverProvider      bool `json:"hoverProvider"`
+		} `json:"capabilities"`
+		ServerInfo struct {
+			Name string `json:"name"`
+		} `json:"serverInfo"`
+	}
+	if err := json.Unmarshal(msg.Result, &init); err != nil {
+		t.Fatal(err)
+	}
+	caps := init.Capabilities
+	if !caps.TextDocumentSync.OpenClose || caps.TextDocumentSync.Change != lspSyncFull || !caps.DefinitionProvider || !caps.HoverProvider || init.ServerInfo.Name != "wire" {
+		t.Errorf("initialize returned %s; want full sync, definition and hover", msg.Result)
+	}
+	c.send(0, "initialized", map[string]interface{}{})
+
+	// The unsaved contents of the open file are checked instead of the file
+	// on disk, which has no errors.
+	c.send(0, "textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": fileURI(wirePath), "languageId": "go", "version": 1, "text": lspBrokenInjector},
+	})
+	diags := c.diagnostics(wirePath)
+	if len(diags) != 1 {
+		t.Fatalf("got %d diagnostics for the open file; want 1: %+v", len(diags), diags)
+	}
+	d := diags[0]
+	wantStart := positionOf(t, lspBrokenInjector, "func InitializeServer", 0)
+	wantRange := lspRange{Start: wantStart, End: lspPosition{Line: wantStart.Line, Character: wantStart.Character + len("func")}}
+	if d.Range != wantRange || d.Severity != lspSeverityError || d.Source != "wire" || !strings.Contains(d.Message, "no provider found for *example.com/wiretest.DB") {
+		t.Errorf("diagnostic = %+v; want the missing *DB at %+v", d, wantRange)
+	}
+
+	// Fixing the file clears its diagnostics.
+	c.send(0, "textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": fileURI(wirePath), "version": 2},
+		"contentChanges": []map[string]string{{"text": lspInjector}},
+	})
+	if diags := c.diagnostics(wirePath); len(diags) != 0 {
+		t.Errorf("got diagnostics %+v after the fix; want none", diags)
+	}
+
+	// *Server in the result of the injector is provided by NewServer.
+	serverPos := positionOf(t, lspInjector, "*Server {", 1)
+	params := map[string]interface{}{
+		"textDocument": map[string]string{"uri": fileURI(wirePath)},
+		"position":     serverPos,
+	}
+	msg = c.call(2, "textDocument/definition", params)
+	var locs []lspLocation
+	if err := json.Unmarshal(msg.Result, &locs); err != nil {
+		t.Fatalf("definition returned %s: %v", msg.Result, err)
+	}
+	defStart := positionOf(t, lspProviders, "NewServer(", 0)
+	wantLoc := lspLocation{
+		URI:   fileURI(providersPath),
+		Range: lspRange{Start: defStart, End: lspPosition{Line: defStart.Line, Character: defStart.Character + len("NewServer")}},
+	}
+	if len(locs) != 1 || locs[0] != wantLoc {
+		t.Errorf("definition = %+v; want %+v", locs, wantLoc)
+	}
+
+	msg = c.call(3, "textDocument/hover", params)
+	var hover lspHover
+	if err := json.Unmarshal(msg.Result, &hover); err != nil {
+		t.Fatalf("hover returned %s: %v", msg.Result, err)
+	}
+	if hover.Contents.Kind != "markdown" || !strings.HasPrefix(hover.Contents.Value, "`*example.com/wiretest.Server` from provider \"NewServer\"") {
+		t.Errorf("hover = %+v; want the provider of *Server in Markdown", hover.Contents)
+	}
+
+	// Nothing is provided at the package clause.
+	params["position"] = lspPosition{Line: 3, Character: 0}
+	if msg := c.call(4, "textDocument/hover", params); string(msg.Result) != "null" {
+		t.Errorf("hover at the package clause = %s; want null", msg.Result)
+	}
+
+	// Closing the last open file of the package clears its diagnostics.
+	c.send(0, "textDocument/didClose", map[string]interface{}{
+		"textDocument": map[string]string{"uri": fileURI(wirePath)},
+	})
+	if diags := c.diagnostics(wirePath); len(diags) != 0 {
+		t.Errorf("got diagnostics %+v after close; want none", diags)
+	}
+
+	c.exit(5)
+}
+
+func TestLSPStaleCheck(t *testing.T) {
+	dir := writeTestModule(t, map[string]string{
+		"providers.go": lspProviders,
+		"wire.go":      lspInjector,
+	})
+	wirePath := filepath.Join(dir, "wire.go")
+	providersPath := filepath.Join(dir, "providers.go")
+	c := startLSP(t)
+
+	// A change made before the package is checked replaces the check of the
+	// previous contents, so only the diagnostics of the latest contents are
+	// published.
+	c.send(0, "textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": fileURI(wirePath), "languageId": "go", "version": 1, "text": lspBrokenInjector},
+	})
+	edited := "// Edited.\n" + lspBrokenInjector
+	c.send(0, "textDocument/didChange", map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": fileURI(wirePath), "version": 2},
+		"contentChanges": []map[string]string{{"text": edited}},
+	})
+	diags := c.diagnostics(wirePath)
+	if want := positionOf(t, edited, "func InitializeServer", 0); len(diags) != 1 || diags[0].Range.Start != want {
+		t.Fatalf("got diagnostics %+v; want one at %+v in the latest contents", diags, want)
+	}
+
+	// Requests are answered while a package is checked. Closing the last
+	// open file of the package cancels its pending check, so the cleared
+	// diagnostics are the last ones published.
+	c.send(0, "textDocument/didOpen", map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": fileURI(providersPath), "languageId": "go", "version": 1, "text": "package wiretest\n"},
+	})
+	if msg := c.call(1, "textDocument/hover", map[string]interface{}{
+		"textDocument": map[string]string{"uri": fileURI(wirePath)},
+		"position":     lspPosition{Line: 3, Character: 0},
+	}); string(msg.Result) != "null" {
+		t.Errorf("hover at the package clause = %s; want null", msg.Result)
+	}
+	c.send(0, "textDocument/didClose", map[string]interface{}{
+		"textDocument": map[string]string{"uri": fileURI(providersPath)},
+	})
+	c.send(0, "textDocument/didClose", map[string]interface{}{
+		"textDocument": map[string]string{"uri": fileURI(wirePath)},
+	})
+	if diags := c.diagnostics(wirePath); len(diags) != 0 {
+		t.Errorf("got diagnostics %+v after close; want none", diags)
+	}
+
+	c.exit(2)
+}
+
+func TestLSPErrors(t *testing.T) {
+	c := startLSP(t)
+
+	// A body that is not JSON gets a parse error with a null ID, and the
+	// server keeps reading.
+	c.sendRaw("{not json")
+	msg := c.read()
+	if string(msg.ID) != "null" || msg.Error == nil || msg.Error.Code != lspParseError {
+		t.Errorf("response to invalid JSON = %+v; want a parse error", msg)
+	}
+
+	msg = c.call(1, "workspace/symbol", map[string]string{"query": "x"})
+	if msg.Error == nil || msg.Error.Code != lspMethodNotFound {
+		t.Errorf("response to an unknown method = %+v; want method not found", msg)
+	}
+
+	msg = c.call(2, "textDocument/hover", "not params")
+	if msg.Error == nil || msg.Error.Code != lspInvalidParams {
+		t.Errorf("response to invalid params = %+v; want invalid params", msg)
+	}
+
+	// Unknown notifications get no response, so the next message is the
+	// response to shutdown.
+	c.send(0, "$/cancelRequest", map[string]int{"id": 1})
+	c.exit(3)
+}
+
+func TestLSPExitWithoutShutdown(t *testing.T) {
+	c := startLSP(t)
+	c.send(0, "exit", nil)
+	if err := <-c.done; err == nil {
+		t.Error("run returned nil after exit without shutdown; want an error")
+	}
+}
+
+func TestLSPInvalidContentLength(t *testing.T) {
+	c := startLSP(t)
+	if _, err := io.WriteString(c.w, "Content-Length: x\r\n\r\n{}"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-c.done; err == nil || !strings.Contains(err.Error(), "Content-Length") {
+		t.Errorf("run returned %v; want an invalid Content-Length error", err)
+	}
+}
+
+func TestLSPOffset(t *testing.T) {
+	// "é" is one UTF-16 code unit and two bytes, and "😀" is two UTF-16
+	// code units and four bytes.
+	content := []byte("package p\r\nvar é, 😀, x = 1, 2, 3\n")
+	tests := []struct {
+		pos  lspPosition
+		want int
+	}{
+		{lspPosition{Line: 0, Character: 0}, 0},
+		{lspPosition{Line: 1, Character: 4}, 15},
+		{lspPosition{Line: 1, Character: 7}, 19},
+		{lspPosition{Line: 1, Character: 11}, 25},
+		// Positions past the end of a line are at its end.
+		{lspPosition{Line: 1, Character: 100}, 36},
+		{lspPosition{Line: 5, Character: 0}, 37},
+	}
+	for _, test := range tests {
+		if got := lspOffset(content, test.pos); got != test.want {
+			t.Errorf("lspOffset(%+v) = %d; want %d", test.pos, got, test.want)
+		}
+	}
+}
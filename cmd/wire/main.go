@@ -19,12 +19,14 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"go/token"
 	"go/types"
 	"log"
 	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
 	"strconv"
@@ -40,10 +42,15 @@ func main() {
 	subcommands.Register(subcommands.CommandsCommand(), "")
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.HelpCommand(), "")
+	subcommands.Register(&cacheCmd{}, "")
 	subcommands.Register(&checkCmd{}, "")
 	subcommands.Register(&diffCmd{}, "")
 	subcommands.Register(&genCmd{}, "")
+	subcommands.Register(&graphCmd{}, "")
+	subcommands.Register(&lspCmd{}, "")
 	subcommands.Register(&showCmd{}, "")
+	subcommands.Register(&unusedCmd{}, "")
+	subcommands.Register(&whyCmd{}, "")
 	flag.Parse()
 
 	// Initialize the default logger to log to stderr.
@@ -59,10 +66,15 @@ func main() {
 		"commands": true, // builtin
 		"help":     true, // builtin
 		"flags":    true, // builtin
+		"cache":    true,
 		"check":    true,
 		"diff":     true,
 		"gen":      true,
+		"graph":    true,
+		"lsp":      true,
 		"show":     true,
+		"unused":   true,
+		"why":      true,
 	}
 	// Default to running the "gen" command.
 	if args := flag.Args(); len(args) == 0 || !allCmds[args[0]] {
@@ -83,9 +95,18 @@ func packages(f *flag.FlagSet) []string {
 }
 
 // newGenerateOptions returns an initialized wire.GenerateOptions, possibly
-// with the Header option set.
-func newGenerateOptions(headerFile string) (*wire.GenerateOptions, error) {
+// with the Header, Overlay and CacheDir options set. cache is the value of
+// the -cache flag.
+func newGenerateOptions(headerFile, overlayFile, cache string) (*wire.GenerateOptions, error) {
 	opts := new(wire.GenerateOptions)
+	switch cache {
+	case "", "on":
+		// Generate without the cache if there is no user cache directory.
+		opts.CacheDir, _ = wire.DefaultCacheDir()
+	case "off":
+	default:
+		return nil, fmt.Errorf("unknown -cache %q; want on or off", cache)
+	}
 	if headerFile != "" {
 		var err error
 		opts.Header, err = os.ReadFile(headerFile)
@@ -93,13 +114,80 @@ func newGenerateOptions(headerFile string) (*wire.GenerateOptions, error) {
 			return nil, fmt.Errorf("failed to read header file %q: %v", headerFile, err)
 		}
 	}
+	overlay, err := readOverlay(overlayFile)
+	if err != nil {
+		return nil, err
+	}
+	opts.Overlay = overlay
 	return opts, nil
 }
 
+// cacheUsage is the usage of the -cache flag.
+const cacheUsage = "reuse the code generated before for packages that did not change: on or off; entries unused for 5 days are removed, and wire cache clean removes them all"
+
+// jobsUsage is the usage of the -j flag.
+const jobsUsage = "number of packages to generate concurrently; 0 means GOMAXPROCS"
+
+// overlayUsage is the usage of the -overlay flag.
+const overlayUsage = "JSON file that replaces the contents of files, in the format of go build -overlay"
+
+// readOverlay reads the overlay file given to the -overlay flag, which has
+// the format of the -overlay flag of go build:
+//
+//	{"Replace": {"path/to/file.go": "path/to/replacement.go"}}
+//
+// It returns the contents of the replacements by the absolute paths of the
+// files they replace, or nil if path is empty.
+func readOverlay(path string) (map[string][]byte, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read overlay file %q: %v", path, err)
+	}
+	var file struct {
+		Replace map[string]string
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse overlay file %q: %v", path, err)
+	}
+	overlay := make(map[string][]byte, len(file.Replace))
+	for name, replacement := range file.Replace {
+		if replacement == "" {
+			return nil, fmt.Errorf("overlay file %q deletes %s, which is not supported", path, name)
+		}
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return nil, err
+		}
+		overlay[abs], err = os.ReadFile(replacement)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read overlay replacement for %s: %v", name, err)
+		}
+	}
+	return overlay, nil
+}
+
+// readGenerated returns the current contents of a wire_gen.go file, from
+// overlay if it replaces the file. It assumes the file is empty if it can't
+// read it.
+func readGenerated(overlay map[string][]byte, path string) []byte {
+	if content, ok := overlay[path]; ok {
+		return content
+	}
+	content, _ := os.ReadFile(path)
+	return content
+}
+
 type genCmd struct {
 	headerFile     string
 	prefixFileName string
 	tags           string
+	wrapErrors     bool
+	overlay        string
+	jobs           int
+	cache          string
 }
 
 func (*genCmd) Name() string { return "gen" }
@@ -118,6 +206,10 @@ func (cmd *genCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&cmd.headerFile, "header_file", "", "path to file to insert as a header in wire_gen.go")
 	f.StringVar(&cmd.prefixFileName, "output_file_prefix", "", "string to prepend to output file names.")
 	f.StringVar(&cmd.tags, "tags", "", "append build tags to the default wirebuild")
+	f.BoolVar(&cmd.wrapErrors, "wrap_errors", false, "wrap errors from providers with the provider name in generated injectors")
+	f.StringVar(&cmd.overlay, "overlay", "", overlayUsage)
+	f.IntVar(&cmd.jobs, "j", 0, jobsUsage)
+	f.StringVar(&cmd.cache, "cache", "on", cacheUsage)
 }
 
 func (cmd *genCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
@@ -126,7 +218,7 @@ func (cmd *genCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interfa
 		log.Println("failed to get working directory: ", err)
 		return subcommands.ExitFailure
 	}
-	opts, err := newGenerateOptions(cmd.headerFile)
+	opts, err := newGenerateOptions(cmd.headerFile, cmd.overlay, cmd.cache)
 	if err != nil {
 		log.Println(err)
 		return subcommands.ExitFailure
@@ -134,6 +226,8 @@ func (cmd *genCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interfa
 
 	opts.PrefixOutputFile = cmd.prefixFileName
 	opts.Tags = cmd.tags
+	opts.WrapErrors = cmd.wrapErrors
+	opts.Jobs = cmd.jobs
 
 	outs, errs := wire.Generate(ctx, wd, os.Environ(), packages(f), opts)
 	if len(errs) > 0 {
@@ -172,6 +266,10 @@ func (cmd *genCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interfa
 type diffCmd struct {
 	headerFile string
 	tags       string
+	wrapErrors bool
+	format     string
+	overlay    string
+	jobs       int
 }
 
 func (*diffCmd) Name() string { return "diff" }
@@ -188,31 +286,52 @@ func (*diffCmd) Usage() string {
 
   Similar to the diff command, it returns 0 if no diff, 1 if different, 2
   plus an error if trouble.
+
+  With -format=sarif or -format=github, it prints the errors and the
+  out-of-date files as a SARIF 2.1.0 log or as GitHub Actions workflow
+  commands instead of printing the diffs.
 `
 }
 func (cmd *diffCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&cmd.headerFile, "header_file", "", "path to file to insert as a header in wire_gen.go")
 	f.StringVar(&cmd.tags, "tags", "", "append build tags to the default wirebuild")
+	f.BoolVar(&cmd.wrapErrors, "wrap_errors", false, "wrap errors from providers with the provider name in generated injectors")
+	f.StringVar(&cmd.format, "format", "text", "output format: text, sarif or github")
+	f.StringVar(&cmd.overlay, "overlay", "", overlayUsage)
+	f.IntVar(&cmd.jobs, "j", 0, jobsUsage)
 }
 func (cmd *diffCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
 	const (
 		errReturn  = subcommands.ExitStatus(2)
 		diffReturn = subcommands.ExitStatus(1)
 	)
+	switch cmd.format {
+	case "text", "sarif", "github":
+	default:
+		log.Printf("unknown format %q; want text, sarif or github\n", cmd.format)
+		return subcommands.ExitUsageError
+	}
 	wd, err := os.Getwd()
 	if err != nil {
 		log.Println("failed to get working directory: ", err)
 		return errReturn
 	}
-	opts, err := newGenerateOptions(cmd.headerFile)
+	// diff checks the files against what gen would generate now, so it does
+	// not trust code generated before.
+	opts, err := newGenerateOptions(cmd.headerFile, cmd.overlay, "off")
 	if err != nil {
 		log.Println(err)
-		return subcommands.ExitFailure
+		return errReturn
 	}
 
 	opts.Tags = cmd.tags
+	opts.WrapErrors = cmd.wrapErrors
+	opts.Jobs = cmd.jobs
 
 	outs, errs := wire.Generate(ctx, wd, os.Environ(), packages(f), opts)
+	if cmd.format != "text" {
+		return cmd.writeDiagnostics(wd, opts.Overlay, outs, errs)
+	}
 	if len(errs) > 0 {
 		logErrors(errs)
 		log.Println("generate failed")
@@ -233,8 +352,7 @@ func (cmd *diffCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interf
 			// No Wire output. Maybe errors, maybe no Wire directives.
 			continue
 		}
-		// Assumes the current file is empty if we can't read it.
-		cur, _ := os.ReadFile(out.OutputPath)
+		cur := readGenerated(opts.Overlay, out.OutputPath)
 		if diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
 			A: difflib.SplitLines(string(cur)),
 			B: difflib.SplitLines(string(out.Content)),
@@ -259,8 +377,81 @@ func (cmd *diffCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interf
 	return subcommands.ExitSuccess
 }
 
+// writeDiagnostics prints the errors and out-of-date files of a diff in
+// cmd.format, returning the same exit status as a text diff.
+func (cmd *diffCmd) writeDiagnostics(wd string, overlay map[string][]byte, outs []wire.GenerateResult, errs []error) subcommands.ExitStatus {
+	diags := errorDiagnostics(errs)
+	failed := len(errs) > 0
+	hadDiff := false
+	for _, out := range outs {
+		diags = append(diags, errorDiagnostics(out.Errs)...)
+		failed = failed || len(out.Errs) > 0
+		if len(out.Content) == 0 {
+			continue
+		}
+		cur := readGenerated(overlay, out.OutputPath)
+		if string(cur) != string(out.Content) {
+			diags = append(diags, diagnostic{
+				rule:    outOfDateRule,
+				pos:     token.Position{Filename: out.OutputPath},
+				message: fmt.Sprintf("%s is out of date; run wire gen to update it", filepath.Base(out.OutputPath)),
+			})
+			hadDiff = true
+		}
+	}
+	if err := writeDiagnostics(os.Stdout, cmd.format, wd, diags); err != nil {
+		log.Println(err)
+		return subcommands.ExitStatus(2)
+	}
+	switch {
+	case failed:
+		return subcommands.ExitStatus(2)
+	case hadDiff:
+		return subcommands.ExitStatus(1)
+	default:
+		return subcommands.ExitSuccess
+	}
+}
+
+type cacheCmd struct{}
+
+func (*cacheCmd) Name() string { return "cache" }
+func (*cacheCmd) Synopsis() string {
+	return "manage the cache of generated code"
+}
+func (*cacheCmd) Usage() string {
+	return `cache clean
+
+  gen caches the code it generates for each package in the wire directory
+  of the user cache directory, and reuses it while the files of the package
+  and of its dependencies, the flags and the go environment do not change.
+  diff never uses the cache. Once a day, gen removes the entries that were
+  not used in the last five days, like the go build cache. cache clean
+  removes the whole cache.
+`
+}
+func (*cacheCmd) SetFlags(*flag.FlagSet) {}
+func (*cacheCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if f.NArg() != 1 || f.Arg(0) != "clean" {
+		log.Println("usage: wire cache clean")
+		return subcommands.ExitUsageError
+	}
+	dir, err := wire.DefaultCacheDir()
+	if err != nil {
+		log.Println("failed to find the cache directory: ", err)
+		return subcommands.ExitFailure
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Println("failed to remove the cache: ", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
 type showCmd struct {
-	tags string
+	tags    string
+	format  string
+	overlay string
 }
 
 func (*showCmd) Name() string { return "show" }
@@ -268,38 +459,53 @@ func (*showCmd) Synopsis() string {
 	return "describe all top-level provider sets"
 }
 func (*showCmd) Usage() string {
-	return `show [packages]
+	return `show [-format text|json] [packages]
 
   Given one or more packages, show finds all the provider sets declared as
   top-level variables and prints what other provider sets they import and what
-  outputs they can produce, given possible inputs. It also lists any injector
-  functions defined in the package.
+  outputs they can produce, given possible inputs. For provider sets created
+  with wire.Override, it also prints which providers were replaced. It also
+  lists any injector functions defined in the package.
+
+  With -format=json, it prints the same information and any errors as a JSON
+  object, described in the user guide.
 
   If no packages are listed, it defaults to ".".
 `
 }
 func (cmd *showCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&cmd.tags, "tags", "", "append build tags to the default wirebuild")
+	f.StringVar(&cmd.format, "format", "text", "output format: text or json")
+	f.StringVar(&cmd.overlay, "overlay", "", overlayUsage)
 }
 func (cmd *showCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if cmd.format != "text" && cmd.format != "json" {
+		log.Printf("unknown format %q; want text or json\n", cmd.format)
+		return subcommands.ExitUsageError
+	}
 	wd, err := os.Getwd()
 	if err != nil {
 		log.Println("failed to get working directory: ", err)
 		return subcommands.ExitFailure
 	}
-	info, errs := wire.Load(ctx, wd, os.Environ(), cmd.tags, packages(f))
-	if info != nil {
-		keys := make([]wire.ProviderSetID, 0, len(info.Sets))
-		for k := range info.Sets {
-			keys = append(keys, k)
+	overlay, err := readOverlay(cmd.overlay)
+	if err != nil {
+		log.Println(err)
+		return subcommands.ExitFailure
+	}
+	info, errs := wire.Load(ctx, wd, os.Environ(), cmd.tags, packages(f), overlay)
+	if cmd.format == "json" {
+		if err := writeJSON(os.Stdout, showReport(info, errs)); err != nil {
+			log.Println(err)
+			return subcommands.ExitFailure
 		}
-		sort.Slice(keys, func(i, j int) bool {
-			if keys[i].ImportPath == keys[j].ImportPath {
-				return keys[i].VarName < keys[j].VarName
-			}
-			return keys[i].ImportPath < keys[j].ImportPath
-		})
-		for i, k := range keys {
+		if len(errs) > 0 {
+			return subcommands.ExitFailure
+		}
+		return subcommands.ExitSuccess
+	}
+	if info != nil {
+		for i, k := range sortedSetIDs(info) {
 			if i > 0 {
 				fmt.Println()
 			}
@@ -312,33 +518,25 @@ func (cmd *showCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interf
 				fmt.Printf("\tOutputs given %s:\n", outGroups[i].name)
 				out := make(map[string]token.Pos, outGroups[i].outputs.Len())
 				outGroups[i].outputs.Iterate(func(t types.Type, v interface{}) {
-					switch v := v.(type) {
-					case *wire.Provider:
-						out[types.TypeString(t, nil)] = v.Pos
-					case *wire.Value:
-						out[types.TypeString(t, nil)] = v.Pos
-					case *wire.Field:
-						out[types.TypeString(t, nil)] = v.Pos
-					default:
-						panic("unreachable")
-					}
+					out[wire.TypeString(t)] = outputPos(v)
 				})
 				for _, t := range sortSet(out) {
 					fmt.Printf("\t\t%s\n", t)
 					fmt.Printf("\t\t\tat %v\n", info.Fset.Position(out[t]))
 				}
 			}
+			if overrides := info.Sets[k].Overrides; len(overrides) > 0 {
+				fmt.Println("\tOverrides:")
+				for _, o := range overrides {
+					fmt.Printf("\t\t%s\n", wire.TypeString(o.Type))
+					fmt.Printf("\t\t\treplaced %v\n", info.Fset.Position(o.Replaced))
+					fmt.Printf("\t\t\tby %v\n", info.Fset.Position(o.By))
+				}
+			}
 		}
 		if len(info.Injectors) > 0 {
-			injectors := append([]*wire.Injector(nil), info.Injectors...)
-			sort.Slice(injectors, func(i, j int) bool {
-				if injectors[i].ImportPath == injectors[j].ImportPath {
-					return injectors[i].FuncName < injectors[j].FuncName
-				}
-				return injectors[i].ImportPath < injectors[j].ImportPath
-			})
 			fmt.Println("\nInjectors:")
-			for _, in := range injectors {
+			for _, in := range sortedInjectors(info) {
 				fmt.Printf("\t%v\n", in)
 			}
 		}
@@ -351,8 +549,57 @@ func (cmd *showCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interf
 	return subcommands.ExitSuccess
 }
 
+// sortedSetIDs returns the IDs of the provider sets of info, sorted by
+// import path and name.
+func sortedSetIDs(info *wire.Info) []wire.ProviderSetID {
+	keys := make([]wire.ProviderSetID, 0, len(info.Sets))
+	for k := range info.Sets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ImportPath == keys[j].ImportPath {
+			return keys[i].VarName < keys[j].VarName
+		}
+		return keys[i].ImportPath < keys[j].ImportPath
+	})
+	return keys
+}
+
+// sortedInjectors returns the injectors of info, sorted by import path and
+// name.
+func sortedInjectors(info *wire.Info) []*wire.Injector {
+	injectors := append([]*wire.Injector(nil), info.Injectors...)
+	sort.Slice(injectors, func(i, j int) bool {
+		if injectors[i].ImportPath == injectors[j].ImportPath {
+			return injectors[i].FuncName < injectors[j].FuncName
+		}
+		return injectors[i].ImportPath < injectors[j].ImportPath
+	})
+	return injectors
+}
+
+// outputPos returns the position of a value of an outGroup's outputs.
+func outputPos(v interface{}) token.Pos {
+	switch v := v.(type) {
+	case *wire.Provider:
+		return v.Pos
+	case *wire.Value:
+		return v.Pos
+	case *wire.Field:
+		return v.Pos
+	case *wire.Multibinding:
+		return v.Pos
+	case *wire.Optional:
+		return v.Pos
+	default:
+		panic("unreachable")
+	}
+}
+
 type checkCmd struct {
-	tags string
+	tags    string
+	format  string
+	overlay string
 }
 
 func (*checkCmd) Name() string { return "check" }
@@ -360,24 +607,57 @@ func (*checkCmd) Synopsis() string {
 	return "print any Wire errors found"
 }
 func (*checkCmd) Usage() string {
-	return `check [-tags tag,list] [packages]
+	return `check [-tags tag,list] [-format text|json|sarif|github] [packages]
 
   Given one or more packages, check prints any type-checking or Wire errors
   found with top-level variable provider sets or injector functions.
 
+  With -format=json, it prints the errors as a JSON object, described in the
+  user guide. With -format=sarif, it prints them as a SARIF 2.1.0 log for
+  code scanning tools, and with -format=github, as GitHub Actions workflow
+  commands that annotate the lines with errors.
+
   If no packages are listed, it defaults to ".".
 `
 }
 func (cmd *checkCmd) SetFlags(f *flag.FlagSet) {
 	f.StringVar(&cmd.tags, "tags", "", "append build tags to the default wirebuild")
+	f.StringVar(&cmd.format, "format", "text", "output format: text, json, sarif or github")
+	f.StringVar(&cmd.overlay, "overlay", "", overlayUsage)
 }
 func (cmd *checkCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	switch cmd.format {
+	case "text", "json", "sarif", "github":
+	default:
+		log.Printf("unknown format %q; want text, json, sarif or github\n", cmd.format)
+		return subcommands.ExitUsageError
+	}
 	wd, err := os.Getwd()
 	if err != nil {
 		log.Println("failed to get working directory: ", err)
 		return subcommands.ExitFailure
 	}
-	_, errs := wire.Load(ctx, wd, os.Environ(), cmd.tags, packages(f))
+	overlay, err := readOverlay(cmd.overlay)
+	if err != nil {
+		log.Println(err)
+		return subcommands.ExitFailure
+	}
+	_, errs := wire.Load(ctx, wd, os.Environ(), cmd.tags, packages(f), overlay)
+	if cmd.format != "text" {
+		if cmd.format == "json" {
+			err = writeJSON(os.Stdout, checkReport(errs))
+		} else {
+			err = writeDiagnostics(os.Stdout, cmd.format, wd, errorDiagnostics(errs))
+		}
+		if err != nil {
+			log.Println(err)
+			return subcommands.ExitFailure
+		}
+		if len(errs) > 0 {
+			return subcommands.ExitFailure
+		}
+		return subcommands.ExitSuccess
+	}
 	if len(errs) > 0 {
 		logErrors(errs)
 		log.Println("error loading packages")
@@ -386,10 +666,331 @@ func (cmd *checkCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...inter
 	return subcommands.ExitSuccess
 }
 
+type graphCmd struct {
+	tags      string
+	format    string
+	name      string
+	collapse  bool
+	highlight string
+	overlay   string
+}
+
+func (*graphCmd) Name() string { return "graph" }
+func (*graphCmd) Synopsis() string {
+	return "draw the dependency graph of a provider set or injector"
+}
+func (*graphCmd) Usage() string {
+	return `graph -for name [-format dot|mermaid] [-collapse] [-highlight type] [packages]
+
+  Given one or more packages, graph prints the dependency graph of the
+  provider set or injector function with the given name, as a Graphviz DOT
+  digraph or a Mermaid flowchart. Providers, values, fields, interface
+  bindings, injector arguments and the types the set does not provide are
+  drawn as nodes, with an edge labeled by type from each value to the nodes
+  that use it.
+
+  The name is a top-level variable or function name, optionally qualified by
+  its quoted import path, as in "example.com/foo".Set.
+
+  If no packages are listed, it defaults to ".".
+`
+}
+func (cmd *graphCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.tags, "tags", "", "append build tags to the default wirebuild")
+	f.StringVar(&cmd.format, "format", "dot", "output format: dot or mermaid")
+	f.StringVar(&cmd.name, "for", "", "name of the provider set or injector to draw")
+	f.BoolVar(&cmd.collapse, "collapse", false, "draw a node for each package instead of each provider")
+	f.StringVar(&cmd.highlight, "highlight", "", "highlight the values needed to create this type")
+	f.StringVar(&cmd.overlay, "overlay", "", overlayUsage)
+}
+func (cmd *graphCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if cmd.format != "dot" && cmd.format != "mermaid" {
+		log.Printf("unknown format %q; want dot or mermaid\n", cmd.format)
+		return subcommands.ExitUsageError
+	}
+	if cmd.name == "" {
+		log.Println("missing -for flag")
+		return subcommands.ExitUsageError
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Println("failed to get working directory: ", err)
+		return subcommands.ExitFailure
+	}
+	overlay, err := readOverlay(cmd.overlay)
+	if err != nil {
+		log.Println(err)
+		return subcommands.ExitFailure
+	}
+	info, errs := wire.Load(ctx, wd, os.Environ(), cmd.tags, packages(f), overlay)
+	if len(errs) > 0 {
+		logErrors(errs)
+		log.Println("error loading packages")
+		return subcommands.ExitFailure
+	}
+	var graphs []*depGraph
+	var found []string
+	for id, set := range info.Sets {
+		if id.VarName == cmd.name || id.String() == cmd.name {
+			graphs = append(graphs, setGraph(set, id.ImportPath))
+			found = append(found, id.String())
+		}
+	}
+	for _, in := range info.Injectors {
+		if in.FuncName == cmd.name || in.String() == cmd.name {
+			graphs = append(graphs, injectorGraph(in))
+			found = append(found, in.String())
+		}
+	}
+	switch len(graphs) {
+	case 0:
+		log.Printf("no provider set or injector named %s\n", cmd.name)
+		return subcommands.ExitFailure
+	case 1:
+	default:
+		sort.Strings(found)
+		log.Printf("%s is ambiguous: %s\n", cmd.name, strings.Join(found, ", "))
+		return subcommands.ExitFailure
+	}
+	g := graphs[0]
+	if cmd.highlight != "" && !g.highlight(cmd.highlight) {
+		log.Printf("%s does not provide %s\n", found[0], cmd.highlight)
+		return subcommands.ExitFailure
+	}
+	if cmd.collapse {
+		g = g.collapse()
+	}
+	if cmd.format == "dot" {
+		err = g.writeDOT(os.Stdout)
+	} else {
+		err = g.writeMermaid(os.Stdout)
+	}
+	if err != nil {
+		log.Println(err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+type whyCmd struct {
+	tags    string
+	overlay string
+}
+
+func (*whyCmd) Name() string { return "why" }
+func (*whyCmd) Synopsis() string {
+	return "explain how an injector gets a value of a type"
+}
+func (*whyCmd) Usage() string {
+	return `why [-tags tag,list] injector type [packages]
+
+  Given an injector function and a type, why prints the chain of values
+  from the injector's output down to the value of the type it needs, with
+  what provides each of them and the provider sets it was imported through.
+
+  The injector is a function name, optionally qualified by its quoted import
+  path, as in "example.com/foo".InitializeFoo. The type is written as in
+  *example.com/foo.Bar or *foo.Bar.
+
+  If no packages are listed, it defaults to ".".
+`
+}
+func (cmd *whyCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.tags, "tags", "", "append build tags to the default wirebuild")
+	f.StringVar(&cmd.overlay, "overlay", "", overlayUsage)
+}
+func (cmd *whyCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if f.NArg() < 2 {
+		log.Println("why needs an injector and a type")
+		return subcommands.ExitUsageError
+	}
+	name, typ := f.Arg(0), f.Arg(1)
+	pkgs := f.Args()[2:]
+	if len(pkgs) == 0 {
+		pkgs = []string{"."}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Println("failed to get working directory: ", err)
+		return subcommands.ExitFailure
+	}
+	overlay, err := readOverlay(cmd.overlay)
+	if err != nil {
+		log.Println(err)
+		return subcommands.ExitFailure
+	}
+	info, errs := wire.Load(ctx, wd, os.Environ(), cmd.tags, pkgs, overlay)
+	if len(errs) > 0 {
+		logErrors(errs)
+		log.Println("error loading packages")
+		return subcommands.ExitFailure
+	}
+	var found []*wire.Injector
+	for _, in := range sortedInjectors(info) {
+		if in.FuncName == name || in.String() == name {
+			found = append(found, in)
+		}
+	}
+	switch len(found) {
+	case 0:
+		log.Printf("no injector named %s\n", name)
+		return subcommands.ExitFailure
+	case 1:
+	default:
+		ids := make([]string, len(found))
+		for i, in := range found {
+			ids[i] = in.String()
+		}
+		log.Printf("%s is ambiguous: %s\n", name, strings.Join(ids, ", "))
+		return subcommands.ExitFailure
+	}
+	steps, errs := wire.Why(info.Fset, found[0], typ)
+	if len(errs) > 0 {
+		logErrors(errs)
+		return subcommands.ExitFailure
+	}
+	fmt.Println(found[0])
+	for i, s := range steps {
+		verb := "returns"
+		if i > 0 {
+			verb = "needs"
+		}
+		fmt.Printf("\t%s %s\n", verb, wire.TypeString(s.Type))
+		fmt.Printf("\t\tfrom %s\n", s.Trace[0])
+		for _, imp := range s.Trace[1:] {
+			fmt.Printf("\t\timported through %s\n", imp)
+		}
+	}
+	return subcommands.ExitSuccess
+}
+
+type unusedCmd struct {
+	tags    string
+	format  string
+	overlay string
+}
+
+func (*unusedCmd) Name() string { return "unused" }
+func (*unusedCmd) Synopsis() string {
+	return "print providers in provider sets that no injector uses"
+}
+func (*unusedCmd) Usage() string {
+	return `unused [-tags tag,list] [-format text|sarif|github] [packages]
+
+  Given one or more packages, unused solves all of their injectors and prints
+  the providers, values, interface bindings, fields, decorators,
+  multibindings and their contributions, and optional bindings of the named
+  provider sets they include that none of the injectors use, grouped by
+  provider set.
+
+  If no packages are listed, it defaults to ".".
+
+  It returns 0 if everything is used, 1 if anything is unused, 2 plus an
+  error if trouble. With -format=sarif or -format=github, it prints the
+  unused items and the errors as a SARIF 2.1.0 log or as GitHub Actions
+  workflow commands.
+`
+}
+func (cmd *unusedCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.tags, "tags", "", "append build tags to the default wirebuild")
+	f.StringVar(&cmd.format, "format", "text", "output format: text, sarif or github")
+	f.StringVar(&cmd.overlay, "overlay", "", overlayUsage)
+}
+func (cmd *unusedCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	switch cmd.format {
+	case "text", "sarif", "github":
+	default:
+		log.Printf("unknown format %q; want text, sarif or github\n", cmd.format)
+		return subcommands.ExitUsageError
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Println("failed to get working directory: ", err)
+		return subcommands.ExitStatus(2)
+	}
+	overlay, err := readOverlay(cmd.overlay)
+	if err != nil {
+		log.Println(err)
+		return subcommands.ExitStatus(2)
+	}
+	info, errs := wire.Load(ctx, wd, os.Environ(), cmd.tags, packages(f), overlay)
+	var items []wire.UnusedItem
+	if len(errs) == 0 {
+		// Injectors that failed to load would make what they use look
+		// unused, so nothing is reported unless all of them loaded.
+		items = wire.Unused(info.Fset, sortedInjectors(info))
+	}
+	if cmd.format != "text" {
+		diags := errorDiagnostics(errs)
+		for _, item := range items {
+			diags = append(diags, diagnostic{
+				rule:    unusedRule,
+				pos:     info.Fset.Position(item.Pos),
+				message: fmt.Sprintf("%s in provider set %s", item.Message, item.Set),
+			})
+		}
+		if err := writeDiagnostics(os.Stdout, cmd.format, wd, diags); err != nil {
+			log.Println(err)
+			return subcommands.ExitStatus(2)
+		}
+	} else if len(errs) > 0 {
+		logErrors(errs)
+		log.Println("error loading packages")
+	} else {
+		for i, item := range items {
+			if i == 0 || item.Set != items[i-1].Set {
+				fmt.Println(item.Set)
+			}
+			fmt.Printf("\t%v: %s\n", info.Fset.Position(item.Pos), item.Message)
+		}
+	}
+	switch {
+	case len(errs) > 0:
+		return subcommands.ExitStatus(2)
+	case len(items) > 0:
+		return subcommands.ExitStatus(1)
+	default:
+		return subcommands.ExitSuccess
+	}
+}
+
+type lspCmd struct {
+	tags string
+}
+
+func (*lspCmd) Name() string { return "lsp" }
+func (*lspCmd) Synopsis() string {
+	return "run a language server for Wire over stdin and stdout"
+}
+func (*lspCmd) Usage() string {
+	return `lsp [-tags tag,list]
+
+  lsp runs a Language Server Protocol server that communicates with an
+  editor over stdin and stdout. It publishes the Wire errors of the package
+  of each open file as diagnostics. For the type of a parameter or result of
+  an injector or provider, it goes to the provider of the type on a
+  definition request and describes how the type is provided on hover. The
+  contents of open files are used instead of the files on disk, so unsaved
+  changes are checked as they are typed. A package is checked in the
+  background shortly after its files stop changing, and the errors of a
+  package are cleared when its last open file is closed.
+`
+}
+func (cmd *lspCmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.tags, "tags", "", "append build tags to the default wirebuild")
+}
+func (cmd *lspCmd) Execute(ctx context.Context, f *flag.FlagSet, args ...interface{}) subcommands.ExitStatus {
+	if err := newLSPServer(os.Stdin, os.Stdout, cmd.tags).run(ctx); err != nil {
+		log.Println(err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
 type outGroup struct {
 	name    string
 	inputs  *typeutil.Map // values are not important
-	outputs *typeutil.Map // values are *wire.Provider, *wire.Value, or *wire.Field
+	outputs *typeutil.Map // values are *wire.Provider, *wire.Value, *wire.Field, or *wire.Multibinding
 }
 
 // gather flattens a provider set into outputs grouped by the inputs
@@ -442,20 +1043,41 @@ func gather(info *wire.Info, key wire.ProviderSetID) (_ []outGroup, imports map[
 			case pv.IsArg():
 				// This is an injector argument.
 				inputVisited.Set(curr, -1)
-			case pv.IsProvider():
+			case pv.IsProvider() || pv.IsMultibinding():
+				// A multibinding depends on its elements like a provider
+				// depends on its arguments.
+				var p interface{}
+				var args []types.Type
+				if pv.IsProvider() {
+					p = pv.Provider()
+					for _, arg := range pv.Provider().Args {
+						args = append(args, arg.Type)
+					}
+				} else {
+					p = pv.Multibinding()
+					args = pv.Multibinding().Elems
+				}
+				// Decorators depend on their arguments other than the
+				// value they decorate.
+				for _, d := range pv.Decorators() {
+					for _, arg := range d.Provider.Args {
+						if !types.Identical(arg.Type, d.Decorated()) {
+							args = append(args, arg.Type)
+						}
+					}
+				}
 				// Try to see if any args haven't been visited.
-				p := pv.Provider()
 				allPresent := true
-				for _, arg := range p.Args {
-					if inputVisited.At(arg.Type) == nil {
+				for _, arg := range args {
+					if inputVisited.At(arg) == nil {
 						allPresent = false
 					}
 				}
 				if !allPresent {
 					stk = append(stk, curr)
-					for _, arg := range p.Args {
-						if inputVisited.At(arg.Type) == nil {
-							stk = append(stk, arg.Type)
+					for _, arg := range args {
+						if inputVisited.At(arg) == nil {
+							stk = append(stk, arg)
 						}
 					}
 					continue dfs
@@ -464,10 +1086,10 @@ func gather(info *wire.Info, key wire.ProviderSetID) (_ []outGroup, imports map[
 				// Build up set of input types, match to a group.
 				in := new(typeutil.Map)
 				in.SetHasher(hash)
-				for _, arg := range p.Args {
-					i := inputVisited.At(arg.Type).(int)
+				for _, arg := range args {
+					i := inputVisited.At(arg).(int)
 					if i == -1 {
-						in.Set(arg.Type, true)
+						in.Set(arg, true)
 					} else {
 						mergeTypeSets(in, groups[i].inputs)
 					}
@@ -487,8 +1109,14 @@ func gather(info *wire.Info, key wire.ProviderSetID) (_ []outGroup, imports map[
 					inputs:  in,
 					outputs: out,
 				})
-			case pv.IsValue():
-				v := pv.Value()
+			case pv.IsValue() || pv.IsOptional():
+				// Values and optional bindings do not have inputs.
+				var v interface{}
+				if pv.IsValue() {
+					v = pv.Value()
+				} else {
+					v = pv.Optional()
+				}
 				for i := range groups {
 					if groups[i].inputs.Len() == 0 {
 						groups[i].outputs.Set(curr, v)
@@ -552,7 +1180,7 @@ func gather(info *wire.Info, key wire.ProviderSetID) (_ []outGroup, imports map[
 		}
 		instr := make([]string, 0, groups[i].inputs.Len())
 		groups[i].inputs.Iterate(func(k types.Type, _ interface{}) {
-			instr = append(instr, types.TypeString(k, nil))
+			instr = append(instr, wire.TypeString(k))
 		})
 		sort.Strings(instr)
 		groups[i].name = strings.Join(instr, ", ")
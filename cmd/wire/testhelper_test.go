@@ -0,0 +1,108 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/almondoo/wire/internal/wire"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// loadTimeout bounds how long loading a test module may take.
+const loadTimeout = 60 * time.Second
+
+// writeTestModule writes a module named example.com/wiretest with the given
+// files, keyed by slash-separated path, to a temporary directory and returns
+// the directory. The module requires github.com/almondoo/wire and replaces
+// it with the repository root, so it builds without a module proxy.
+func writeTestModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+	repoRoot, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	goMod := "module example.com/wiretest\n\n" +
+		"go 1.19\n\n" +
+		"require github.com/almondoo/wire v0.0.0-00010101000000-000000000000\n\n" +
+		"replace github.com/almondoo/wire => " + repoRoot + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// testEnv returns the environment of the go command run by the tests.
+func testEnv() []string {
+	return append(os.Environ(), "GOPROXY=off")
+}
+
+// loadTestModule loads the packages of a module written by writeTestModule.
+// Wire errors are returned rather than failing the test.
+func loadTestModule(t *testing.T, dir string) (*wire.Info, []error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), loadTimeout)
+	defer cancel()
+	return wire.Load(ctx, dir, testEnv(), "", []string{"./..."}, nil)
+}
+
+// checkGolden compares got with the contents of testdata/name, or writes it
+// there if the -update flag is set.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", filepath.FromSlash(name))
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v; run go test -update to create it", err)
+	}
+	if got == string(want) {
+		return
+	}
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(want)),
+		B:        difflib.SplitLines(got),
+		FromFile: path,
+		ToFile:   "got",
+		Context:  3,
+	})
+	t.Errorf("output differs from %s:\n%s", path, strings.TrimSpace(diff))
+}
@@ -0,0 +1,26 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Wirevet reports errors in Wire provider sets and injectors. It runs the
+// analyzer of package wireanalysis on its own, or as a tool of go vet:
+//
+//	go vet -vettool=$(which wirevet) -tags=wireinject ./...
+package main
+
+import (
+	"github.com/almondoo/wire/wireanalysis"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() { singlechecker.Main(wireanalysis.Analyzer) }
@@ -23,6 +23,8 @@ import (
 	"sort"
 	"strings"
 
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
 	"golang.org/x/tools/go/types/typeutil"
 )
 
@@ -33,11 +35,13 @@ const (
 	structProvider
 	valueExpr
 	selectorExpr
+	collectionLit
+	zeroValueExpr
+	componentLit
 )
 
 // A call represents a step of an injector function.  It may be either a
-// function call or a composite struct literal, depending on the value
-// of kind.
+// function call or a composite literal, depending on the value of kind.
 type call struct {
 	// kind indicates the code pattern to use.
 	kind callKind
@@ -48,15 +52,22 @@ type call struct {
 	// pkg and name identify one of the following:
 	// 1) the provider to call for kind == funcProviderCall;
 	// 2) the type to construct for kind == structProvider;
-	// 3) the name to select for kind == selectorExpr.
+	// 3) the name to select for kind == selectorExpr;
+	// 4) the interface to implement for kind == componentLit.
+	// They are not set for kind == collectionLit or kind == zeroValueExpr.
 	pkg  *types.Package
 	name string
 
+	// typeArgs is the list of type arguments to instantiate a generic
+	// provider function or struct with. It is nil if the provider is not
+	// generic.
+	typeArgs []types.Type
+
 	// args is a list of arguments to call the provider with. Each element is:
 	// a) one of the givens (args[i] < len(given)),
 	// b) the result of a previous provider call (args[i] >= len(given))
 	//
-	// This will be nil for kind == valueExpr.
+	// This will be nil for kind == valueExpr and kind == zeroValueExpr.
 	//
 	// If kind == selectorExpr, then the length of this slice will be 1 and the
 	// "argument" will be the value to access fields from.
@@ -65,18 +76,34 @@ type call struct {
 	// varargs is true if the provider function is variadic.
 	varargs bool
 
-	// fieldNames maps the arguments to struct field names.
-	// This will only be set if kind == structProvider.
+	// fieldNames maps the arguments to struct field names, or to the names
+	// of the interface methods that return them for kind == componentLit.
+	// This will only be set if kind == structProvider or kind == componentLit.
 	fieldNames []string
 
 	// ins is the list of types this call receives as arguments.
 	// This will be nil for kind == valueExpr.
 	ins []types.Type
 
+	// src is the source of the provider, value, field, multibinding or
+	// optional binding in the injector's provider set. It is nil for the
+	// zero value of an unprovided optional input.
+	src *providerSetSrc
+	// decorator is the decorator called, if kind == funcProviderCall and
+	// the call is to a decorator.
+	decorator *Decorator
+
 	// The following are only set for kind == funcProviderCall:
 
 	// hasCleanup is true if the provider call returns a cleanup function.
 	hasCleanup bool
+	// cleanupErr is true if the cleanup function returns an error.
+	cleanupErr bool
+	// cleanupCtx is true if the cleanup function takes a context.Context.
+	cleanupCtx bool
+	// closer is true if the cleanup function is the Close method of the
+	// provided value.
+	closer bool
 	// hasErr is true if the provider call returns an error.
 	hasErr bool
 
@@ -84,22 +111,61 @@ type call struct {
 
 	valueExpr     ast.Expr
 	valueTypeInfo *types.Info
+	// declareOut is true if the value must be declared with type out, as
+	// for the default value of wire.Optional, which may be an untyped
+	// constant.
+	declareOut bool
 
 	// The following are only set for kind == selectorExpr:
 
 	ptrToField bool
+
+	// The following are only set for kind == collectionLit:
+
+	// mapKeys are the keys of the map elements given by args. It is nil if
+	// the call produces a slice.
+	mapKeys []string
 }
 
 // solve finds the sequence of calls required to produce an output type
 // with an optional set of provided inputs.
 func solve(fset *token.FileSet, out types.Type, given *types.Tuple, set *ProviderSet) ([]call, []error) {
+	calls, _, errs := solveUses(fset, out, given, set)
+	return calls, errs
+}
+
+// A use records that solve provided t with src, an entry of the injector's
+// provider set. For the intermediate values of a decorated type, t is the
+// decorated type and d is the decorator that provides the value, if any.
+type use struct {
+	src *providerSetSrc
+	t   types.Type
+	d   *Decorator
+}
+
+// maxInstanceDepth is the number of instances of a generic provider that
+// solve allows each to need the next, which stops instantiation that would
+// never end.
+const maxInstanceDepth = 32
+
+// solveUses is like solve, but also returns what it used from set.
+func solveUses(fset *token.FileSet, out types.Type, given *types.Tuple, set *ProviderSet) ([]call, []use, []error) {
 	ec := new(errorCollector)
+	// An injector that returns a wire.Outputs struct fills in its fields
+	// itself rather than using a provider of the struct.
+	outputs, err := outputsProvider(fset, set.qualifiers, out)
+	if err != nil {
+		return nil, nil, []error{err}
+	}
 
 	// Start building the mapping of type to local variable of the given type.
 	// The first len(given) local variables are the given types.
+	// Decorated arguments are visited like other decorated types instead.
 	index := new(typeutil.Map)
 	for i := 0; i < given.Len(); i++ {
-		index.Set(given.At(i).Type(), i)
+		if t := given.At(i).Type(); len(set.For(t).d) == 0 {
+			index.Set(t, i)
+		}
 	}
 
 	// Topological sort of the directed graph defined by the providers
@@ -108,39 +174,128 @@ func solve(fset *token.FileSet, out types.Type, given *types.Tuple, set *Provide
 	// the type was visited, but failed due to an error added to ec.
 	errAbort := errors.New("failed to visit")
 	var used []*providerSetSrc
+	var uses []use
 	var calls []call
+	// zeroed records the types that were left as their zero value for an
+	// optional input, so that inputs requiring them are still reported.
+	zeroed := new(typeutil.Map)
+	// stages maps the decorated types and the keys of their intermediate
+	// values, created by stageKey, to what provides them.
+	type stage struct {
+		pv  ProvidedType
+		src *providerSetSrc
+		// d is the decorator that provides the stage, if any.
+		d *Decorator
+	}
+	stages := new(typeutil.Map)
+	// instances maps the types provided by instantiating generic providers
+	// to the instances.
+	type instance struct {
+		p   *Provider
+		src *providerSetSrc
+	}
+	instances := new(typeutil.Map)
+	// lookup returns what provides t and its source. The outputs struct of
+	// the injector is provided by outputs. A decorated type is
+	// provided by its last decorator, whose decorated argument is provided
+	// by the previous decorator, and so on down to the original provider.
+	lookup := func(t types.Type) (ProvidedType, *providerSetSrc) {
+		if outputs != nil && types.Identical(t, out) {
+			return ProvidedType{t: out, p: outputs}, &providerSetSrc{Provider: outputs}
+		}
+		if s := stages.At(t); s != nil {
+			return s.(stage).pv, s.(stage).src
+		}
+		if inst, ok := instances.At(t).(instance); ok {
+			return ProvidedType{t: t, p: inst.p}, inst.src
+		}
+		pv := set.For(t)
+		src, _ := set.srcMap.At(t).(*providerSetSrc)
+		if len(pv.d) == 0 {
+			return pv, src
+		}
+		prev := stageKey(t, pv.d[0].d)
+		base := pv
+		base.d = nil
+		if types.Identical(base.t, t) {
+			base.t = prev
+		}
+		stages.Set(prev, stage{pv: base, src: src})
+		for i, d := range pv.d {
+			out := t
+			if i+1 < len(pv.d) {
+				out = stageKey(t, pv.d[i+1].d)
+			}
+			p := *d.d.Provider
+			p.Args = append([]ProviderInput(nil), p.Args...)
+			p.Args[d.d.decoratedArg()].Type = prev
+			p.Out = []types.Type{out}
+			stages.Set(out, stage{pv: ProvidedType{t: out, p: &p}, src: d.src, d: d.d})
+			prev = out
+		}
+		s := stages.At(t).(stage)
+		return s.pv, s.src
+	}
 	type frame struct {
-		t    types.Type
-		from types.Type
-		up   *frame
+		t        types.Type
+		from     types.Type
+		up       *frame
+		optional bool
 	}
 	stk := []frame{{t: out}}
 dfs:
 	for len(stk) > 0 {
 		curr := stk[len(stk)-1]
 		stk = stk[:len(stk)-1]
-		if index.At(curr.t) != nil {
+		if index.At(curr.t) != nil && (curr.optional || zeroed.At(curr.t) == nil) {
 			continue
 		}
+		zeroed.Delete(curr.t)
 
-		pv := set.For(curr.t)
+		pv, src := lookup(curr.t)
+		if pv.IsNil() {
+			p, psrc, err := set.instantiate(fset, curr.t)
+			if err != nil {
+				ec.add(err)
+				index.Set(curr.t, errAbort)
+				continue
+			}
+			if p != nil {
+				instances.Set(curr.t, instance{p: p, src: psrc})
+				pv, src = lookup(curr.t)
+			}
+		}
 		if pv.IsNil() {
+			if curr.optional {
+				index.Set(curr.t, given.Len()+len(calls))
+				zeroed.Set(curr.t, true)
+				calls = append(calls, call{
+					kind: zeroValueExpr,
+					out:  curr.t,
+				})
+				continue
+			}
 			if curr.from == nil {
-				ec.add(fmt.Errorf("no provider found for %s, output of injector", types.TypeString(curr.t, nil)))
+				ec.add(fmt.Errorf("no provider found for %s, output of injector", TypeString(curr.t)))
 				index.Set(curr.t, errAbort)
 				continue
 			}
 			sb := new(strings.Builder)
-			fmt.Fprintf(sb, "no provider found for %s", types.TypeString(curr.t, nil))
+			fmt.Fprintf(sb, "no provider found for %s", TypeString(curr.t))
 			for f := curr.up; f != nil; f = f.up {
-				fmt.Fprintf(sb, "\nneeded by %s in %s", types.TypeString(f.t, nil), set.srcMap.At(f.t).(*providerSetSrc).description(fset, f.t))
+				_, fsrc := lookup(f.t)
+				fmt.Fprintf(sb, "\nneeded by %s in %s", TypeString(f.t), fsrc.description(fset, f.t))
 			}
 			ec.add(errors.New(sb.String()))
 			index.Set(curr.t, errAbort)
 			continue
 		}
-		src := set.srcMap.At(curr.t).(*providerSetSrc)
 		used = append(used, src)
+		u := use{src: src, t: unstage(curr.t)}
+		if s, ok := stages.At(curr.t).(stage); ok {
+			u.d = s.d
+		}
+		uses = append(uses, u)
 		if concrete := pv.Type(); !types.Identical(concrete, curr.t) {
 			// Interface binding does not create a call.
 			i := index.At(concrete)
@@ -152,24 +307,59 @@ dfs:
 			continue
 		}
 
-		switch pv := set.For(curr.t); {
+		switch {
 		case pv.IsArg():
-			// Continue, already added to stk.
+			// Only reached for a decorated argument, or the value of one
+			// before it is decorated.
+			index.Set(curr.t, pv.Arg().Index)
 		case pv.IsProvider():
 			p := pv.Provider()
+			if p.origin != nil {
+				// An instance of a generic provider can need the type it
+				// provides, which the provider map of the set cannot show,
+				// or ever larger instances of the same provider.
+				var path []types.Type
+				depth := 0
+				for f := &curr; f != nil; f = f.up {
+					path = append(path, f.t)
+					if f != &curr && types.Identical(f.t, curr.t) {
+						sb := new(strings.Builder)
+						fmt.Fprintf(sb, "cycle for %s:\n", TypeString(curr.t))
+						for i := len(path) - 1; i > 0; i-- {
+							if fp, _ := lookup(path[i]); fp.IsProvider() {
+								fmt.Fprintf(sb, "%s (%s.%s) ->\n", TypeString(path[i]), fp.Provider().Pkg.Path(), fp.Provider().Name)
+							} else {
+								fmt.Fprintf(sb, "%s ->\n", TypeString(path[i]))
+							}
+						}
+						fmt.Fprintf(sb, "%s", TypeString(curr.t))
+						ec.add(errors.New(sb.String()))
+						index.Set(curr.t, errAbort)
+						continue dfs
+					}
+					if fp, _ := lookup(f.t); fp.IsProvider() && fp.Provider().origin == p.origin {
+						depth++
+					}
+				}
+				if depth > maxInstanceDepth {
+					ec.add(fmt.Errorf("generic provider %s is instantiated more than %d times to provide %s", p.origin.Name, maxInstanceDepth, TypeString(curr.t)))
+					index.Set(curr.t, errAbort)
+					continue
+				}
+			}
 			// Ensure that all argument types have been visited. If not, push them
 			// on the stack in reverse order so that calls are added in argument
 			// order.
 			visitedArgs := true
 			for i := len(p.Args) - 1; i >= 0; i-- {
 				a := p.Args[i]
-				if index.At(a.Type) == nil {
+				if index.At(a.Type) == nil || !a.Optional && zeroed.At(a.Type) != nil {
 					if visitedArgs {
 						// Make sure to re-visit this type after visiting all arguments.
 						stk = append(stk, curr)
 						visitedArgs = false
 					}
-					stk = append(stk, frame{t: a.Type, from: curr.t, up: &curr})
+					stk = append(stk, frame{t: a.Type, from: curr.t, up: &curr, optional: a.Optional})
 				}
 			}
 			if !visitedArgs {
@@ -187,10 +377,17 @@ dfs:
 				args[i] = v.(int)
 			}
 			index.Set(curr.t, given.Len()+len(calls))
+			var decorator *Decorator
+			if s, ok := stages.At(curr.t).(stage); ok {
+				decorator = s.d
+			}
 			kind := funcProviderCall
 			fieldNames := []string(nil)
-			if p.IsStruct {
+			if p.IsStruct || p.outputs {
 				kind = structProvider
+				if !p.IsStruct {
+					kind = componentLit
+				}
 				for _, arg := range p.Args {
 					fieldNames = append(fieldNames, arg.FieldName)
 				}
@@ -199,12 +396,18 @@ dfs:
 				kind:       kind,
 				pkg:        p.Pkg,
 				name:       p.Name,
+				typeArgs:   p.TypeArgs,
 				args:       args,
 				varargs:    p.Varargs,
 				fieldNames: fieldNames,
 				ins:        ins,
 				out:        curr.t,
+				src:        src,
+				decorator:  decorator,
 				hasCleanup: p.HasCleanup,
+				cleanupErr: p.CleanupErr,
+				cleanupCtx: p.CleanupCtx,
+				closer:     p.Closer,
 				hasErr:     p.HasErr,
 			})
 		case pv.IsValue():
@@ -213,6 +416,7 @@ dfs:
 			calls = append(calls, call{
 				kind:          valueExpr,
 				out:           curr.t,
+				src:           src,
 				valueExpr:     v.expr,
 				valueTypeInfo: v.info,
 			})
@@ -240,20 +444,76 @@ dfs:
 				pkg:        f.Pkg,
 				name:       f.Name,
 				out:        curr.t,
+				src:        src,
 				args:       args,
 				ptrToField: ptrToField,
 			})
+		case pv.IsMultibinding():
+			m := pv.Multibinding()
+			used = append(used, m.sources...)
+			// Ensure that all elements have been visited, in the same way as
+			// the arguments of a provider.
+			visitedElems := true
+			for i := len(m.Elems) - 1; i >= 0; i-- {
+				e := m.Elems[i]
+				if index.At(e) == nil {
+					if visitedElems {
+						stk = append(stk, curr)
+						visitedElems = false
+					}
+					stk = append(stk, frame{t: e, from: curr.t, up: &curr})
+				}
+			}
+			if !visitedElems {
+				continue
+			}
+			args := make([]int, len(m.Elems))
+			for i, e := range m.Elems {
+				v := index.At(e)
+				if v == errAbort {
+					index.Set(curr.t, errAbort)
+					continue dfs
+				}
+				args[i] = v.(int)
+			}
+			index.Set(curr.t, given.Len()+len(calls))
+			calls = append(calls, call{
+				kind:    collectionLit,
+				out:     curr.t,
+				src:     src,
+				args:    args,
+				ins:     m.Elems,
+				mapKeys: m.Keys,
+			})
+		case pv.IsOptional():
+			index.Set(curr.t, given.Len()+len(calls))
+			if v := pv.Optional().Default; v != nil {
+				calls = append(calls, call{
+					kind:          valueExpr,
+					out:           curr.t,
+					src:           src,
+					valueExpr:     v.expr,
+					valueTypeInfo: v.info,
+					declareOut:    true,
+				})
+				continue
+			}
+			calls = append(calls, call{
+				kind: zeroValueExpr,
+				out:  curr.t,
+				src:  src,
+			})
 		default:
 			panic("unknown return value from ProviderSet.For")
 		}
 	}
 	if len(ec.errors) > 0 {
-		return nil, ec.errors
+		return nil, nil, ec.errors
 	}
 	if errs := verifyArgsUsed(set, used); len(errs) > 0 {
-		return nil, errs
+		return nil, nil, errs
 	}
-	return calls, nil
+	return calls, uses, nil
 }
 
 // verifyArgsUsed ensures that all of the arguments in set were used during solve.
@@ -278,7 +538,7 @@ func verifyArgsUsed(set *ProviderSet, used []*providerSetSrc) []error {
 	for _, p := range set.Providers {
 		found := false
 		for _, u := range used {
-			if u.Provider == p {
+			if u.Provider != nil && (u.Provider == p || u.Provider.origin == p) {
 				found = true
 				break
 			}
@@ -296,7 +556,7 @@ func verifyArgsUsed(set *ProviderSet, used []*providerSetSrc) []error {
 			}
 		}
 		if !found {
-			errs = append(errs, fmt.Errorf("unused value of type %s", types.TypeString(v.Out, nil)))
+			errs = append(errs, fmt.Errorf("unused value of type %s", TypeString(v.Out)))
 		}
 	}
 	for _, b := range set.Bindings {
@@ -308,7 +568,7 @@ func verifyArgsUsed(set *ProviderSet, used []*providerSetSrc) []error {
 			}
 		}
 		if !found {
-			errs = append(errs, fmt.Errorf("unused interface binding to type %s", types.TypeString(b.Iface, nil)))
+			errs = append(errs, fmt.Errorf("unused interface binding to type %s", TypeString(b.Iface)))
 		}
 	}
 	for _, f := range set.Fields {
@@ -323,9 +583,167 @@ func verifyArgsUsed(set *ProviderSet, used []*providerSetSrc) []error {
 			errs = append(errs, fmt.Errorf("unused field %q.%s", f.Parent, f.Name))
 		}
 	}
+	for _, m := range set.Multibindings {
+		found := false
+		for _, u := range used {
+			if u.Multibinding == m {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("unused %s for %s", m.marker(), TypeString(m.Out)))
+		}
+	}
+	for _, d := range set.Decorators {
+		found := false
+		for _, u := range used {
+			if u.Decorator == d {
+				found = true
+				break
+			}
+		}
+		if !found {
+			p := d.Provider
+			errs = append(errs, fmt.Errorf("unused decorator %q", p.Pkg.Name()+"."+p.Name))
+		}
+	}
+	for _, o := range set.Optionals {
+		found := false
+		for _, u := range used {
+			if u.Optional == o {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("unused wire.Optional for %s", TypeString(o.Out)))
+		}
+	}
 	return errs
 }
 
+// instantiate returns a provider of t that instantiates a generic provider
+// of set or of the sets it imports, and the source of the provider in set.
+// It returns a nil provider if no generic provider can provide t, and an
+// error if more than one can or if the type arguments that provide t do not
+// satisfy the constraints of the provider.
+func (set *ProviderSet) instantiate(fset *token.FileSet, t types.Type) (*Provider, *providerSetSrc, error) {
+	var found *Provider
+	var foundSrc *providerSetSrc
+	add := func(p *Provider, src *providerSetSrc) error {
+		if found != nil && found.origin != p.origin {
+			return fmt.Errorf("multiple generic providers for %s: %s (%s) and %s (%s)",
+				TypeString(t), found.origin.Name, fset.Position(found.origin.Pos), p.origin.Name, fset.Position(p.origin.Pos))
+		}
+		if found == nil {
+			found, foundSrc = p, src
+		}
+		return nil
+	}
+	for _, g := range set.Providers {
+		if g.generic == nil {
+			continue
+		}
+		p, err := instantiateProvider(fset, g, t)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p == nil {
+			continue
+		}
+		if err := add(p, &providerSetSrc{Provider: p}); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, imp := range set.Imports {
+		p, src, err := imp.instantiate(fset, t)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p == nil {
+			continue
+		}
+		if err := add(p, &providerSetSrc{Import: imp, next: src}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return found, foundSrc, nil
+}
+
+// instantiateProvider returns the instantiation of the generic provider g
+// that provides t, or nil if none does.
+func instantiateProvider(fset *token.FileSet, g *Provider, t types.Type) (*Provider, error) {
+	sig := g.generic.Type().(*types.Signature)
+	tparams := sig.TypeParams()
+	targs := make([]types.Type, tparams.Len())
+	if !unify(g.Out[0], t, tparams, targs) {
+		return nil, nil
+	}
+	// genericFuncProvider ensures that every type parameter is in Out[0], so
+	// unify found all of the type arguments.
+	inst, err := types.Instantiate(nil, sig, targs, true)
+	if err != nil {
+		return nil, notePosition(fset.Position(g.Pos), fmt.Errorf("cannot instantiate generic provider %s to provide %s: %v", g.Name, TypeString(t), err))
+	}
+	p, errs := funcProvider(fset, nil, g.generic, inst.(*types.Signature), targs, nil)
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	p.origin = g
+	return p, nil
+}
+
+// unify reports whether the type x, which may mention the type parameters
+// in tparams, is t once they are replaced with type arguments. It records
+// the type arguments it finds in targs, which is indexed like tparams.
+// Type parameters are only inferred from the element and key types of
+// composite types and from the type arguments of named types; other types
+// must be identical.
+func unify(x, t types.Type, tparams *types.TypeParamList, targs []types.Type) bool {
+	switch x := x.(type) {
+	case *types.TypeParam:
+		if i := x.Index(); i < tparams.Len() && tparams.At(i) == x {
+			if targs[i] == nil {
+				targs[i] = t
+				return true
+			}
+			return types.Identical(targs[i], t)
+		}
+	case *types.Pointer:
+		t, ok := t.(*types.Pointer)
+		return ok && unify(x.Elem(), t.Elem(), tparams, targs)
+	case *types.Slice:
+		t, ok := t.(*types.Slice)
+		return ok && unify(x.Elem(), t.Elem(), tparams, targs)
+	case *types.Array:
+		t, ok := t.(*types.Array)
+		return ok && x.Len() == t.Len() && unify(x.Elem(), t.Elem(), tparams, targs)
+	case *types.Map:
+		t, ok := t.(*types.Map)
+		return ok && unify(x.Key(), t.Key(), tparams, targs) && unify(x.Elem(), t.Elem(), tparams, targs)
+	case *types.Chan:
+		t, ok := t.(*types.Chan)
+		return ok && x.Dir() == t.Dir() && unify(x.Elem(), t.Elem(), tparams, targs)
+	case *types.Named:
+		t, ok := t.(*types.Named)
+		if !ok || x.Origin() != t.Origin() {
+			return false
+		}
+		xa, ta := x.TypeArgs(), t.TypeArgs()
+		if xa.Len() != ta.Len() {
+			return false
+		}
+		for i := 0; i < xa.Len(); i++ {
+			if !unify(xa.At(i), ta.At(i), tparams, targs) {
+				return false
+			}
+		}
+		return true
+	}
+	return types.Identical(x, t)
+}
+
 // buildProviderMap creates the providerMap and srcMap fields for a given
 // provider set. The given provider set's providerMap and srcMap fields are
 // ignored.
@@ -343,7 +761,7 @@ func buildProviderMap(fset *token.FileSet, hasher typeutil.Hasher, set *Provider
 			typ := givens.At(i).Type()
 			arg := &InjectorArg{Args: set.InjectorArgs, Index: i}
 			src := &providerSetSrc{InjectorArg: arg}
-			if prevSrc := srcMap.At(typ); prevSrc != nil {
+			if prevSrc := srcMap.At(typ); prevSrc != nil && !overrides(set, prevSrc.(*providerSetSrc)) {
 				ec.add(bindingConflictError(fset, typ, set, src, prevSrc.(*providerSetSrc)))
 				continue
 			}
@@ -352,10 +770,39 @@ func buildProviderMap(fset *token.FileSet, hasher typeutil.Hasher, set *Provider
 		}
 	}
 	// Process imports, verifying that there are no conflicts between sets.
+	// Optional bindings are only used for types that nothing else provides,
+	// so they are added after all other providers.
+	type optionalSrc struct {
+		t   types.Type
+		pt  *ProvidedType
+		src *providerSetSrc
+	}
+	var optionals []optionalSrc
+	for _, o := range set.Optionals {
+		optionals = append(optionals, optionalSrc{o.Out, &ProvidedType{t: o.Out, o: o}, &providerSetSrc{Optional: o}})
+	}
 	for _, imp := range set.Imports {
 		src := &providerSetSrc{Import: imp}
 		imp.providerMap.Iterate(func(k types.Type, v interface{}) {
-			if prevSrc := srcMap.At(k); prevSrc != nil {
+			if pt := v.(*ProvidedType); pt.IsOptional() {
+				optionals = append(optionals, optionalSrc{k, pt, src})
+				return
+			} else if pt.IsMultibinding() {
+				// Multibindings from different sets are merged rather than
+				// conflicting. Sources are tracked in terms of this set.
+				m := &Multibinding{
+					Out:     pt.m.Out,
+					Pos:     pt.m.Pos,
+					Elems:   pt.m.Elems,
+					Keys:    pt.m.Keys,
+					sources: []*providerSetSrc{src},
+				}
+				if err := addMultibinding(fset, set, providerMap, srcMap, m, src); err != nil {
+					ec.add(err)
+				}
+				return
+			}
+			if prevSrc := srcMap.At(k); prevSrc != nil && !overrides(set, prevSrc.(*providerSetSrc)) {
 				ec.add(bindingConflictError(fset, k, set, src, prevSrc.(*providerSetSrc)))
 				return
 			}
@@ -367,11 +814,15 @@ func buildProviderMap(fset *token.FileSet, hasher typeutil.Hasher, set *Provider
 		return nil, nil, ec.errors
 	}
 
-	// Process non-binding providers in new set.
+	// Process non-binding providers in new set. Generic providers do not
+	// provide any type until solve instantiates them.
 	for _, p := range set.Providers {
+		if p.generic != nil {
+			continue
+		}
 		src := &providerSetSrc{Provider: p}
 		for _, typ := range p.Out {
-			if prevSrc := srcMap.At(typ); prevSrc != nil {
+			if prevSrc := srcMap.At(typ); prevSrc != nil && !overrides(set, prevSrc.(*providerSetSrc)) {
 				ec.add(bindingConflictError(fset, typ, set, src, prevSrc.(*providerSetSrc)))
 				continue
 			}
@@ -381,7 +832,7 @@ func buildProviderMap(fset *token.FileSet, hasher typeutil.Hasher, set *Provider
 	}
 	for _, v := range set.Values {
 		src := &providerSetSrc{Value: v}
-		if prevSrc := srcMap.At(v.Out); prevSrc != nil {
+		if prevSrc := srcMap.At(v.Out); prevSrc != nil && !overrides(set, prevSrc.(*providerSetSrc)) {
 			ec.add(bindingConflictError(fset, v.Out, set, src, prevSrc.(*providerSetSrc)))
 			continue
 		}
@@ -391,7 +842,7 @@ func buildProviderMap(fset *token.FileSet, hasher typeutil.Hasher, set *Provider
 	for _, f := range set.Fields {
 		src := &providerSetSrc{Field: f}
 		for _, typ := range f.Out {
-			if prevSrc := srcMap.At(typ); prevSrc != nil {
+			if prevSrc := srcMap.At(typ); prevSrc != nil && !overrides(set, prevSrc.(*providerSetSrc)) {
 				ec.add(bindingConflictError(fset, typ, set, src, prevSrc.(*providerSetSrc)))
 				continue
 			}
@@ -399,6 +850,24 @@ func buildProviderMap(fset *token.FileSet, hasher typeutil.Hasher, set *Provider
 			srcMap.Set(typ, src)
 		}
 	}
+	for _, m := range set.Multibindings {
+		src := &providerSetSrc{Multibinding: m}
+		own := &Multibinding{
+			Out:     m.Out,
+			Pos:     m.Pos,
+			Elems:   m.Elems,
+			Keys:    m.Keys,
+			sources: []*providerSetSrc{src},
+		}
+		if err := addMultibinding(fset, set, providerMap, srcMap, own, src); err != nil {
+			ec.add(err)
+		}
+	}
+	for _, o := range optionals {
+		if err := addOptional(fset, set, providerMap, srcMap, o.t, o.pt, o.src); err != nil {
+			ec.add(err)
+		}
+	}
 	if len(ec.errors) > 0 {
 		return nil, nil, ec.errors
 	}
@@ -407,7 +876,7 @@ func buildProviderMap(fset *token.FileSet, hasher typeutil.Hasher, set *Provider
 	// ensure the concrete type is being provided.
 	for _, b := range set.Bindings {
 		src := &providerSetSrc{Binding: b}
-		if prevSrc := srcMap.At(b.Iface); prevSrc != nil {
+		if prevSrc := srcMap.At(b.Iface); prevSrc != nil && !providerMap.At(b.Iface).(*ProvidedType).IsOptional() && !overrides(set, prevSrc.(*providerSetSrc)) {
 			ec.add(bindingConflictError(fset, b.Iface, set, src, prevSrc.(*providerSetSrc)))
 			continue
 		}
@@ -417,18 +886,121 @@ func buildProviderMap(fset *token.FileSet, hasher typeutil.Hasher, set *Provider
 			if setName == "" {
 				setName = "provider set"
 			}
-			ec.add(notePosition(fset.Position(b.Pos), fmt.Errorf("wire.Bind of concrete type %q to interface %q, but %s does not include a provider for %q", b.Provided, b.Iface, setName, b.Provided)))
+			ec.add(notePosition(fset.Position(b.Pos), fmt.Errorf("wire.Bind of concrete type %q to interface %q, but %s does not include a provider for %q", TypeString(b.Provided), TypeString(b.Iface), setName, TypeString(b.Provided))))
 			continue
 		}
-		providerMap.Set(b.Iface, concrete)
+		// Decorators of the concrete type do not apply to the interface.
+		bound := *concrete.(*ProvidedType)
+		bound.d = nil
+		providerMap.Set(b.Iface, &bound)
 		srcMap.Set(b.Iface, src)
 	}
 	if len(ec.errors) > 0 {
 		return nil, nil, ec.errors
 	}
+
+	// Apply decorators to the types they decorate. The decorators of
+	// imported sets are applied again, since a type they decorate may be
+	// provided by this set or by another imported set.
+	decorated := new(typeutil.Map)
+	decorated.SetHasher(hasher)
+	for _, d := range decorations(set) {
+		t := d.d.Decorated()
+		pt, _ := providerMap.At(t).(*ProvidedType)
+		if pt == nil {
+			continue
+		}
+		if decorated.At(t) == nil {
+			decorated.Set(t, true)
+			cp := *pt
+			cp.d = nil
+			pt = &cp
+			providerMap.Set(t, pt)
+		}
+		pt.d = append(pt.d, d)
+	}
 	return providerMap, srcMap, nil
 }
 
+// decorations returns the decorators of set and of the sets it imports, in
+// the order they are applied, along with their sources in set.
+func decorations(set *ProviderSet) []decoration {
+	var ds []decoration
+	seen := make(map[*Decorator]bool)
+	for _, imp := range set.Imports {
+		src := &providerSetSrc{Import: imp}
+		for _, d := range decorations(imp) {
+			if !seen[d.d] {
+				seen[d.d] = true
+				ds = append(ds, decoration{d: d.d, src: src})
+			}
+		}
+	}
+	for _, d := range set.Decorators {
+		if !seen[d] {
+			seen[d] = true
+			ds = append(ds, decoration{d: d, src: &providerSetSrc{Decorator: d}})
+		}
+	}
+	return ds
+}
+
+// addOptional adds the optional binding pt for t from src to providerMap and
+// srcMap, unless t is already provided. Two optional bindings for the same
+// type only conflict if they both declare a default value.
+func addOptional(fset *token.FileSet, set *ProviderSet, providerMap, srcMap *typeutil.Map, t types.Type, pt *ProvidedType, src *providerSetSrc) error {
+	prevSrc := srcMap.At(t)
+	if prevSrc == nil {
+		providerMap.Set(t, pt)
+		srcMap.Set(t, src)
+		return nil
+	}
+	prev := providerMap.At(t).(*ProvidedType)
+	if !prev.IsOptional() || prev.o == pt.o || pt.o.Default == nil {
+		return nil
+	}
+	if prev.o.Default != nil {
+		return bindingConflictError(fset, t, set, src, prevSrc.(*providerSetSrc))
+	}
+	providerMap.Set(t, pt)
+	srcMap.Set(t, src)
+	return nil
+}
+
+// addMultibinding adds the multibinding m from src to providerMap and
+// srcMap, merging it with any multibinding for the same type that was
+// already added.
+func addMultibinding(fset *token.FileSet, set *ProviderSet, providerMap, srcMap *typeutil.Map, m *Multibinding, src *providerSetSrc) error {
+	prevSrc := srcMap.At(m.Out)
+	if prevSrc == nil {
+		providerMap.Set(m.Out, &ProvidedType{t: m.Out, m: m})
+		srcMap.Set(m.Out, src)
+		return nil
+	}
+	prev := providerMap.At(m.Out).(*ProvidedType)
+	if !prev.IsMultibinding() {
+		return bindingConflictError(fset, m.Out, set, src, prevSrc.(*providerSetSrc))
+	}
+	merged := &Multibinding{
+		Out:     m.Out,
+		Pos:     set.Pos,
+		Elems:   append(append([]types.Type(nil), prev.m.Elems...), m.Elems...),
+		sources: append(append([]*providerSetSrc(nil), prev.m.sources...), m.sources...),
+	}
+	if prev.m.Keys != nil {
+		merged.Keys = append(append([]string{}, prev.m.Keys...), m.Keys...)
+		for i, k := range merged.Keys {
+			for _, k2 := range merged.Keys[:i] {
+				if k == k2 {
+					return notePosition(fset.Position(set.Pos), fmt.Errorf("multiple values for key %q of %s", k, TypeString(m.Out)))
+				}
+			}
+		}
+	}
+	providerMap.Set(m.Out, &ProvidedType{t: m.Out, m: merged})
+	return nil
+}
+
 func verifyAcyclic(providerMap *typeutil.Map, hasher typeutil.Hasher) []error {
 	// We must visit every provider type inside provider map, but we don't
 	// have a well-defined starting point and there may be several
@@ -440,7 +1012,7 @@ func verifyAcyclic(providerMap *typeutil.Map, hasher typeutil.Hasher) []error {
 	ec := new(errorCollector)
 	// Sort output types so that errors about cycles are consistent.
 	outputs := providerMap.Keys()
-	sort.Slice(outputs, func(i, j int) bool { return types.TypeString(outputs[i], nil) < types.TypeString(outputs[j], nil) })
+	sort.Slice(outputs, func(i, j int) bool { return TypeString(outputs[i]) < TypeString(outputs[j]) })
 	for _, root := range outputs {
 		// Depth-first search using a stack of trails through the provider map.
 		stk := [][]types.Type{{root}}
@@ -458,55 +1030,76 @@ func verifyAcyclic(providerMap *typeutil.Map, hasher typeutil.Hasher) []error {
 				continue
 			}
 			pt := x.(*ProvidedType)
+			var args []types.Type
 			switch {
-			case pt.IsValue():
-				// Leaf: values do not have dependencies.
+			case pt.IsValue() || pt.IsOptional():
+				// Leaf: values and optional bindings do not have dependencies.
 			case pt.IsArg():
 				// Injector arguments do not have dependencies.
-			case pt.IsProvider() || pt.IsField():
-				var args []types.Type
-				if pt.IsProvider() {
-					for _, arg := range pt.Provider().Args {
+			case pt.IsProvider():
+				for _, arg := range pt.Provider().Args {
+					args = append(args, arg.Type)
+				}
+			case pt.IsField():
+				args = append(args, pt.Field().Parent)
+			case pt.IsMultibinding():
+				args = append(args, pt.Multibinding().Elems...)
+			default:
+				panic("invalid provider map value")
+			}
+			// Decorators depend on their arguments other than the value
+			// they decorate.
+			for _, d := range pt.d {
+				for i, arg := range d.d.Provider.Args {
+					if i != d.d.decoratedArg() {
 						args = append(args, arg.Type)
 					}
-				} else {
-					args = append(args, pt.Field().Parent)
 				}
-				for _, a := range args {
-					hasCycle := false
-					for i, b := range curr {
-						if types.Identical(a, b) {
-							sb := new(strings.Builder)
-							fmt.Fprintf(sb, "cycle for %s:\n", types.TypeString(a, nil))
-							for j := i; j < len(curr); j++ {
-								t := providerMap.At(curr[j]).(*ProvidedType)
-								if t.IsProvider() {
-									p := t.Provider()
-									fmt.Fprintf(sb, "%s (%s.%s) ->\n", types.TypeString(curr[j], nil), p.Pkg.Path(), p.Name)
-								} else {
-									p := t.Field()
-									fmt.Fprintf(sb, "%s (%s.%s) ->\n", types.TypeString(curr[j], nil), p.Parent, p.Name)
-								}
+			}
+			for _, a := range args {
+				hasCycle := false
+				for i, b := range curr {
+					if types.Identical(a, b) {
+						sb := new(strings.Builder)
+						fmt.Fprintf(sb, "cycle for %s:\n", TypeString(a))
+						for j := i; j < len(curr); j++ {
+							t := providerMap.At(curr[j]).(*ProvidedType)
+							switch {
+							case t.IsProvider():
+								p := t.Provider()
+								fmt.Fprintf(sb, "%s (%s.%s) ->\n", TypeString(curr[j]), p.Pkg.Path(), p.Name)
+							case t.IsField():
+								p := t.Field()
+								fmt.Fprintf(sb, "%s (%s.%s) ->\n", TypeString(curr[j]), p.Parent, p.Name)
+							case t.IsMultibinding():
+								fmt.Fprintf(sb, "%s (%s) ->\n", TypeString(curr[j]), t.Multibinding().marker())
+							default:
+								fmt.Fprintf(sb, "%s ->\n", TypeString(curr[j]))
 							}
-							fmt.Fprintf(sb, "%s", types.TypeString(a, nil))
-							ec.add(errors.New(sb.String()))
-							hasCycle = true
-							break
 						}
-					}
-					if !hasCycle {
-						next := append(append([]types.Type(nil), curr...), a)
-						stk = append(stk, next)
+						fmt.Fprintf(sb, "%s", TypeString(a))
+						ec.add(errors.New(sb.String()))
+						hasCycle = true
+						break
 					}
 				}
-			default:
-				panic("invalid provider map value")
+				if !hasCycle {
+					next := append(append([]types.Type(nil), curr...), a)
+					stk = append(stk, next)
+				}
 			}
 		}
 	}
 	return ec.errors
 }
 
+// overrides reports whether a provider in set may replace the provider
+// described by prevSrc instead of conflicting with it. This is only the case
+// for the providers of the overridden set in a set created by wire.Override.
+func overrides(set *ProviderSet, prevSrc *providerSetSrc) bool {
+	return set.override && prevSrc.Import == set.Imports[0]
+}
+
 // bindingConflictError creates a new error describing multiple bindings
 // for the same output type.
 func bindingConflictError(fset *token.FileSet, typ types.Type, set *ProviderSet, cur, prev *providerSetSrc) error {
@@ -514,8 +1107,453 @@ func bindingConflictError(fset *token.FileSet, typ types.Type, set *ProviderSet,
 	if set.VarName != "" {
 		fmt.Fprintf(sb, "%s has ", set.VarName)
 	}
-	fmt.Fprintf(sb, "multiple bindings for %s\n", types.TypeString(typ, nil))
+	fmt.Fprintf(sb, "multiple bindings for %s\n", TypeString(typ))
 	fmt.Fprintf(sb, "current:\n<- %s\n", strings.Join(cur.trace(fset, typ), "\n<- "))
 	fmt.Fprintf(sb, "previous:\n<- %s", strings.Join(prev.trace(fset, typ), "\n<- "))
 	return notePosition(fset.Position(set.Pos), errors.New(sb.String()))
 }
+
+// A WhyStep is a step of the path from the output of an injector to a type
+// it needs, as returned by Why.
+type WhyStep struct {
+	// Type is the type needed at this step.
+	Type types.Type
+	// Pos is the position of what provides Type.
+	Pos token.Pos
+	// Trace describes what provides Type, followed by the provider sets it
+	// was imported through, innermost first.
+	Trace []string
+}
+
+// Why returns the shortest path from the output of an injector to a value
+// of the type named typ that it needs. typ is written as by TypeString, or
+// with package names instead of import paths. The first step is the
+// injector's output, and each following step is needed by the previous one.
+// An interface bound to a concrete type is a step of its own, followed by
+// the concrete type.
+func Why(fset *token.FileSet, in *Injector, typ string) ([]WhyStep, []error) {
+	given := in.Set.InjectorArgs.Tuple
+	calls, errs := solve(fset, in.Out, given, in.Set)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	// Nodes are the injector's arguments followed by the calls, like the
+	// arguments of calls.
+	outType := func(n int) types.Type {
+		if n < given.Len() {
+			return given.At(n).Type()
+		}
+		return calls[n-given.Len()].out
+	}
+	start := given.Len() + len(calls) - 1
+	if len(calls) == 0 {
+		start = in.Set.For(in.Out).Arg().Index
+	}
+	// An edge of the search goes from a node to the node of one of its
+	// arguments, with the type that the argument is needed as.
+	type edge struct {
+		from int
+		t    types.Type
+	}
+	prev := map[int]edge{start: {from: -1, t: in.Out}}
+	queue := []int{start}
+	found := -1
+	for len(queue) > 0 && found == -1 {
+		n := queue[0]
+		queue = queue[1:]
+		if e := prev[n]; matchesTypeString(e.t, typ) || matchesTypeString(outType(n), typ) {
+			found = n
+			break
+		}
+		if n < given.Len() {
+			continue
+		}
+		c := &calls[n-given.Len()]
+		for i, a := range c.args {
+			if _, seen := prev[a]; seen {
+				continue
+			}
+			t := outType(a)
+			if i < len(c.ins) {
+				t = c.ins[i]
+			}
+			prev[a] = edge{from: n, t: t}
+			queue = append(queue, a)
+		}
+	}
+	if found == -1 {
+		return nil, []error{fmt.Errorf("inject %s: does not need %s", in.FuncName, typ)}
+	}
+	var steps []WhyStep
+	step := func(t types.Type, src *providerSetSrc) {
+		if src == nil {
+			steps = append(steps, WhyStep{Type: t, Trace: []string{"zero value of optional input"}})
+			return
+		}
+		steps = append(steps, WhyStep{Type: t, Pos: src.pos(t), Trace: src.trace(fset, t)})
+	}
+	for n := found; n != -1; n = prev[n].from {
+		// Steps are added in reverse order, so the concrete type of a
+		// binding comes before the interface. The stages of decorated
+		// values are not in srcMap, so they are described by the decorated
+		// type.
+		t, out := unstage(prev[n].t), unstage(outType(n))
+		switch {
+		case n < given.Len():
+			src, _ := in.Set.srcMap.At(out).(*providerSetSrc)
+			step(out, src)
+		case calls[n-given.Len()].decorator != nil:
+			d := calls[n-given.Len()].decorator
+			steps = append(steps, WhyStep{Type: out, Pos: d.Pos, Trace: decoratorTrace(fset, in.Set, d)})
+		default:
+			step(out, calls[n-given.Len()].src)
+		}
+		// An interface bound to a concrete type is needed as t but provided
+		// as out.
+		if src, ok := in.Set.srcMap.At(t).(*providerSetSrc); ok && !types.Identical(t, out) {
+			if n == found && matchesTypeString(t, typ) {
+				// The path ends at the interface.
+				steps = steps[:len(steps)-1]
+			}
+			step(t, src)
+		}
+	}
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+	return steps, nil
+}
+
+// matchesTypeString reports whether t is written as s by TypeString, or by
+// TypeStringQualified with package names.
+func matchesTypeString(t types.Type, s string) bool {
+	return TypeString(t) == s || TypeStringQualified(t, (*types.Package).Name) == s
+}
+
+// unelement returns the element type of a key created by elementKey, or t if
+// it is not one.
+func unelement(t types.Type) types.Type {
+	if n, ok := t.(*types.Named); ok && n.Obj().Pkg() == elementPkg {
+		return n.TypeArgs().At(0)
+	}
+	return t
+}
+
+// unstage returns the decorated type of a stage key, or t if it is not one.
+func unstage(t types.Type) types.Type {
+	if n, ok := t.(*types.Named); ok && n.Obj().Pkg() == stagePkg {
+		return n.TypeArgs().At(0)
+	}
+	return t
+}
+
+// decoratorTrace describes decorator d, followed by the provider sets it was
+// imported into set through, innermost first. It returns nil if set does not
+// include d.
+func decoratorTrace(fset *token.FileSet, set *ProviderSet, d *Decorator) []string {
+	for _, sd := range set.Decorators {
+		if sd == d {
+			return []string{(&providerSetSrc{Decorator: d}).description(fset, nil)}
+		}
+	}
+	for _, imp := range set.Imports {
+		if tr := decoratorTrace(fset, imp, d); tr != nil {
+			return append(tr, (&providerSetSrc{Import: imp}).description(fset, nil))
+		}
+	}
+	return nil
+}
+
+// An UnusedItem is a provider, value, interface binding, field, decorator,
+// multibinding, contribution to a multibinding or optional binding of a
+// named provider set that no injector uses, as returned by Unused.
+type UnusedItem struct {
+	// Set is the named provider set that includes the item, directly or
+	// through an unnamed set.
+	Set ProviderSetID
+	// Pos is the position of the item.
+	Pos token.Pos
+	// Message describes the item, as in `unused provider "foo.NewBar"`.
+	Message string
+}
+
+// Unused returns the items of the named provider sets that injectors
+// include, directly or indirectly, that none of the injectors use. The items
+// are sorted by set and then by position. The items included directly by the
+// wire.Build calls of the injectors are not returned, since generating the
+// injectors fails if they are unused.
+func Unused(fset *token.FileSet, injectors []*Injector) []UnusedItem {
+	used := make(map[interface{}]bool)
+	// useMultibinding marks the calls to wire.SliceOf or wire.MapOf that
+	// contribute to the multibinding for t in set, which may be merged from
+	// the sets it imports, as used.
+	var useMultibinding func(set *ProviderSet, t types.Type)
+	useMultibinding = func(set *ProviderSet, t types.Type) {
+		pt, _ := set.providerMap.At(t).(*ProvidedType)
+		if pt == nil || !pt.IsMultibinding() {
+			return
+		}
+		for _, src := range pt.m.sources {
+			switch {
+			case src.Multibinding != nil:
+				used[src.Multibinding] = true
+			case src.Import != nil:
+				useMultibinding(src.Import, t)
+			}
+		}
+	}
+	for _, in := range injectors {
+		_, uses, errs := solveUses(fset, in.Out, in.Set.InjectorArgs.Tuple, in.Set)
+		if len(errs) > 0 {
+			// Load only returns injectors that it could solve.
+			continue
+		}
+		for _, u := range uses {
+			if u.d != nil {
+				used[u.d] = true
+				continue
+			}
+			useMultibinding(in.Set, u.t)
+			// Follow imported sets down to the item that provides the type.
+			src := u.src
+			for src != nil && src.Import != nil {
+				src = src.parent(u.t)
+			}
+			switch {
+			case src == nil:
+			case src.Provider != nil:
+				used[src.Provider] = true
+				if src.Provider.origin != nil {
+					used[src.Provider.origin] = true
+				}
+			case src.Binding != nil:
+				used[src.Binding] = true
+			case src.Value != nil:
+				used[src.Value] = true
+			case src.Field != nil:
+				used[src.Field] = true
+			case src.Optional != nil:
+				used[src.Optional] = true
+			}
+		}
+	}
+
+	var items []UnusedItem
+	visited := make(map[*ProviderSet]bool)
+	// visit adds the unused items of set, which is included in the named
+	// set named by id, or in a wire.Build call if id is the zero value.
+	var visit func(set *ProviderSet, id ProviderSetID)
+	visit = func(set *ProviderSet, id ProviderSetID) {
+		if visited[set] {
+			return
+		}
+		visited[set] = true
+		if set.VarName != "" {
+			id = ProviderSetID{ImportPath: set.PkgPath, VarName: set.VarName}
+		}
+		add := func(item interface{}, pos token.Pos, format string, args ...interface{}) {
+			if id.VarName != "" && !used[item] {
+				items = append(items, UnusedItem{Set: id, Pos: pos, Message: fmt.Sprintf(format, args...)})
+			}
+		}
+		// The contributions to multibindings are added to the providers
+		// and values of the set, but are reported with their multibinding.
+		contributes := make(map[interface{}]*Multibinding)
+		for _, m := range set.Multibindings {
+			for _, p := range m.providers {
+				contributes[p] = m
+			}
+			for _, v := range m.values {
+				contributes[v] = m
+			}
+		}
+		for _, p := range set.Providers {
+			if m := contributes[p]; m != nil {
+				add(p, p.Pos, "unused provider %q contributed to %s for %s", p.Pkg.Name()+"."+p.Name, m.marker(), TypeString(m.Out))
+				continue
+			}
+			add(p, p.Pos, "unused provider %q", p.Pkg.Name()+"."+p.Name)
+		}
+		for _, v := range set.Values {
+			if m := contributes[v]; m != nil {
+				add(v, v.Pos, "unused value of type %s contributed to %s for %s", TypeString(unelement(v.Out)), m.marker(), TypeString(m.Out))
+				continue
+			}
+			add(v, v.Pos, "unused value of type %s", TypeString(v.Out))
+		}
+		for _, b := range set.Bindings {
+			add(b, b.Pos, "unused interface binding to type %s", TypeString(b.Iface))
+		}
+		for _, f := range set.Fields {
+			add(f, f.Pos, "unused field %q.%s", f.Parent, f.Name)
+		}
+		for _, d := range set.Decorators {
+			add(d, d.Pos, "unused decorator %q", d.Provider.Pkg.Name()+"."+d.Provider.Name)
+		}
+		for _, m := range set.Multibindings {
+			add(m, m.Pos, "unused %s for %s", m.marker(), TypeString(m.Out))
+		}
+		for _, o := range set.Optionals {
+			// An optional binding with a default value is unused if another
+			// provider provides its type, so that the default is never used.
+			if o.Default != nil {
+				add(o, o.Default.Pos, "unused default value of wire.Optional for %s", TypeString(o.Out))
+				continue
+			}
+			add(o, o.Pos, "unused wire.Optional for %s", TypeString(o.Out))
+		}
+		for _, imp := range set.Imports {
+			visit(imp, id)
+		}
+	}
+	for _, in := range injectors {
+		visit(in.Set, ProviderSetID{})
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Set != items[j].Set {
+			if items[i].Set.ImportPath != items[j].Set.ImportPath {
+				return items[i].Set.ImportPath < items[j].Set.ImportPath
+			}
+			return items[i].Set.VarName < items[j].Set.VarName
+		}
+		return items[i].Pos < items[j].Pos
+	})
+	return items
+}
+
+// A ProviderChain describes how a provider set provides a type, as returned
+// by Info.ProviderAt.
+type ProviderChain struct {
+	// Type is the provided type.
+	Type types.Type
+	// Steps describe what provides Type. An interface bound to a concrete
+	// type is followed by what provides the concrete type, so the last step
+	// describes what creates the value.
+	Steps []WhyStep
+	// Decorators describe the decorators applied to the value, in the order
+	// they are applied.
+	Decorators []WhyStep
+}
+
+// ProviderAt returns how the type at offset in the file named filename is
+// provided, if it is the type of a parameter or result of an injector or
+// provider in the initial packages. The type is looked up in the provider
+// set of the injector, or for a provider, of the first injector that calls
+// it, or else of the first named set in the initial packages that includes
+// it. ProviderAt returns nil if there is no such type or nothing provides
+// it.
+func (info *Info) ProviderAt(filename string, offset int) *ProviderChain {
+	for _, pkg := range info.pkgs {
+		for _, f := range pkg.Syntax {
+			tf := info.Fset.File(f.Pos())
+			if tf.Name() != filename || offset > tf.Size() {
+				continue
+			}
+			pos := tf.Pos(offset)
+			path, _ := astutil.PathEnclosingInterval(f, pos, pos)
+			return info.providerAt(pkg, path)
+		}
+	}
+	return nil
+}
+
+func (info *Info) providerAt(pkg *packages.Package, path []ast.Node) *ProviderChain {
+	for i, n := range path {
+		fn, ok := n.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		// The path must go through a field of the function's parameters
+		// or results, as in fn.Type.Params.List[j].
+		if i < 3 || path[i-1] != fn.Type {
+			return nil
+		}
+		field, ok := path[i-3].(*ast.Field)
+		if !ok {
+			return nil
+		}
+		obj, _ := pkg.TypesInfo.Defs[fn.Name].(*types.Func)
+		t := pkg.TypesInfo.TypeOf(field.Type)
+		if obj == nil || t == nil {
+			return nil
+		}
+		for _, in := range info.Injectors {
+			if in.ImportPath == pkg.PkgPath && in.FuncName == fn.Name.Name {
+				return providerChain(info.Fset, in.Set, t)
+			}
+		}
+		for _, in := range info.Injectors {
+			calls, errs := solve(info.Fset, in.Out, in.Set.InjectorArgs.Tuple, in.Set)
+			if len(errs) > 0 {
+				continue
+			}
+			for _, c := range calls {
+				if c.kind == funcProviderCall && c.pkg == obj.Pkg() && c.name == obj.Name() {
+					return providerChain(info.Fset, in.Set, t)
+				}
+			}
+		}
+		ids := make([]ProviderSetID, 0, len(info.Sets))
+		for id := range info.Sets {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool {
+			if ids[i].ImportPath != ids[j].ImportPath {
+				return ids[i].ImportPath < ids[j].ImportPath
+			}
+			return ids[i].VarName < ids[j].VarName
+		})
+		for _, id := range ids {
+			if set := info.Sets[id]; includesProvider(set, obj) {
+				return providerChain(info.Fset, set, t)
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// includesProvider reports whether set includes the provider fn, directly
+// or through an imported set.
+func includesProvider(set *ProviderSet, fn *types.Func) bool {
+	for _, p := range set.Providers {
+		if p.Pkg == fn.Pkg() && p.Name == fn.Name() {
+			return true
+		}
+	}
+	for _, imp := range set.Imports {
+		if includesProvider(imp, fn) {
+			return true
+		}
+	}
+	return false
+}
+
+// providerChain returns how set provides t, or nil if it does not.
+func providerChain(fset *token.FileSet, set *ProviderSet, t types.Type) *ProviderChain {
+	pv := set.For(t)
+	if pv.IsNil() {
+		p, src, err := set.instantiate(fset, t)
+		if p == nil || err != nil {
+			return nil
+		}
+		return &ProviderChain{Type: t, Steps: []WhyStep{{Type: t, Pos: src.pos(t), Trace: src.trace(fset, t)}}}
+	}
+	chain := &ProviderChain{Type: t}
+	for {
+		src, ok := set.srcMap.At(t).(*providerSetSrc)
+		if !ok {
+			break
+		}
+		chain.Steps = append(chain.Steps, WhyStep{Type: t, Pos: src.pos(t), Trace: src.trace(fset, t)})
+		concrete := set.For(t).Type()
+		if types.Identical(concrete, t) {
+			break
+		}
+		t = concrete
+	}
+	for _, d := range pv.d {
+		chain.Decorators = append(chain.Decorators, WhyStep{Type: chain.Type, Pos: d.d.Pos, Trace: decoratorTrace(fset, set, d.d)})
+	}
+	return chain
+}
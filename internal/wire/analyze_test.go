@@ -17,6 +17,7 @@ package wire
 import (
 	"go/token"
 	"go/types"
+	"strings"
 	"testing"
 
 	"golang.org/x/tools/go/types/typeutil"
@@ -25,6 +26,7 @@ import (
 func TestBuildProviderMap(t *testing.T) {
 	fset := token.NewFileSet()
 	hasher := typeutil.MakeHasher()
+	q := newQualifiers()
 	pkg := testPkg("example.com/test", "test")
 	intT := types.Typ[types.Int]
 	stringT := types.Typ[types.String]
@@ -100,6 +102,264 @@ func TestBuildProviderMap(t *testing.T) {
 		}
 	})
 
+	t.Run("named providers of same type", func(t *testing.T) {
+		p1 := makeProvider(pkg, "NewPrimary", nil, []types.Type{intT})
+		p2 := makeProvider(pkg, "NewReplica", nil, []types.Type{mustQualify(t, q, intT, "replica")})
+		pset := &ProviderSet{
+			Pos:       token.NoPos,
+			PkgPath:   pkg.Path(),
+			Providers: []*Provider{p1, p2},
+		}
+		pm, _, errs := buildProviderMap(fset, hasher, pset)
+		assertNoErrors(t, errs)
+		if pm.At(intT) == nil {
+			t.Error("expected int type in provider map")
+		}
+		if pm.At(mustQualify(t, q, intT, "replica")) == nil {
+			t.Error("expected int type named \"replica\" in provider map")
+		}
+	})
+
+	t.Run("duplicate named providers", func(t *testing.T) {
+		p1 := makeProvider(pkg, "NewFoo", nil, []types.Type{mustQualify(t, q, intT, "replica")})
+		p2 := makeProvider(pkg, "NewBar", nil, []types.Type{mustQualify(t, q, intT, "replica")})
+		pset := &ProviderSet{
+			Pos:       token.NoPos,
+			PkgPath:   pkg.Path(),
+			Providers: []*Provider{p1, p2},
+		}
+		_, _, errs := buildProviderMap(fset, hasher, pset)
+		assertErrorContains(t, errs, `multiple bindings for int named "replica"`)
+	})
+
+	t.Run("multibindings merged across imports", func(t *testing.T) {
+		sliceT := types.NewSlice(intT)
+		newSet := func(name string) *ProviderSet {
+			elem := elementKey(intT, sliceT)
+			p := makeProvider(pkg, name, nil, []types.Type{elem})
+			pset := &ProviderSet{
+				Pos:           token.NoPos,
+				PkgPath:       pkg.Path(),
+				Providers:     []*Provider{p},
+				Multibindings: []*Multibinding{{Out: sliceT, Elems: []types.Type{elem}}},
+			}
+			var errs []error
+			pset.providerMap, pset.srcMap, errs = buildProviderMap(fset, hasher, pset)
+			assertNoErrors(t, errs)
+			return pset
+		}
+		a, b := newSet("NewA"), newSet("NewB")
+		pset := &ProviderSet{
+			Pos:     token.NoPos,
+			PkgPath: pkg.Path(),
+			Imports: []*ProviderSet{a, b},
+		}
+		pm, _, errs := buildProviderMap(fset, hasher, pset)
+		assertNoErrors(t, errs)
+		m := pm.At(sliceT).(*ProvidedType).Multibinding()
+		want := []types.Type{a.Multibindings[0].Elems[0], b.Multibindings[0].Elems[0]}
+		if len(m.Elems) != len(want) || m.Elems[0] != want[0] || m.Elems[1] != want[1] {
+			t.Errorf("merged elements = %v; want %v", m.Elems, want)
+		}
+	})
+
+	t.Run("multibinding conflicts with provider", func(t *testing.T) {
+		sliceT := types.NewSlice(intT)
+		p := makeProvider(pkg, "NewInts", nil, []types.Type{sliceT})
+		pset := &ProviderSet{
+			Pos:           token.NoPos,
+			PkgPath:       pkg.Path(),
+			Providers:     []*Provider{p},
+			Multibindings: []*Multibinding{{Out: sliceT}},
+		}
+		_, _, errs := buildProviderMap(fset, hasher, pset)
+		assertErrorContains(t, errs, "multiple bindings for []int")
+	})
+
+	t.Run("multibinding map keys conflict", func(t *testing.T) {
+		mapT := types.NewMap(stringT, intT)
+		pset := &ProviderSet{
+			Pos:     token.NoPos,
+			PkgPath: pkg.Path(),
+			Multibindings: []*Multibinding{
+				{Out: mapT, Keys: []string{"a"}, Elems: []types.Type{elementKey(intT, mapT)}},
+				{Out: mapT, Keys: []string{"a"}, Elems: []types.Type{elementKey(intT, mapT)}},
+			},
+		}
+		_, _, errs := buildProviderMap(fset, hasher, pset)
+		assertErrorContains(t, errs, `multiple values for key "a" of map[string]int`)
+	})
+
+	t.Run("provider overrides imported optional", func(t *testing.T) {
+		lib := &ProviderSet{
+			Pos:       token.NoPos,
+			PkgPath:   pkg.Path(),
+			Optionals: []*Optional{{Out: intT}},
+		}
+		var errs []error
+		lib.providerMap, lib.srcMap, errs = buildProviderMap(fset, hasher, lib)
+		assertNoErrors(t, errs)
+		if !lib.For(intT).IsOptional() {
+			t.Fatal("expected optional int in library set")
+		}
+
+		p := makeProvider(pkg, "NewInt", nil, []types.Type{intT})
+		pset := &ProviderSet{
+			Pos:       token.NoPos,
+			PkgPath:   pkg.Path(),
+			Providers: []*Provider{p},
+			Imports:   []*ProviderSet{lib},
+		}
+		pm, _, errs := buildProviderMap(fset, hasher, pset)
+		assertNoErrors(t, errs)
+		if pt := pm.At(intT).(*ProvidedType); !pt.IsProvider() {
+			t.Errorf("int is provided by %+v; want provider NewInt", pt)
+		}
+	})
+
+	t.Run("optional default overrides zero value", func(t *testing.T) {
+		zero := &Optional{Out: intT}
+		withDefault := &Optional{Out: intT, Default: &Value{Out: intT}}
+		pset := &ProviderSet{
+			Pos:       token.NoPos,
+			PkgPath:   pkg.Path(),
+			Optionals: []*Optional{zero, withDefault},
+		}
+		pm, _, errs := buildProviderMap(fset, hasher, pset)
+		assertNoErrors(t, errs)
+		if got := pm.At(intT).(*ProvidedType).Optional(); got != withDefault {
+			t.Errorf("optional = %+v; want the one with a default", got)
+		}
+	})
+
+	t.Run("optional defaults conflict", func(t *testing.T) {
+		pset := &ProviderSet{
+			Pos:     token.NoPos,
+			PkgPath: pkg.Path(),
+			Optionals: []*Optional{
+				{Out: intT, Default: &Value{Out: intT}},
+				{Out: intT, Default: &Value{Out: intT}},
+			},
+		}
+		_, _, errs := buildProviderMap(fset, hasher, pset)
+		assertErrorContains(t, errs, "multiple bindings for int")
+	})
+
+	t.Run("decorators applied in import order", func(t *testing.T) {
+		newDecorator := func(name string) *Decorator {
+			return &Decorator{Provider: makeProvider(pkg, name, []ProviderInput{{Type: intT}}, []types.Type{intT})}
+		}
+		d1, d2 := newDecorator("WithA"), newDecorator("WithB")
+		lib := &ProviderSet{
+			Pos:        token.NoPos,
+			PkgPath:    pkg.Path(),
+			Decorators: []*Decorator{d1},
+		}
+		var errs []error
+		lib.providerMap, lib.srcMap, errs = buildProviderMap(fset, hasher, lib)
+		assertNoErrors(t, errs)
+
+		p := makeProvider(pkg, "NewInt", nil, []types.Type{intT})
+		pset := &ProviderSet{
+			Pos:        token.NoPos,
+			PkgPath:    pkg.Path(),
+			Providers:  []*Provider{p},
+			Decorators: []*Decorator{d2},
+			Imports:    []*ProviderSet{lib},
+		}
+		pm, _, errs := buildProviderMap(fset, hasher, pset)
+		assertNoErrors(t, errs)
+		pt := pm.At(intT).(*ProvidedType)
+		if got := pt.Decorators(); len(got) != 2 || got[0] != d1 || got[1] != d2 {
+			t.Errorf("decorators = %v; want [WithA WithB]", got)
+		}
+		if pt.d[0].src.Import != lib || pt.d[1].src.Decorator != d2 {
+			t.Errorf("decorator sources = %+v, %+v; want import and decorator", pt.d[0].src, pt.d[1].src)
+		}
+	})
+
+	t.Run("binding does not inherit decorators", func(t *testing.T) {
+		ifaceT := types.NewInterfaceType(nil, nil)
+		p := makeProvider(pkg, "NewInt", nil, []types.Type{intT})
+		d := &Decorator{Provider: makeProvider(pkg, "WithA", []ProviderInput{{Type: intT}}, []types.Type{intT})}
+		lib := &ProviderSet{
+			Pos:        token.NoPos,
+			PkgPath:    pkg.Path(),
+			Providers:  []*Provider{p},
+			Decorators: []*Decorator{d},
+		}
+		var errs []error
+		lib.providerMap, lib.srcMap, errs = buildProviderMap(fset, hasher, lib)
+		assertNoErrors(t, errs)
+		pset := &ProviderSet{
+			Pos:      token.NoPos,
+			PkgPath:  pkg.Path(),
+			Bindings: []*IfaceBinding{{Iface: ifaceT, Provided: intT}},
+			Imports:  []*ProviderSet{lib},
+		}
+		pm, _, errs := buildProviderMap(fset, hasher, pset)
+		assertNoErrors(t, errs)
+		if got := pm.At(ifaceT).(*ProvidedType).Decorators(); len(got) != 0 {
+			t.Errorf("interface decorators = %v; want none", got)
+		}
+		if got := pm.At(intT).(*ProvidedType).Decorators(); len(got) != 1 {
+			t.Errorf("int decorators = %v; want [WithA]", got)
+		}
+	})
+
+	t.Run("override replaces provider", func(t *testing.T) {
+		prod := makeProvider(pkg, "NewInt", nil, []types.Type{intT})
+		base := &ProviderSet{
+			Pos:       token.NoPos,
+			PkgPath:   pkg.Path(),
+			Providers: []*Provider{prod, makeProvider(pkg, "NewString", nil, []types.Type{stringT})},
+		}
+		var errs []error
+		base.providerMap, base.srcMap, errs = buildProviderMap(fset, hasher, base)
+		assertNoErrors(t, errs)
+
+		fake := makeProvider(pkg, "NewFakeInt", nil, []types.Type{intT})
+		pset := &ProviderSet{
+			Pos:       token.NoPos,
+			PkgPath:   pkg.Path(),
+			Providers: []*Provider{fake},
+			Imports:   []*ProviderSet{base},
+			override:  true,
+		}
+		pm, sm, errs := buildProviderMap(fset, hasher, pset)
+		assertNoErrors(t, errs)
+		if got := pm.At(intT).(*ProvidedType).Provider(); got != fake {
+			t.Errorf("provider for int = %v; want NewFakeInt", got.Name)
+		}
+		if src := sm.At(stringT).(*providerSetSrc); src.Import != base {
+			t.Errorf("source for string = %+v; want import of base set", src)
+		}
+	})
+
+	t.Run("override does not allow duplicate replacements", func(t *testing.T) {
+		base := &ProviderSet{
+			Pos:       token.NoPos,
+			PkgPath:   pkg.Path(),
+			Providers: []*Provider{makeProvider(pkg, "NewInt", nil, []types.Type{intT})},
+		}
+		var errs []error
+		base.providerMap, base.srcMap, errs = buildProviderMap(fset, hasher, base)
+		assertNoErrors(t, errs)
+
+		pset := &ProviderSet{
+			Pos:     token.NoPos,
+			PkgPath: pkg.Path(),
+			Providers: []*Provider{
+				makeProvider(pkg, "NewFakeInt", nil, []types.Type{intT}),
+				makeProvider(pkg, "NewOtherFakeInt", nil, []types.Type{intT}),
+			},
+			Imports:  []*ProviderSet{base},
+			override: true,
+		}
+		_, _, errs = buildProviderMap(fset, hasher, pset)
+		assertErrorContains(t, errs, "multiple bindings")
+	})
+
 	t.Run("duplicate injector args", func(t *testing.T) {
 		args := &InjectorArgs{
 			Name:  "NewService",
@@ -218,6 +478,24 @@ func TestVerifyAcyclic(t *testing.T) {
 		assertErrorContains(t, errs, "cycle for")
 	})
 
+	t.Run("cycle through decorator", func(t *testing.T) {
+		typeA := makeNamedType("A")
+		typeB := makeNamedType("B")
+
+		pm := new(typeutil.Map)
+		pm.SetHasher(hasher)
+
+		pA := makeProvider(pkg, "NewA", nil, []types.Type{typeA})
+		pB := makeProvider(pkg, "NewB", []ProviderInput{{Type: typeA}}, []types.Type{typeB})
+		d := &Decorator{Provider: makeProvider(pkg, "WithB", []ProviderInput{{Type: typeA}, {Type: typeB}}, []types.Type{typeA})}
+
+		pm.Set(typeA, &ProvidedType{t: typeA, p: pA, d: []decoration{{d: d}}})
+		pm.Set(typeB, &ProvidedType{t: typeB, p: pB})
+
+		errs := verifyAcyclic(pm, hasher)
+		assertErrorContains(t, errs, "cycle for example.com/test.A")
+	})
+
 	t.Run("value provider (no cycle possible)", func(t *testing.T) {
 		typeA := makeNamedType("A")
 
@@ -233,6 +511,7 @@ func TestVerifyAcyclic(t *testing.T) {
 func TestSolve(t *testing.T) {
 	fset := token.NewFileSet()
 	pkg := testPkg("example.com/test", "test")
+	q := newQualifiers()
 
 	makeNamedType := func(name string) types.Type {
 		return types.NewNamed(
@@ -319,6 +598,281 @@ func TestSolve(t *testing.T) {
 		assertErrorContains(t, errs, "no provider found")
 	})
 
+	t.Run("named argument", func(t *testing.T) {
+		typeA := makeNamedType("A")
+		typeB := makeNamedType("B")
+		namedA := mustQualify(t, q, typeA, "other")
+
+		pA := makeProvider(pkg, "NewA", nil, []types.Type{typeA})
+		pOther := makeProvider(pkg, "NewOtherA", nil, []types.Type{namedA})
+		pB := makeProvider(pkg, "NewB", []ProviderInput{{Type: typeA}, {Type: namedA}}, []types.Type{typeB})
+		set := makeProviderSet(t, []*Provider{pA, pOther, pB}, nil, nil, nil, nil)
+
+		calls, errs := solve(fset, typeB, vars(), set)
+		assertNoErrors(t, errs)
+		if len(calls) != 3 {
+			t.Fatalf("got %d calls; want 3", len(calls))
+		}
+		if calls[0].name != "NewA" || calls[1].name != "NewOtherA" {
+			t.Errorf("calls = %q, %q; want NewA, NewOtherA", calls[0].name, calls[1].name)
+		}
+		if want := []int{0, 1}; calls[2].args[0] != want[0] || calls[2].args[1] != want[1] {
+			t.Errorf("NewB args = %v; want %v", calls[2].args, want)
+		}
+	})
+
+	t.Run("no provider for named type", func(t *testing.T) {
+		typeA := makeNamedType("A")
+		typeB := makeNamedType("B")
+
+		pA := makeProvider(pkg, "NewA", nil, []types.Type{typeA})
+		pB := makeProvider(pkg, "NewB", []ProviderInput{{Type: mustQualify(t, q, typeA, "other")}}, []types.Type{typeB})
+		set := makeProviderSet(t, []*Provider{pA, pB}, nil, nil, nil, nil)
+
+		_, errs := solve(fset, typeB, vars(), set)
+		assertErrorContains(t, errs, `no provider found for example.com/test.A named "other"`)
+	})
+
+	t.Run("optional struct field", func(t *testing.T) {
+		typeA := makeNamedType("A")
+		typeB := makeNamedType("B")
+		typeS := makeNamedType("S")
+
+		pa := makeProvider(pkg, "NewA", nil, []types.Type{typeA})
+		ps := makeProvider(pkg, "S", []ProviderInput{
+			{Type: typeA, FieldName: "A"},
+			{Type: typeB, FieldName: "B", Optional: true},
+		}, []types.Type{typeS}, withStruct())
+		set := makeProviderSet(t, []*Provider{pa, ps}, nil, nil, nil, nil)
+
+		calls, errs := solve(fset, typeS, vars(), set)
+		assertNoErrors(t, errs)
+		if len(calls) != 3 {
+			t.Fatalf("got %d calls; want 3", len(calls))
+		}
+		if c := calls[1]; c.kind != zeroValueExpr || !types.Identical(c.out, typeB) {
+			t.Errorf("calls[1] = %+v; want zero value of B", c)
+		}
+	})
+
+	t.Run("optional zero value still required elsewhere", func(t *testing.T) {
+		typeB := makeNamedType("B")
+		typeC := makeNamedType("C")
+		typeS := makeNamedType("S")
+
+		pc := makeProvider(pkg, "NewC", []ProviderInput{{Type: typeB}}, []types.Type{typeC})
+		ps := makeProvider(pkg, "S", []ProviderInput{
+			{Type: typeB, FieldName: "B", Optional: true},
+			{Type: typeC, FieldName: "C"},
+		}, []types.Type{typeS}, withStruct())
+		set := makeProviderSet(t, []*Provider{pc, ps}, nil, nil, nil, nil)
+
+		_, errs := solve(fset, typeS, vars(), set)
+		assertErrorContains(t, errs, "no provider found for example.com/test.B")
+	})
+
+	t.Run("optional binding", func(t *testing.T) {
+		typeA := makeNamedType("A")
+		typeB := makeNamedType("B")
+
+		p := makeProvider(pkg, "NewB", []ProviderInput{{Type: typeA}}, []types.Type{typeB})
+		o := &Optional{Out: typeA}
+		set := &ProviderSet{Providers: []*Provider{p}, Optionals: []*Optional{o}}
+		var errs []error
+		set.providerMap, set.srcMap, errs = buildProviderMap(fset, typeutil.MakeHasher(), set)
+		assertNoErrors(t, errs)
+
+		calls, errs := solve(fset, typeB, vars(), set)
+		assertNoErrors(t, errs)
+		if len(calls) != 2 || calls[0].kind != zeroValueExpr {
+			t.Fatalf("calls = %+v; want zero value of A then NewB", calls)
+		}
+	})
+
+	t.Run("decorator chain", func(t *testing.T) {
+		typeA := makeNamedType("A")
+		typeB := makeNamedType("B")
+
+		pa := makeProvider(pkg, "NewA", nil, []types.Type{typeA})
+		pb := makeProvider(pkg, "NewB", nil, []types.Type{typeB})
+		d1 := &Decorator{Provider: makeProvider(pkg, "WithLogging", []ProviderInput{{Type: typeA}}, []types.Type{typeA})}
+		d2 := &Decorator{Provider: makeProvider(pkg, "WithB", []ProviderInput{{Type: typeB}, {Type: typeA}}, []types.Type{typeA}, withErr())}
+		set := &ProviderSet{Providers: []*Provider{pa, pb}, Decorators: []*Decorator{d1, d2}}
+		var errs []error
+		set.providerMap, set.srcMap, errs = buildProviderMap(fset, typeutil.MakeHasher(), set)
+		assertNoErrors(t, errs)
+
+		calls, errs := solve(fset, typeA, vars(), set)
+		assertNoErrors(t, errs)
+		var names []string
+		for _, c := range calls {
+			names = append(names, c.name)
+		}
+		if want := []string{"NewB", "NewA", "WithLogging", "WithB"}; strings.Join(names, ",") != strings.Join(want, ",") {
+			t.Fatalf("calls = %v; want %v", names, want)
+		}
+		if args := calls[2].args; len(args) != 1 || args[0] != 1 {
+			t.Errorf("WithLogging args = %v; want [1]", args)
+		}
+		if args := calls[3].args; len(args) != 2 || args[0] != 0 || args[1] != 2 {
+			t.Errorf("WithB args = %v; want [0 2]", args)
+		}
+		if !calls[3].hasErr || !types.Identical(calls[3].out, typeA) {
+			t.Errorf("last call = %+v; want WithB returning A and an error", calls[3])
+		}
+	})
+
+	t.Run("decorated injector argument", func(t *testing.T) {
+		typeA := makeNamedType("A")
+
+		d := &Decorator{Provider: makeProvider(pkg, "WithLogging", []ProviderInput{{Type: typeA}}, []types.Type{typeA})}
+		given := vars(typeA)
+		set := &ProviderSet{
+			Decorators:   []*Decorator{d},
+			InjectorArgs: &InjectorArgs{Name: "inject", Tuple: given},
+		}
+		var errs []error
+		set.providerMap, set.srcMap, errs = buildProviderMap(fset, typeutil.MakeHasher(), set)
+		assertNoErrors(t, errs)
+
+		calls, errs := solve(fset, typeA, given, set)
+		assertNoErrors(t, errs)
+		if len(calls) != 1 || calls[0].name != "WithLogging" || calls[0].args[0] != 0 {
+			t.Fatalf("calls = %+v; want WithLogging of the argument", calls)
+		}
+	})
+
+	t.Run("multibinding", func(t *testing.T) {
+		typeA := makeNamedType("A")
+		sliceT := types.NewSlice(typeA)
+		e1, e2 := elementKey(typeA, sliceT), elementKey(typeA, sliceT)
+
+		p1 := makeProvider(pkg, "NewA1", nil, []types.Type{e1})
+		p2 := makeProvider(pkg, "NewA2", nil, []types.Type{e2})
+		m := &Multibinding{Out: sliceT, Elems: []types.Type{e1, e2}}
+		set := &ProviderSet{Providers: []*Provider{p1, p2}, Multibindings: []*Multibinding{m}}
+		var errs []error
+		set.providerMap, set.srcMap, errs = buildProviderMap(fset, typeutil.MakeHasher(), set)
+		assertNoErrors(t, errs)
+
+		calls, errs := solve(fset, sliceT, vars(), set)
+		assertNoErrors(t, errs)
+		if len(calls) != 3 {
+			t.Fatalf("got %d calls; want 3", len(calls))
+		}
+		if calls[0].name != "NewA1" || calls[1].name != "NewA2" {
+			t.Errorf("calls = %q, %q; want NewA1, NewA2", calls[0].name, calls[1].name)
+		}
+		if c := calls[2]; c.kind != collectionLit || len(c.args) != 2 || c.args[0] != 0 || c.args[1] != 1 {
+			t.Errorf("last call = %+v; want slice of calls 0 and 1", c)
+		}
+	})
+
+	t.Run("injector outputs struct", func(t *testing.T) {
+		typeA := makeNamedType("A")
+		typeB := makeNamedType("B")
+		typeC := makeNamedType("C")
+		wirePkg := types.NewPackage("github.com/almondoo/wire", "wire")
+		outputsT := types.NewNamed(types.NewTypeName(token.NoPos, wirePkg, "Outputs", nil), types.NewStruct(nil, nil), nil)
+		typeApp := types.NewNamed(
+			types.NewTypeName(token.NoPos, pkg, "App", nil),
+			types.NewStruct([]*types.Var{
+				types.NewField(token.NoPos, wirePkg, "Outputs", outputsT, true),
+				types.NewField(token.NoPos, pkg, "B", typeB, false),
+				types.NewField(token.NoPos, pkg, "C", typeC, false),
+			}, nil),
+			nil,
+		)
+
+		pa := makeProvider(pkg, "NewA", nil, []types.Type{typeA})
+		pb := makeProvider(pkg, "NewB", []ProviderInput{{Type: typeA}}, []types.Type{typeB})
+		pc := makeProvider(pkg, "NewC", []ProviderInput{{Type: typeA}}, []types.Type{typeC}, withErr())
+		set := makeProviderSet(t, []*Provider{pa, pb, pc}, nil, nil, nil, nil)
+
+		calls, errs := solve(fset, typeApp, vars(), set)
+		assertNoErrors(t, errs)
+		if len(calls) != 4 {
+			t.Fatalf("got %d calls; want 4", len(calls))
+		}
+		if calls[0].name != "NewA" || calls[1].name != "NewB" || calls[2].name != "NewC" {
+			t.Errorf("calls = %q, %q, %q; want NewA, NewB, NewC", calls[0].name, calls[1].name, calls[2].name)
+		}
+		c := calls[3]
+		if c.kind != structProvider || c.name != "App" || len(c.args) != 2 || c.args[0] != 1 || c.args[1] != 2 {
+			t.Errorf("last call = %+v; want App of calls 1 and 2", c)
+		}
+		if len(c.fieldNames) != 2 || c.fieldNames[0] != "B" || c.fieldNames[1] != "C" {
+			t.Errorf("field names = %q; want [B C]", c.fieldNames)
+		}
+	})
+
+	t.Run("injector outputs struct missing field provider", func(t *testing.T) {
+		typeB := makeNamedType("B")
+		wirePkg := types.NewPackage("github.com/almondoo/wire", "wire")
+		outputsT := types.NewNamed(types.NewTypeName(token.NoPos, wirePkg, "Outputs", nil), types.NewStruct(nil, nil), nil)
+		typeApp := types.NewNamed(
+			types.NewTypeName(token.NoPos, pkg, "App", nil),
+			types.NewStruct([]*types.Var{
+				types.NewField(token.NoPos, wirePkg, "Outputs", outputsT, true),
+				types.NewField(token.NoPos, pkg, "B", typeB, false),
+			}, nil),
+			nil,
+		)
+		set := makeProviderSet(t, nil, nil, nil, nil, nil)
+
+		_, errs := solve(fset, types.NewPointer(typeApp), vars(), set)
+		assertErrorContains(t, errs, "no provider found for example.com/test.B")
+		assertErrorContains(t, errs, "injector outputs \"App\"")
+	})
+
+	t.Run("component interface", func(t *testing.T) {
+		typeA := makeNamedType("A")
+		typeB := makeNamedType("B")
+		wirePkg := types.NewPackage("github.com/almondoo/wire", "wire")
+		componentT := types.NewNamed(types.NewTypeName(token.NoPos, wirePkg, "Component", nil), types.NewInterfaceType(nil, nil), nil)
+		method := func(name string, result types.Type) *types.Func {
+			return types.NewFunc(token.NoPos, pkg, name, types.NewSignatureType(nil, nil, nil, nil, vars(result), false))
+		}
+		typeApp := types.NewNamed(
+			types.NewTypeName(token.NoPos, pkg, "App", nil),
+			types.NewInterfaceType([]*types.Func{method("A", typeA), method("B", typeB)}, []types.Type{componentT}).Complete(),
+			nil,
+		)
+
+		pa := makeProvider(pkg, "NewA", nil, []types.Type{typeA})
+		pb := makeProvider(pkg, "NewB", []ProviderInput{{Type: typeA}}, []types.Type{typeB})
+		set := makeProviderSet(t, []*Provider{pa, pb}, nil, nil, nil, nil)
+
+		calls, errs := solve(fset, typeApp, vars(), set)
+		assertNoErrors(t, errs)
+		if len(calls) != 3 {
+			t.Fatalf("got %d calls; want 3", len(calls))
+		}
+		c := calls[2]
+		if c.kind != componentLit || len(c.args) != 2 || c.args[0] != 0 || c.args[1] != 1 {
+			t.Errorf("last call = %+v; want App of calls 0 and 1", c)
+		}
+		if len(c.fieldNames) != 2 || c.fieldNames[0] != "A" || c.fieldNames[1] != "B" {
+			t.Errorf("method names = %q; want [A B]", c.fieldNames)
+		}
+	})
+
+	t.Run("component method with parameters", func(t *testing.T) {
+		typeA := makeNamedType("A")
+		wirePkg := types.NewPackage("github.com/almondoo/wire", "wire")
+		componentT := types.NewNamed(types.NewTypeName(token.NoPos, wirePkg, "Component", nil), types.NewInterfaceType(nil, nil), nil)
+		m := types.NewFunc(token.NoPos, pkg, "A", types.NewSignatureType(nil, nil, nil, vars(typeA), vars(typeA), false))
+		typeApp := types.NewNamed(
+			types.NewTypeName(token.NoPos, pkg, "App", nil),
+			types.NewInterfaceType([]*types.Func{m}, []types.Type{componentT}).Complete(),
+			nil,
+		)
+		set := makeProviderSet(t, nil, nil, nil, nil, nil)
+
+		_, errs := solve(fset, typeApp, vars(), set)
+		assertErrorContains(t, errs, "component method A must have no parameters and return a single value")
+	})
+
 	t.Run("provider with cleanup", func(t *testing.T) {
 		typeA := makeNamedType("A")
 		p := makeProvider(pkg, "NewA", nil, []types.Type{typeA}, withCleanup())
@@ -371,6 +925,23 @@ func TestVerifyArgsUsed(t *testing.T) {
 		assertNoErrors(t, errs)
 	})
 
+	t.Run("unused decorator", func(t *testing.T) {
+		typeA := makeNamedType("A")
+		d := &Decorator{Provider: makeProvider(pkg, "WithLogging", []ProviderInput{{Type: typeA}}, []types.Type{typeA})}
+		set := &ProviderSet{Decorators: []*Decorator{d}}
+
+		errs := verifyArgsUsed(set, nil)
+		assertErrorContains(t, errs, `unused decorator "test.WithLogging"`)
+	})
+
+	t.Run("unused optional", func(t *testing.T) {
+		typeA := makeNamedType("A")
+		set := &ProviderSet{Optionals: []*Optional{{Out: typeA}}}
+
+		errs := verifyArgsUsed(set, nil)
+		assertErrorContains(t, errs, "unused wire.Optional for example.com/test.A")
+	})
+
 	t.Run("unused provider", func(t *testing.T) {
 		typeA := makeNamedType("A")
 		p := makeProvider(pkg, "NewA", nil, []types.Type{typeA})
@@ -456,3 +1027,121 @@ func TestBindingConflictError(t *testing.T) {
 		t.Error("expected non-empty error message")
 	}
 }
+
+func TestUnify(t *testing.T) {
+	pkg := testPkg("example.com/test", "test")
+	anyT := types.Universe.Lookup("any").Type()
+	intT := types.Typ[types.Int]
+	stringT := types.Typ[types.String]
+	k := types.NewTypeParam(types.NewTypeName(token.NoPos, pkg, "K", nil), anyT)
+	v := types.NewTypeParam(types.NewTypeName(token.NoPos, pkg, "V", nil), anyT)
+	// Type parameters are indexed by the signature that declares them.
+	tparams := types.NewSignatureType(nil, nil, []*types.TypeParam{k, v}, nil, nil, false).TypeParams()
+	pair := types.NewNamed(types.NewTypeName(token.NoPos, pkg, "Pair", nil), nil, nil)
+	pair.SetTypeParams([]*types.TypeParam{
+		types.NewTypeParam(types.NewTypeName(token.NoPos, pkg, "A", nil), anyT),
+		types.NewTypeParam(types.NewTypeName(token.NoPos, pkg, "B", nil), anyT),
+	})
+	pair.SetUnderlying(types.NewStruct(nil, nil))
+	instantiate := func(args ...types.Type) types.Type {
+		t.Helper()
+		inst, err := types.Instantiate(nil, pair, args, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return inst
+	}
+	other := types.NewNamed(types.NewTypeName(token.NoPos, pkg, "Other", nil), types.NewStruct(nil, nil), nil)
+
+	tests := []struct {
+		name  string
+		x, t  types.Type
+		want  bool
+		wantK types.Type
+		wantV types.Type
+	}{
+		{"type parameter", k, intT, true, intT, nil},
+		{"pointer", types.NewPointer(k), types.NewPointer(intT), true, intT, nil},
+		{"pointer mismatch", types.NewPointer(k), intT, false, nil, nil},
+		{"slice", types.NewSlice(v), types.NewSlice(stringT), true, nil, stringT},
+		{"map", types.NewMap(k, v), types.NewMap(stringT, intT), true, stringT, intT},
+		{"repeated", types.NewMap(k, k), types.NewMap(stringT, intT), false, nil, nil},
+		{"array", types.NewArray(k, 2), types.NewArray(intT, 3), false, nil, nil},
+		{"chan", types.NewChan(types.RecvOnly, k), types.NewChan(types.RecvOnly, intT), true, intT, nil},
+		{"chan direction", types.NewChan(types.RecvOnly, k), types.NewChan(types.SendRecv, intT), false, nil, nil},
+		{"named", instantiate(k, types.NewSlice(v)), instantiate(intT, types.NewSlice(stringT)), true, intT, stringT},
+		{"named mismatch", instantiate(k, v), other, false, nil, nil},
+		{"identical", other, other, true, nil, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			targs := make([]types.Type, 2)
+			got := unify(test.x, test.t, tparams, targs)
+			if got != test.want {
+				t.Fatalf("unify(%v, %v) = %t; want %t", test.x, test.t, got, test.want)
+			}
+			if !got {
+				return
+			}
+			for i, want := range []types.Type{test.wantK, test.wantV} {
+				if (targs[i] == nil) != (want == nil) || want != nil && !types.Identical(targs[i], want) {
+					t.Errorf("type argument %d = %v; want %v", i, targs[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestSolveGenericProvider(t *testing.T) {
+	fset := token.NewFileSet()
+	pkg := testPkg("example.com/test", "test")
+	anyT := types.Universe.Lookup("any").Type()
+	intT := types.Typ[types.Int]
+
+	box := types.NewNamed(types.NewTypeName(token.NoPos, pkg, "Box", nil), nil, nil)
+	box.SetTypeParams([]*types.TypeParam{types.NewTypeParam(types.NewTypeName(token.NoPos, pkg, "E", nil), anyT)})
+	box.SetUnderlying(types.NewStruct(nil, nil))
+	boxOf := func(arg types.Type) types.Type {
+		t.Helper()
+		inst, err := types.Instantiate(nil, box, []types.Type{arg}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return types.NewPointer(inst)
+	}
+	// func NewBox[T any](T) *Box[T]
+	tparam := types.NewTypeParam(types.NewTypeName(token.NoPos, pkg, "T", nil), anyT)
+	sig := types.NewSignatureType(nil, nil, []*types.TypeParam{tparam}, vars(tparam), vars(boxOf(tparam)), false)
+	g, errs := processFuncProvider(fset, makeFunc(pkg, "NewBox", sig))
+	assertNoErrors(t, errs)
+	if g.generic == nil {
+		t.Fatal("processFuncProvider did not create a generic provider")
+	}
+
+	inner := makeProviderSet(t, []*Provider{g, makeProvider(pkg, "NewInt", nil, []types.Type{intT})}, nil, nil, nil, nil)
+	if inner.For(g.Out[0]).IsProvider() {
+		t.Error("provider map includes the output of a generic provider")
+	}
+	outer := &ProviderSet{Imports: []*ProviderSet{inner}}
+	outer.providerMap, outer.srcMap, errs = buildProviderMap(fset, typeutil.MakeHasher(), outer)
+	assertNoErrors(t, errs)
+
+	calls, errs := solve(fset, boxOf(intT), vars(), outer)
+	assertNoErrors(t, errs)
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls; want 2", len(calls))
+	}
+	c := calls[1]
+	if c.name != "NewBox" || len(c.typeArgs) != 1 || c.typeArgs[0] != intT || c.args[0] != 0 {
+		t.Errorf("call = %s%v(%v); want NewBox[int](0)", c.name, c.typeArgs, c.args)
+	}
+	if c.src.Import != inner || c.src.next == nil || c.src.next.Provider == nil || c.src.next.Provider.origin != g {
+		t.Errorf("call source does not lead through the imported set to an instance of NewBox")
+	}
+	if pos := c.src.pos(c.out); pos != g.Pos {
+		t.Errorf("call source position = %v; want the position of NewBox", pos)
+	}
+	if errs := verifyArgsUsed(inner, []*providerSetSrc{c.src.parent(c.out), calls[0].src.parent(calls[0].out)}); len(errs) > 0 {
+		t.Errorf("verifyArgsUsed reports the generic provider as unused: %v", errs)
+	}
+}
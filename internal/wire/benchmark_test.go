@@ -15,10 +15,14 @@
 package wire
 
 import (
+	"context"
 	"fmt"
 	"go/ast"
 	"go/token"
 	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"golang.org/x/tools/go/types/typeutil"
@@ -196,3 +200,101 @@ func BenchmarkCopyAST(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkLoad(b *testing.B) {
+	dir := b.TempDir()
+	writeLoadBenchmarkFixture(b, dir, 25, 40)
+	env := integrationEnv()
+
+	for _, skip := range []bool{false, true} {
+		b.Run(fmt.Sprintf("skipBodies=%t", skip), func(b *testing.B) {
+			defer func(old bool) { skipDependencyBodies = old }(skipDependencyBodies)
+			skipDependencyBodies = skip
+			ctx := context.Background()
+			// Warm up the build cache, so that the first iteration does not
+			// pay for go list computing it.
+			if _, errs := Load(ctx, dir, env, "", []string{"."}, nil); len(errs) > 0 {
+				b.Fatalf("Load failed: %v", errs)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, errs := Load(ctx, dir, env, "", []string{"."}, nil); len(errs) > 0 {
+					b.Fatalf("Load failed: %v", errs)
+				}
+			}
+		})
+	}
+}
+
+// writeLoadBenchmarkFixture writes a module with an injector that uses a
+// provider set from package dep, which has files files of funcs functions
+// each. The functions have bodies of the size and shape of ordinary code, and
+// dep imports net/http, so that most of the work of loading the module is
+// type-checking function bodies of dependencies, as in a real program.
+func writeLoadBenchmarkFixture(b *testing.B, dir string, files, funcs int) {
+	b.Helper()
+	writeIntegrationModule(b, dir)
+	if err := os.Mkdir(filepath.Join(dir, "dep"), 0o755); err != nil {
+		b.Fatal(err)
+	}
+	writeIntegrationFile(b, filepath.Join(dir, "dep", "dep.go"), `package dep
+
+import (
+	"net/http"
+
+	"github.com/almondoo/wire"
+)
+
+type Config struct {
+	Addr string
+}
+
+func NewServer(cfg Config) *http.Server {
+	return &http.Server{Addr: cfg.Addr}
+}
+
+var Set = wire.NewSet(NewServer, wire.Value(Config{Addr: ":8080"}))
+`)
+	for i := 0; i < files; i++ {
+		var sb strings.Builder
+		sb.WriteString("package dep\n\nimport (\n\t\"fmt\"\n\t\"sort\"\n\t\"strings\"\n)\n")
+		for j := 0; j < funcs; j++ {
+			fmt.Fprintf(&sb, `
+func f%d_%d(xs []string) (map[string]int, error) {
+	counts := make(map[string]int)
+	for i, x := range xs {
+		x = strings.TrimSpace(x)
+		if x == "" {
+			return nil, fmt.Errorf("element %%d is empty", i)
+		}
+		counts[strings.ToLower(x)]++
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return counts[keys[i]] < counts[keys[j]] })
+	return counts, nil
+}
+`, i, j)
+		}
+		writeIntegrationFile(b, filepath.Join(dir, "dep", fmt.Sprintf("f%d.go", i)), sb.String())
+	}
+	writeIntegrationFile(b, filepath.Join(dir, "wire.go"), `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import (
+	"net/http"
+
+	"example.com/wiretest/dep"
+	"github.com/almondoo/wire"
+)
+
+func InitializeServer() *http.Server {
+	wire.Build(dep.Set)
+	return nil
+}
+`)
+}
@@ -0,0 +1,426 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wire
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// DefaultCacheDir returns the directory of the cache of generated code that
+// the wire command uses, in the user cache directory.
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "wire"), nil
+}
+
+const (
+	// cacheMtimeInterval is how often the modification time of a cache
+	// entry is updated when the entry is used, which records when it was
+	// last used without writing to the file system on every hit.
+	cacheMtimeInterval = 1 * time.Hour
+	// cacheTrimInterval is how often the cache is trimmed.
+	cacheTrimInterval = 24 * time.Hour
+	// cacheTrimLimit is how long an entry is kept after it was last used.
+	cacheTrimLimit = 5 * 24 * time.Hour
+	// cacheTrimFile is the file in the cache directory that records when
+	// the cache was last trimmed.
+	cacheTrimFile = "trim.txt"
+)
+
+// generateCached is Generate with the cache in opts.CacheDir.
+//
+// The code generated for a package is stored under a key that hashes the
+// build of Wire, the options that affect the generated code, the build
+// flags and the go command environment used to load packages, and the files
+// of the package and of every package it depends on, directly or
+// indirectly. Listing the packages and their files is much cheaper than
+// loading their syntax and types, so when no package changed, Generate does
+// not load or solve anything. The files of dependencies are hashed rather
+// than their export data, because export data does not record the
+// expressions passed to wire.Value, which are copied into the generated
+// code.
+//
+// Like the go build cache, the cache is trimmed at most once a day, removing
+// the entries that were not used in the last five days.
+func generateCached(ctx context.Context, wd string, env []string, patterns []string, opts *GenerateOptions) ([]GenerateResult, []error) {
+	uncached := *opts
+	uncached.CacheDir = ""
+	exe, err := executableHash()
+	if err != nil {
+		// Without the build of Wire in the keys, the cache could return code
+		// generated by another version of Wire.
+		return Generate(ctx, wd, env, patterns, &uncached)
+	}
+	goEnv, err := goEnvHash(ctx, wd, env)
+	if err != nil {
+		// Without the environment in the keys, the cache could return code
+		// generated for another platform or with other build flags.
+		return Generate(ctx, wd, env, patterns, &uncached)
+	}
+	cfg := loadConfig(ctx, wd, env, opts.Tags, opts.Overlay)
+	cfg.Mode = packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps
+	roots, err := packages.Load(cfg, escapePatterns(patterns)...)
+	if err != nil {
+		return nil, []error{err}
+	}
+	for _, p := range roots {
+		if len(p.Errors) > 0 || p.PkgPath == "command-line-arguments" {
+			// Let load report the errors, and load the packages named by
+			// files, which cannot be loaded again by their import path.
+			return Generate(ctx, wd, env, patterns, &uncached)
+		}
+	}
+
+	defer trimCache(opts.CacheDir, time.Now())
+	k := &cacheKeyer{
+		exe:        exe,
+		goEnv:      goEnv,
+		buildFlags: cfg.BuildFlags,
+		opts:       opts,
+		overlay:    opts.Overlay,
+		digests:    make(map[*packages.Package][]byte),
+	}
+	results := make([]GenerateResult, len(roots))
+	keys := make([]string, len(roots))
+	var missed []int
+	for i, p := range roots {
+		results[i].PkgPath = p.PkgPath
+		key, err := k.key(p)
+		if err == nil {
+			keys[i] = key
+			if res, ok := readCachedResult(opts, p, key); ok {
+				results[i] = res
+				continue
+			}
+		}
+		missed = append(missed, i)
+	}
+	if len(missed) == 0 {
+		return results, nil
+	}
+
+	missedPatterns := make([]string, len(missed))
+	for j, i := range missed {
+		missedPatterns[j] = roots[i].PkgPath
+	}
+	pkgs, errs := load(ctx, wd, env, opts.Tags, missedPatterns, opts.Overlay)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	generated := make(map[string]GenerateResult, len(pkgs))
+	for _, res := range generatePackages(pkgs, opts) {
+		generated[res.PkgPath] = res
+	}
+	for _, i := range missed {
+		res, ok := generated[roots[i].PkgPath]
+		if !ok {
+			results[i].Errs = []error{fmt.Errorf("package %s was not loaded again", roots[i].PkgPath)}
+			continue
+		}
+		results[i] = res
+		if len(res.Errs) == 0 && keys[i] != "" {
+			// The cache only saves time, so failing to write to it is
+			// not an error.
+			_ = writeCacheEntry(opts.CacheDir, keys[i], res.Content)
+		}
+	}
+	return results, nil
+}
+
+// readCachedResult returns the result of Generate for p stored under key,
+// and whether there is one.
+func readCachedResult(opts *GenerateOptions, p *packages.Package, key string) (GenerateResult, bool) {
+	outDir, err := detectOutputDir(p.GoFiles)
+	if err != nil {
+		return GenerateResult{}, false
+	}
+	path := filepath.Join(opts.CacheDir, key)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return GenerateResult{}, false
+	}
+	markCacheEntryUsed(path, time.Now())
+	if len(content) == 0 {
+		// The package has no injectors.
+		content = nil
+	}
+	return GenerateResult{
+		PkgPath:    p.PkgPath,
+		OutputPath: filepath.Join(outDir, opts.PrefixOutputFile+"wire_gen.go"),
+		Content:    content,
+	}, true
+}
+
+// writeCacheEntry stores content under key in dir. It writes to a temporary
+// file first, so that a concurrent run of Wire never reads a partial entry.
+func writeCacheEntry(dir, key string, content []byte) error {
+	if err := os.MkdirAll(dir, 0o777); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, key))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// markCacheEntryUsed records that the cache entry at path was used at now,
+// unless it was already marked in the last cacheMtimeInterval.
+func markCacheEntryUsed(path string, now time.Time) {
+	info, err := os.Stat(path)
+	if err != nil || now.Sub(info.ModTime()) < cacheMtimeInterval {
+		return
+	}
+	// An entry whose time is not updated is only removed earlier.
+	_ = os.Chtimes(path, now, now)
+}
+
+// trimCache removes the entries of the cache in dir that were not used in
+// the cacheTrimLimit before now, unless the cache was trimmed in the last
+// cacheTrimInterval. Failing to trim the cache is not an error, so nothing
+// is returned.
+func trimCache(dir string, now time.Time) {
+	trimFile := filepath.Join(dir, cacheTrimFile)
+	if b, err := os.ReadFile(trimFile); err == nil {
+		t, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+		if err == nil && now.Sub(time.Unix(t, 0)) < cacheTrimInterval {
+			return
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	cutoff := now.Add(-cacheTrimLimit)
+	for _, e := range entries {
+		if e.Name() == cacheTrimFile {
+			continue
+		}
+		// Temporary files left by a run of Wire that was killed are removed
+		// as well.
+		info, err := e.Info()
+		if err == nil && info.ModTime().Before(cutoff) {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+	_ = writeCacheEntry(dir, cacheTrimFile, []byte(strconv.FormatInt(now.Unix(), 10)))
+}
+
+// cacheKeyer computes the keys of the cache entries of packages.
+type cacheKeyer struct {
+	exe []byte
+	// goEnv is the hash returned by goEnvHash.
+	goEnv      []byte
+	buildFlags []string
+	opts       *GenerateOptions
+	overlay    map[string][]byte
+	// digests maps a package to the hash of its files and the digests of
+	// its imports. Packages are shared by all the roots that depend on them.
+	digests map[*packages.Package][]byte
+}
+
+// key returns the key of the cache entry of the code generated for p.
+func (k *cacheKeyer) key(p *packages.Package) (string, error) {
+	d, err := k.digest(p)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	writeHashString(h, "wire cache 2")
+	h.Write(k.exe)
+	h.Write(k.goEnv)
+	writeHashString(h, strconv.Itoa(len(k.buildFlags)))
+	for _, f := range k.buildFlags {
+		writeHashString(h, f)
+	}
+	// Every option that changes the generated code is part of the key. Jobs,
+	// Overlay and CacheDir do not change it, and the overlay is hashed as
+	// the contents of the files it replaces.
+	writeHashString(h, string(k.opts.Header))
+	writeHashString(h, k.opts.PrefixOutputFile)
+	writeHashString(h, k.opts.Tags)
+	writeHashString(h, strconv.FormatBool(k.opts.WrapErrors))
+	h.Write(d)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// digest returns the hash of the files of p and of the packages it depends
+// on.
+func (k *cacheKeyer) digest(p *packages.Package) ([]byte, error) {
+	if d := k.digests[p]; d != nil {
+		return d, nil
+	}
+	h := sha256.New()
+	writeHashString(h, p.PkgPath)
+	files := append(append([]string(nil), p.GoFiles...), p.OtherFiles...)
+	sort.Strings(files)
+	for _, name := range files {
+		writeHashString(h, name)
+		if err := k.hashFile(h, name); err != nil {
+			return nil, err
+		}
+	}
+	paths := make([]string, 0, len(p.Imports))
+	for path := range p.Imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		d, err := k.digest(p.Imports[path])
+		if err != nil {
+			return nil, err
+		}
+		writeHashString(h, path)
+		h.Write(d)
+	}
+	d := h.Sum(nil)
+	k.digests[p] = d
+	return d, nil
+}
+
+// hashFile writes the hash of the contents of the file name, or of its
+// replacement in the overlay, to h.
+func (k *cacheKeyer) hashFile(h hash.Hash, name string) error {
+	if content, ok := k.overlay[name]; ok {
+		sum := sha256.Sum256(content)
+		h.Write(sum[:])
+		return nil
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fh := sha256.New()
+	if _, err := io.Copy(fh, f); err != nil {
+		return err
+	}
+	h.Write(fh.Sum(nil))
+	return nil
+}
+
+// writeHashString writes s to h with its length, so that the strings
+// written one after another cannot be split differently.
+func writeHashString(h hash.Hash, s string) {
+	io.WriteString(h, strconv.Itoa(len(s)))
+	io.WriteString(h, ":")
+	io.WriteString(h, s)
+}
+
+// cacheGoEnv lists the variables of the go command environment that change
+// which files packages.Load loads or how they are type-checked.
+var cacheGoEnv = []string{
+	"GOOS", "GOARCH", "GOFLAGS", "GOEXPERIMENT", "CGO_ENABLED", "CC",
+	"GOVERSION", "GOROOT", "GOPATH", "GO111MODULE", "GOMOD", "GOWORK",
+	"GO386", "GOAMD64", "GOARM", "GOARM64", "GOMIPS", "GOMIPS64",
+	"GOPPC64", "GORISCV64", "GOWASM",
+}
+
+// goEnvHash returns the hash of the variables in cacheGoEnv as reported by
+// go env in wd with env, which includes the values set in the go env
+// configuration file rather than in env, and of the go.mod and go.work
+// files they name.
+func goEnvHash(ctx context.Context, wd string, env []string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "go", append([]string{"env", "-json"}, cacheGoEnv...)...)
+	cmd.Dir = wd
+	cmd.Env = env
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go env: %v", err)
+	}
+	vars := make(map[string]string)
+	if err := json.Unmarshal(out, &vars); err != nil {
+		return nil, fmt.Errorf("go env: %v", err)
+	}
+	h := sha256.New()
+	for _, name := range cacheGoEnv {
+		writeHashString(h, name)
+		writeHashString(h, vars[name])
+	}
+	// The go directive and the replacements of go.mod and go.work change
+	// how packages are loaded.
+	for _, name := range []string{"GOMOD", "GOWORK"} {
+		path := vars[name]
+		if path == "" || path == "off" || path == os.DevNull {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		writeHashString(h, string(content))
+	}
+	return h.Sum(nil), nil
+}
+
+var (
+	executableHashOnce sync.Once
+	executableDigest   []byte
+	executableErr      error
+)
+
+// executableHash returns the hash of the executable of the running program,
+// which identifies the build of Wire that generates code.
+func executableHash() ([]byte, error) {
+	executableHashOnce.Do(func() {
+		exe, err := os.Executable()
+		if err != nil {
+			executableErr = err
+			return
+		}
+		f, err := os.Open(exe)
+		if err != nil {
+			executableErr = err
+			return
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			executableErr = err
+			return
+		}
+		executableDigest = h.Sum(nil)
+	})
+	return executableDigest, executableErr
+}
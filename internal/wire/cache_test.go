@@ -0,0 +1,319 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wire
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cachedContent replaces the cache entries, to tell whether Generate returns
+// them or generates the code again.
+const cachedContent = "// cached\n"
+
+// writeCacheModule writes a module whose package imports config, which
+// imports base, so that base is an indirect dependency of the injector.
+func writeCacheModule(t *testing.T, dir string) {
+	t.Helper()
+	writeIntegrationModule(t, dir)
+	for _, sub := range []string{"base", "config"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeIntegrationFile(t, filepath.Join(dir, "base", "base.go"), `package base
+
+type Name string
+`)
+	writeIntegrationFile(t, filepath.Join(dir, "config", "config.go"), `package config
+
+import (
+	"example.com/wiretest/base"
+	"github.com/almondoo/wire"
+)
+
+type Config struct {
+	Name base.Name
+}
+
+var Set = wire.NewSet(wire.Value(Config{Name: "app"}))
+`)
+	writeIntegrationFile(t, filepath.Join(dir, "wire.go"), `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import (
+	"example.com/wiretest/config"
+	"github.com/almondoo/wire"
+)
+
+func InitializeConfig() config.Config {
+	wire.Build(config.Set)
+	return config.Config{}
+}
+`)
+}
+
+func TestGenerateCache(t *testing.T) {
+	tests := []struct {
+		name string
+		// change changes the module in dir, the options or the environment
+		// of the second Generate.
+		change func(t *testing.T, dir string, opts *GenerateOptions, env []string) []string
+		hit    bool
+	}{
+		{
+			name: "NothingChanged",
+			change: func(t *testing.T, dir string, opts *GenerateOptions, env []string) []string {
+				return env
+			},
+			hit: true,
+		},
+		{
+			name: "UnrelatedEnv",
+			change: func(t *testing.T, dir string, opts *GenerateOptions, env []string) []string {
+				return append(env, "WIRE_CACHE_TEST=1")
+			},
+			hit: true,
+		},
+		{
+			name: "IndirectDependency",
+			change: func(t *testing.T, dir string, opts *GenerateOptions, env []string) []string {
+				writeIntegrationFile(t, filepath.Join(dir, "base", "base.go"), `package base
+
+// Name is the name of a service.
+type Name string
+`)
+				return env
+			},
+		},
+		{
+			name: "Tags",
+			change: func(t *testing.T, dir string, opts *GenerateOptions, env []string) []string {
+				opts.Tags = "integration"
+				return env
+			},
+		},
+		{
+			name: "Header",
+			change: func(t *testing.T, dir string, opts *GenerateOptions, env []string) []string {
+				opts.Header = []byte("// Header\n")
+				return env
+			},
+		},
+		{
+			name: "PrefixOutputFile",
+			change: func(t *testing.T, dir string, opts *GenerateOptions, env []string) []string {
+				opts.PrefixOutputFile = "gen_"
+				return env
+			},
+		},
+		{
+			name: "WrapErrors",
+			change: func(t *testing.T, dir string, opts *GenerateOptions, env []string) []string {
+				opts.WrapErrors = true
+				return env
+			},
+		},
+		{
+			name: "GOFLAGS",
+			change: func(t *testing.T, dir string, opts *GenerateOptions, env []string) []string {
+				return append(env, "GOFLAGS=-tags=integration")
+			},
+		},
+		{
+			name: "GOOS",
+			change: func(t *testing.T, dir string, opts *GenerateOptions, env []string) []string {
+				if goEnv(t, "GOOS") == "plan9" {
+					return append(env, "GOOS=linux")
+				}
+				return append(env, "GOOS=plan9")
+			},
+		},
+		{
+			name: "GOARCH",
+			change: func(t *testing.T, dir string, opts *GenerateOptions, env []string) []string {
+				if goEnv(t, "GOARCH") == "wasm" {
+					return append(env, "GOOS=linux", "GOARCH=amd64")
+				}
+				return append(env, "GOOS=js", "GOARCH=wasm")
+			},
+		},
+		{
+			name: "GoMod",
+			change: func(t *testing.T, dir string, opts *GenerateOptions, env []string) []string {
+				content, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				writeIntegrationFile(t, filepath.Join(dir, "go.mod"), string(content)+"\n// Changed.\n")
+				return env
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeCacheModule(t, dir)
+			ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
+			defer cancel()
+
+			cacheDir := t.TempDir()
+			generate := func(opts *GenerateOptions, env []string) string {
+				t.Helper()
+				opts.CacheDir = cacheDir
+				results, errs := Generate(ctx, dir, env, []string{"."}, opts)
+				if len(errs) > 0 {
+					t.Fatalf("Generate returned load errors: %v", errs)
+				}
+				if len(results) != 1 {
+					t.Fatalf("got %d GenerateResults, want 1: %+v", len(results), results)
+				}
+				assertNoErrors(t, results[0].Errs)
+				return string(results[0].Content)
+			}
+
+			generate(&GenerateOptions{}, integrationEnv())
+			entries := cacheEntries(t, cacheDir)
+			if len(entries) != 1 {
+				t.Fatalf("cache has %d entries; want 1", len(entries))
+			}
+			if err := os.WriteFile(filepath.Join(cacheDir, entries[0]), []byte(cachedContent), 0o666); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := &GenerateOptions{}
+			env := test.change(t, dir, opts, integrationEnv())
+			got := generate(opts, env)
+			if test.hit && got != cachedContent {
+				t.Errorf("Generate returned:\n%s\nwant the cached content", got)
+			}
+			if !test.hit && got == cachedContent {
+				t.Error("Generate returned the cached content; want the code generated again")
+			}
+		})
+	}
+}
+
+// cacheEntries returns the names of the entries of the cache in dir.
+func cacheEntries(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Name() != cacheTrimFile {
+			names = append(names, e.Name())
+		}
+	}
+	return names
+}
+
+func TestGenerateCacheMarksUsed(t *testing.T) {
+	dir := t.TempDir()
+	writeCacheModule(t, dir)
+	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
+	defer cancel()
+	opts := &GenerateOptions{CacheDir: t.TempDir()}
+	generate := func() {
+		t.Helper()
+		results, errs := Generate(ctx, dir, integrationEnv(), []string{"."}, opts)
+		if len(errs) > 0 {
+			t.Fatalf("Generate returned load errors: %v", errs)
+		}
+		assertNoErrors(t, results[0].Errs)
+	}
+
+	generate()
+	entries := cacheEntries(t, opts.CacheDir)
+	if len(entries) != 1 {
+		t.Fatalf("cache has %d entries; want 1", len(entries))
+	}
+	path := filepath.Join(opts.CacheDir, entries[0])
+	old := time.Now().Add(-2 * 24 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+	generate()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(info.ModTime()) > cacheMtimeInterval {
+		t.Errorf("entry modified at %v after it was used; want now", info.ModTime())
+	}
+}
+
+func TestTrimCache(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	// Each entry was last used the given time before now.
+	entries := map[string]time.Duration{
+		"recent":      time.Hour,
+		"old":         cacheTrimLimit + time.Hour,
+		"old.123.tmp": cacheTrimLimit + time.Hour,
+	}
+	for name, age := range entries {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0o666); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	trimCache(dir, now)
+	if got := strings.Join(cacheEntries(t, dir), " "); got != "recent" {
+		t.Errorf("entries after trim = %q; want recent", got)
+	}
+
+	// The cache is not trimmed again until cacheTrimInterval passed.
+	stale := filepath.Join(dir, "stale")
+	if err := os.WriteFile(stale, nil, 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(stale, now.Add(-cacheTrimLimit-time.Hour), now.Add(-cacheTrimLimit-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	trimCache(dir, now.Add(time.Hour))
+	if got := strings.Join(cacheEntries(t, dir), " "); got != "recent stale" {
+		t.Errorf("entries after a second trim within a day = %q; want recent stale", got)
+	}
+	trimCache(dir, now.Add(cacheTrimInterval))
+	if got := strings.Join(cacheEntries(t, dir), " "); got != "recent" {
+		t.Errorf("entries after a trim a day later = %q; want recent", got)
+	}
+}
+
+// goEnv returns the value of the go env variable name.
+func goEnv(t *testing.T, name string) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
+	defer cancel()
+	b, err := exec.CommandContext(ctx, "go", "env", name).Output()
+	if err != nil {
+		t.Fatalf("go env %s: %v", name, err)
+	}
+	return strings.TrimSpace(string(b))
+}
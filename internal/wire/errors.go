@@ -15,7 +15,12 @@
 package wire
 
 import (
+	"errors"
 	"go/token"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
 )
 
 // errorCollector manages a list of errors. The zero value is an empty list.
@@ -82,3 +87,46 @@ func (w *wireErr) Error() string {
 	}
 	return w.position.String() + ": " + w.error.Error()
 }
+
+// ErrorPosition returns the source position of an error returned by Load or
+// Generate and the error without the position. The position is not valid if
+// the error has none.
+func ErrorPosition(err error) (token.Position, error) {
+	switch e := err.(type) {
+	case *wireErr:
+		return e.position, e.error
+	case packages.Error:
+		return parsePosition(e.Pos), errors.New(e.Msg)
+	default:
+		return token.Position{}, err
+	}
+}
+
+// parsePosition parses a position of the form "file:line:column" or
+// "file:line", as reported by the go/packages loader.
+func parsePosition(s string) token.Position {
+	var pos token.Position
+	var nums []int
+	for len(nums) < 2 {
+		i := strings.LastIndexByte(s, ':')
+		if i == -1 {
+			break
+		}
+		n, err := strconv.Atoi(s[i+1:])
+		if err != nil {
+			break
+		}
+		nums = append(nums, n)
+		s = s[:i]
+	}
+	switch len(nums) {
+	case 1:
+		pos.Line = nums[0]
+	case 2:
+		pos.Line, pos.Column = nums[1], nums[0]
+	default:
+		return token.Position{}
+	}
+	pos.Filename = s
+	return pos
+}
@@ -18,6 +18,8 @@ import (
 	"errors"
 	"go/token"
 	"testing"
+
+	"golang.org/x/tools/go/packages"
 )
 
 func TestNotePosition(t *testing.T) {
@@ -172,3 +174,53 @@ func TestMapErrors(t *testing.T) {
 		}
 	})
 }
+
+func TestErrorPosition(t *testing.T) {
+	pos := token.Position{Filename: "foo.go", Line: 42, Column: 7}
+	tests := []struct {
+		name    string
+		err     error
+		wantPos token.Position
+		wantMsg string
+	}{
+		{
+			name:    "wire error",
+			err:     notePosition(pos, errors.New("something broke")),
+			wantPos: pos,
+			wantMsg: "something broke",
+		},
+		{
+			name:    "package error",
+			err:     packages.Error{Pos: "foo.go:42:7", Msg: "undefined: x"},
+			wantPos: pos,
+			wantMsg: "undefined: x",
+		},
+		{
+			name:    "package error without column",
+			err:     packages.Error{Pos: "foo.go:42", Msg: "undefined: x"},
+			wantPos: token.Position{Filename: "foo.go", Line: 42},
+			wantMsg: "undefined: x",
+		},
+		{
+			name:    "package error without position",
+			err:     packages.Error{Pos: "-", Msg: "no Go files"},
+			wantMsg: "no Go files",
+		},
+		{
+			name:    "plain error",
+			err:     errors.New("boom"),
+			wantMsg: "boom",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotPos, gotErr := ErrorPosition(test.err)
+			if gotPos != test.wantPos {
+				t.Errorf("position = %v; want %v", gotPos, test.wantPos)
+			}
+			if gotErr.Error() != test.wantMsg {
+				t.Errorf("error = %q; want %q", gotErr, test.wantMsg)
+			}
+		})
+	}
+}
@@ -33,8 +33,11 @@ package wire
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"go/format"
+	"go/types"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -53,7 +56,7 @@ const integrationTimeout = 60 * time.Second
 // (resolved relative to this test file's package directory, internal/wire)
 // so the fixture always refers to the checkout under test, whether running
 // on the host or inside the Docker dev container.
-func writeIntegrationModule(t *testing.T, dir string) {
+func writeIntegrationModule(t testing.TB, dir string) {
 	t.Helper()
 
 	repoRoot, err := filepath.Abs("../..")
@@ -69,7 +72,7 @@ func writeIntegrationModule(t *testing.T, dir string) {
 }
 
 // writeIntegrationFile writes content to path, failing the test on error.
-func writeIntegrationFile(t *testing.T, path, content string) {
+func writeIntegrationFile(t testing.TB, path, content string) {
 	t.Helper()
 	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
 		t.Fatalf("write %s: %v", path, err)
@@ -263,7 +266,7 @@ func TestLoadIntegration(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
 	defer cancel()
 
-	info, errs := Load(ctx, dir, integrationEnv(), "", []string{"."})
+	info, errs := Load(ctx, dir, integrationEnv(), "", []string{"."}, nil)
 	if len(errs) > 0 {
 		t.Fatalf("Load returned errors: %v", errs)
 	}
@@ -281,6 +284,12 @@ func TestLoadIntegration(t *testing.T) {
 	if !strings.HasSuffix(inj.ImportPath, "wiretest") {
 		t.Errorf("ImportPath = %q, want suffix %q", inj.ImportPath, "wiretest")
 	}
+	if !inj.Pos.IsValid() {
+		t.Error("Pos is not valid")
+	}
+	if inj.Set == nil || inj.Set.For(inj.Out).IsNil() {
+		t.Errorf("Set does not provide Out %v", inj.Out)
+	}
 }
 
 func TestLoadIntegrationNoInjectors(t *testing.T) {
@@ -290,7 +299,7 @@ func TestLoadIntegrationNoInjectors(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
 	defer cancel()
 
-	info, errs := Load(ctx, dir, integrationEnv(), "", []string{"."})
+	info, errs := Load(ctx, dir, integrationEnv(), "", []string{"."}, nil)
 	if len(errs) > 0 {
 		t.Fatalf("Load returned errors: %v", errs)
 	}
@@ -313,7 +322,7 @@ func TestLoadIntegrationMissingProvider(t *testing.T) {
 	// as Generate (see parse.go's call to solve()), so an unresolved
 	// provider surfaces directly as a Load error and the injector is
 	// omitted from Info.Injectors.
-	info, errs := Load(ctx, dir, integrationEnv(), "", []string{"."})
+	info, errs := Load(ctx, dir, integrationEnv(), "", []string{"."}, nil)
 	if len(errs) == 0 {
 		t.Fatalf("Load returned no errors, want an unresolved-provider error; Injectors: %+v", info.Injectors)
 	}
@@ -322,3 +331,2145 @@ func TestLoadIntegrationMissingProvider(t *testing.T) {
 		t.Errorf("got %d injectors, want 0 since the injector failed to solve: %+v", len(info.Injectors), info.Injectors)
 	}
 }
+
+func TestLoadIntegrationWhy(t *testing.T) {
+	dir := t.TempDir()
+	writeIntegrationModule(t, dir)
+	writeIntegrationFile(t, filepath.Join(dir, "providers.go"), `package wiretest
+
+import "github.com/almondoo/wire"
+
+type DB struct{}
+
+type Store interface{ Get() string }
+
+type dbStore struct{ db *DB }
+
+func (dbStore) Get() string { return "" }
+
+type Handler struct {
+	Store Store
+}
+
+func NewDB() *DB { return &DB{} }
+
+func NewStore(db *DB) *dbStore { return &dbStore{db} }
+
+func Log(s Store) Store { return s }
+
+var StoreSet = wire.NewSet(NewDB, NewStore, wire.Bind(new(Store), new(*dbStore)), wire.Decorate(Log))
+`)
+	writeIntegrationFile(t, filepath.Join(dir, "wire.go"), `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import "github.com/almondoo/wire"
+
+func InitializeHandler() *Handler {
+	wire.Build(StoreSet, wire.Struct(new(Handler), "*"))
+	return nil
+}
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
+	defer cancel()
+
+	info, errs := Load(ctx, dir, integrationEnv(), "", []string{"."}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("Load returned errors: %v", errs)
+	}
+	if len(info.Injectors) != 1 {
+		t.Fatalf("got %d injectors, want 1: %+v", len(info.Injectors), info.Injectors)
+	}
+	in := info.Injectors[0]
+
+	steps, errs := Why(info.Fset, in, "*wiretest.DB")
+	if len(errs) > 0 {
+		t.Fatalf("Why returned errors: %v", errs)
+	}
+	want := []struct {
+		typ   string
+		trace string
+	}{
+		{"*wiretest.Handler", `struct provider "Handler"`},
+		{"wiretest.Store", `decorator "Log"`},
+		{"wiretest.Store", `wire.Bind`},
+		{"*wiretest.dbStore", `provider "NewStore"`},
+		{"*wiretest.DB", `provider "NewDB"`},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("got %d steps, want %d: %+v", len(steps), len(want), steps)
+	}
+	for i, w := range want {
+		s := steps[i]
+		if got := TypeStringQualified(s.Type, (*types.Package).Name); got != w.typ {
+			t.Errorf("steps[%d].Type = %s, want %s", i, got, w.typ)
+		}
+		if !s.Pos.IsValid() {
+			t.Errorf("steps[%d].Pos is not valid", i)
+		}
+		if len(s.Trace) == 0 || !strings.HasPrefix(s.Trace[0], w.trace) {
+			t.Errorf("steps[%d].Trace = %q, want it to start with %q", i, s.Trace, w.trace)
+		}
+	}
+	if got := steps[4].Trace; len(got) != 2 || !strings.HasPrefix(got[1], `provider set "StoreSet"`) {
+		t.Errorf("steps[4].Trace = %q, want NewDB imported through StoreSet", got)
+	}
+
+	if _, errs := Why(info.Fset, in, "string"); len(errs) == 0 {
+		t.Error("Why returned no errors for a type the injector does not need")
+	}
+}
+
+func TestLoadIntegrationUnused(t *testing.T) {
+	dir := t.TempDir()
+	writeIntegrationModule(t, dir)
+	writeIntegrationFile(t, filepath.Join(dir, "providers.go"), `package wiretest
+
+import "github.com/almondoo/wire"
+
+type Config struct {
+	DSN  string
+	Port int
+}
+
+type DB struct{}
+
+type Cache struct{}
+
+type Store interface{ Get() string }
+
+type dbStore struct{}
+
+func (dbStore) Get() string { return "" }
+
+type Server struct{}
+
+type Worker struct{}
+
+func NewDB(dsn string) *DB { return &DB{} }
+
+func NewCache() *Cache { return &Cache{} }
+
+func NewStore(*DB) *dbStore { return &dbStore{} }
+
+func NewServer(Store) *Server { return &Server{} }
+
+func NewWorker(*DB) *Worker { return &Worker{} }
+
+func Log(s Store) Store { return s }
+
+var ConfigSet = wire.NewSet(
+	wire.Value(&Config{}),
+	wire.FieldsOf(new(*Config), "DSN", "Port"),
+)
+
+var Set = wire.NewSet(
+	ConfigSet,
+	NewDB,
+	wire.NewSet(NewCache, NewStore),
+	wire.Bind(new(Store), new(*dbStore)),
+	wire.Decorate(Log),
+	wire.Value(1.5),
+)
+`)
+	writeIntegrationFile(t, filepath.Join(dir, "wire.go"), `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import "github.com/almondoo/wire"
+
+func InitializeServer() *Server {
+	wire.Build(Set, NewServer)
+	return nil
+}
+
+func InitializeWorker() *Worker {
+	wire.Build(Set, NewWorker)
+	return nil
+}
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
+	defer cancel()
+
+	info, errs := Load(ctx, dir, integrationEnv(), "", []string{"."}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("Load returned errors: %v", errs)
+	}
+	if len(info.Injectors) != 2 {
+		t.Fatalf("got %d injectors, want 2: %+v", len(info.Injectors), info.Injectors)
+	}
+
+	var got []string
+	for _, item := range Unused(info.Fset, info.Injectors) {
+		if !item.Pos.IsValid() {
+			t.Errorf("%s: Pos is not valid", item.Message)
+		}
+		got = append(got, item.Set.VarName+": "+item.Message)
+	}
+	want := []string{
+		`ConfigSet: unused field "*example.com/wiretest.Config".Port`,
+		`Set: unused provider "wiretest.NewCache"`,
+		`Set: unused value of type float64`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Unused returned:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Only the injector that needs the store uses the binding and the
+	// decorator.
+	var worker []*Injector
+	for _, in := range info.Injectors {
+		if in.FuncName == "InitializeWorker" {
+			worker = append(worker, in)
+		}
+	}
+	got = got[:0]
+	for _, item := range Unused(info.Fset, worker) {
+		got = append(got, item.Message)
+	}
+	for _, w := range []string{
+		`unused provider "wiretest.NewStore"`,
+		`unused interface binding to type example.com/wiretest.Store`,
+		`unused decorator "wiretest.Log"`,
+	} {
+		found := false
+		for _, g := range got {
+			found = found || g == w
+		}
+		if !found {
+			t.Errorf("Unused for InitializeWorker returned %q, want it to include %q", got, w)
+		}
+	}
+}
+
+func TestLoadIntegrationUnusedMultibindings(t *testing.T) {
+	dir := t.TempDir()
+	writeIntegrationModule(t, dir)
+	writeIntegrationFile(t, filepath.Join(dir, "providers.go"), `package wiretest
+
+import "github.com/almondoo/wire"
+
+type Plugin interface{ Name() string }
+
+type A struct{}
+
+func (A) Name() string { return "a" }
+
+func NewA() A { return A{} }
+
+type Timeout int
+
+type Retries int
+
+func NewRetries() Retries { return 5 }
+
+type DB struct{}
+
+func NewDB() *DB { return &DB{} }
+
+type Server struct{}
+
+func NewServer([]Plugin, map[string]Plugin, Timeout, Retries) *Server { return &Server{} }
+
+type Worker struct{}
+
+func NewWorker(*DB) *Worker { return &Worker{} }
+
+var Plugins = wire.NewSet(wire.SliceOf(new(Plugin), NewA, wire.Value(A{})))
+
+var Set = wire.NewSet(
+	Plugins,
+	NewDB,
+	NewRetries,
+	wire.SliceOf(new(Plugin), wire.InterfaceValue(new(Plugin), A{})),
+	wire.MapOf(new(Plugin), "a", NewA),
+	wire.Optional(new(Timeout), Timeout(5)),
+	wire.Optional(new(Retries), Retries(3)),
+)
+`)
+	writeIntegrationFile(t, filepath.Join(dir, "wire.go"), `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import "github.com/almondoo/wire"
+
+func InitializeServer() *Server {
+	wire.Build(Set, NewServer)
+	return nil
+}
+
+func InitializeWorker() *Worker {
+	wire.Build(Set, NewWorker)
+	return nil
+}
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
+	defer cancel()
+
+	info, errs := Load(ctx, dir, integrationEnv(), "", []string{"."}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("Load returned errors: %v", errs)
+	}
+	injectors := make(map[string]*Injector)
+	for _, in := range info.Injectors {
+		injectors[in.FuncName] = in
+	}
+
+	tests := []struct {
+		name      string
+		injectors []*Injector
+		want      []string
+	}{
+		{
+			// The server uses the slice merged from both sets, the map and
+			// the default timeout. The default number of retries is unused,
+			// since NewRetries provides them.
+			name:      "Server",
+			injectors: []*Injector{injectors["InitializeServer"]},
+			want: []string{
+				`Set: unused provider "wiretest.NewDB"`,
+				`Set: unused default value of wire.Optional for example.com/wiretest.Retries`,
+			},
+		},
+		{
+			name:      "Worker",
+			injectors: []*Injector{injectors["InitializeWorker"]},
+			want: []string{
+				`Plugins: unused provider "wiretest.NewA" contributed to wire.SliceOf for []example.com/wiretest.Plugin`,
+				`Plugins: unused wire.SliceOf for []example.com/wiretest.Plugin`,
+				`Plugins: unused value of type example.com/wiretest.A contributed to wire.SliceOf for []example.com/wiretest.Plugin`,
+				`Set: unused provider "wiretest.NewA" contributed to wire.MapOf for map[string]example.com/wiretest.Plugin`,
+				`Set: unused provider "wiretest.NewRetries"`,
+				`Set: unused wire.SliceOf for []example.com/wiretest.Plugin`,
+				`Set: unused value of type example.com/wiretest.Plugin contributed to wire.SliceOf for []example.com/wiretest.Plugin`,
+				`Set: unused wire.MapOf for map[string]example.com/wiretest.Plugin`,
+				`Set: unused default value of wire.Optional for example.com/wiretest.Timeout`,
+				`Set: unused default value of wire.Optional for example.com/wiretest.Retries`,
+			},
+		},
+		{
+			name:      "Both",
+			injectors: info.Injectors,
+			want: []string{
+				`Set: unused default value of wire.Optional for example.com/wiretest.Retries`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, item := range Unused(info.Fset, test.injectors) {
+				if !item.Pos.IsValid() {
+					t.Errorf("%s: Pos is not valid", item.Message)
+				}
+				got = append(got, item.Set.VarName+": "+item.Message)
+			}
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Errorf("Unused returned:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestLoadIntegrationOverlay(t *testing.T) {
+	dir := t.TempDir()
+	writeMissingProviderFixture(t, dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
+	defer cancel()
+
+	// The overlay adds the missing provider without writing it to disk.
+	overlay := map[string][]byte{
+		filepath.Join(dir, "providers.go"): []byte(`package wiretest
+
+type Widget struct {
+	Name string
+}
+
+func NewWidget() *Widget { return &Widget{} }
+`),
+		filepath.Join(dir, "wire.go"): []byte(`//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import "github.com/almondoo/wire"
+
+func InitializeWidget() *Widget {
+	wire.Build(NewWidget)
+	return nil
+}
+`),
+	}
+	info, errs := Load(ctx, dir, integrationEnv(), "", []string{"."}, overlay)
+	if len(errs) > 0 {
+		t.Fatalf("Load returned errors: %v", errs)
+	}
+	if len(info.Injectors) != 1 {
+		t.Errorf("got %d injectors, want 1: %+v", len(info.Injectors), info.Injectors)
+	}
+}
+
+func TestLoadIntegrationProviderAt(t *testing.T) {
+	dir := t.TempDir()
+	writeIntegrationModule(t, dir)
+	providers := `package wiretest
+
+import "github.com/almondoo/wire"
+
+type DB struct{}
+
+type Store interface{ Get() string }
+
+type dbStore struct{}
+
+func (dbStore) Get() string { return "" }
+
+type Server struct{}
+
+func NewDB() *DB { return &DB{} }
+
+func NewStore(db *DB) *dbStore { return &dbStore{} }
+
+func NewServer(s Store) *Server { return &Server{} }
+
+var Set = wire.NewSet(NewDB, NewStore, wire.Bind(new(Store), new(*dbStore)))
+`
+	writeIntegrationFile(t, filepath.Join(dir, "providers.go"), providers)
+	injector := `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import "github.com/almondoo/wire"
+
+func InitializeServer() *Server {
+	wire.Build(Set, NewServer)
+	return nil
+}
+`
+	writeIntegrationFile(t, filepath.Join(dir, "wire.go"), injector)
+
+	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
+	defer cancel()
+
+	info, errs := Load(ctx, dir, integrationEnv(), "", []string{"."}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("Load returned errors: %v", errs)
+	}
+	tests := []struct {
+		name string
+		file string
+		// at is the text whose start is the offset to look up.
+		at string
+		// want are the descriptions of the steps, or nil if nothing
+		// provides a type at the offset.
+		want []string
+	}{
+		{
+			name: "ProviderParam",
+			file: "providers.go",
+			at:   "*DB) *dbStore",
+			want: []string{`provider "NewDB"`},
+		},
+		{
+			name: "Binding",
+			file: "providers.go",
+			at:   "Store) *Server",
+			want: []string{`wire.Bind`, `provider "NewStore"`},
+		},
+		{
+			name: "InjectorResult",
+			file: "wire.go",
+			at:   "*Server {",
+			want: []string{`provider "NewServer"`},
+		},
+		{
+			name: "NotSignature",
+			file: "providers.go",
+			at:   "&dbStore{}",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content := providers
+			if test.file == "wire.go" {
+				content = injector
+			}
+			chain := info.ProviderAt(filepath.Join(dir, test.file), strings.Index(content, test.at))
+			if test.want == nil {
+				if chain != nil {
+					t.Errorf("ProviderAt = %+v, want nil", chain)
+				}
+				return
+			}
+			if chain == nil {
+				t.Fatal("ProviderAt = nil")
+			}
+			if len(chain.Steps) != len(test.want) {
+				t.Fatalf("got %d steps, want %d: %+v", len(chain.Steps), len(test.want), chain.Steps)
+			}
+			for i, w := range test.want {
+				if got := chain.Steps[i].Trace[0]; !strings.HasPrefix(got, w) {
+					t.Errorf("Steps[%d] is %q, want it to start with %q", i, got, w)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateIntegrationOverlay(t *testing.T) {
+	dir := t.TempDir()
+	writeMissingProviderFixture(t, dir)
+
+	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
+	defer cancel()
+
+	// The overlay adds a provider file and replaces the injector file
+	// without writing them to disk.
+	opts := &GenerateOptions{Overlay: map[string][]byte{
+		filepath.Join(dir, "new_widget.go"): []byte(`package wiretest
+
+func NewWidget() *Widget { return &Widget{Name: "overlay"} }
+`),
+		filepath.Join(dir, "wire.go"): []byte(`//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import "github.com/almondoo/wire"
+
+func InitializeWidget() *Widget {
+	wire.Build(NewWidget)
+	return nil
+}
+`),
+	}}
+	results, errs := Generate(ctx, dir, integrationEnv(), []string{"."}, opts)
+	if len(errs) > 0 {
+		t.Fatalf("Generate returned load errors: %v", errs)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d GenerateResults, want 1: %+v", len(results), results)
+	}
+	res := results[0]
+	assertNoErrors(t, res.Errs)
+	if content := string(res.Content); !strings.Contains(content, "widget := NewWidget()") {
+		t.Errorf("generated content does not call NewWidget from the overlay:\n%s", content)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new_widget.go")); !os.IsNotExist(err) {
+		t.Errorf("overlay file was written to disk: %v", err)
+	}
+}
+
+// generateCase is a test of Generate on a fixture module with a single
+// package, example.com/wiretest, at the module root.
+type generateCase struct {
+	name string
+	// files maps the slash-separated path of each file of the fixture
+	// module, other than go.mod, to its content.
+	files map[string]string
+	// goVersion is the go directive of the fixture module, or empty for
+	// go 1.19.
+	goVersion string
+	opts      *GenerateOptions
+
+	// wantErr is a substring of an error of Generate. If it is set, the
+	// other expectations are not checked.
+	wantErr string
+	// want lists substrings of the generated code, in order if ordered is
+	// set.
+	want    []string
+	ordered bool
+	// notWant lists substrings that the generated code must not contain.
+	notWant []string
+	// count maps substrings of the generated code to how many times they
+	// occur.
+	count map[string]int
+
+	// run is the source of a main package that is run with the generated
+	// code, and wantOutput is its output.
+	run        string
+	wantOutput string
+}
+
+func TestGenerateIntegrationCases(t *testing.T) {
+	tests := []generateCase{
+		{
+			name: "Named",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+type DB struct {
+	Name string
+}
+
+type Store struct {
+	Primary, Replica *DB
+}
+
+type Service struct {
+	Store   *Store
+	Replica *DB ` + "`wire:\"name=replica\"`" + `
+}
+
+func NewPrimaryDB() *DB { return &DB{Name: "primary"} }
+
+func NewReplicaDB() *DB { return &DB{Name: "replica"} }
+
+func NewStore(primary, replica *DB) *Store {
+	return &Store{Primary: primary, Replica: replica}
+}
+`,
+				"wire.go": injectorFile(`func InitializeService() *Service {
+	wire.Build(
+		NewPrimaryDB,
+		wire.Named("replica", NewReplicaDB),
+		wire.NamedArgs(NewStore, "", "replica"),
+		wire.Struct(new(Service), "*"),
+	)
+	return nil
+}
+`),
+			},
+			want: []string{
+				"db := NewPrimaryDB()",
+				"replica := NewReplicaDB()",
+				"store := NewStore(db, replica)",
+				"Replica: replica,",
+			},
+		},
+		{
+			name: "Multibinding",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+import "github.com/almondoo/wire"
+
+type Handler interface {
+	Name() string
+}
+
+type named string
+
+func (n named) Name() string { return string(n) }
+
+type Router struct {
+	Handlers []Handler
+	ByName   map[string]Handler
+}
+
+func NewAuth() named   { return "auth" }
+func NewHealth() named { return "health" }
+
+func NewRouter(hs []Handler, byName map[string]Handler) *Router {
+	return &Router{Handlers: hs, ByName: byName}
+}
+
+var AuthSet = wire.NewSet(wire.SliceOf(new(Handler), NewAuth))
+
+var HealthSet = wire.NewSet(wire.SliceOf(new(Handler), NewHealth))
+
+var ByNameSet = wire.NewSet(wire.MapOf(new(Handler), "health", NewHealth, "auth", NewAuth))
+`,
+				"wire.go": injectorFile(`func InitializeRouter() *Router {
+	wire.Build(HealthSet, AuthSet, ByNameSet, NewRouter)
+	return nil
+}
+`),
+			},
+			// The elements of the slice are in the order of the sets, and
+			// those of the map in the order of their contributions.
+			want: []string{
+				"wiretestNamed := NewHealth()",
+				"named2 := NewAuth()",
+				"[]Handler{\n\t\twiretestNamed,\n\t\tnamed2,\n\t}",
+				"named3 := NewHealth()",
+				"named4 := NewAuth()",
+				"map[string]Handler{\n\t\t\"health\": named3,\n\t\t\"auth\":   named4,\n\t}",
+			},
+			ordered: true,
+		},
+		{
+			name: "Generic",
+			files: map[string]string{
+				"providers.go": genericProviders,
+				"wire.go": injectorFile(`func InitializeApp() *App {
+	wire.Build(
+		NewConfig,
+		NewCache[string, User],
+		wire.Struct(new(Repo[User]), "*"),
+		NewApp,
+	)
+	return nil
+}
+`),
+			},
+			want: []string{
+				"cache := NewCache[string, User](config)",
+				"repo := &Repo[User]{",
+			},
+		},
+		{
+			name:  "GenericInferred",
+			files: genericInferredFiles,
+			want: []string{
+				"cache := NewCache[string, User](config)",
+				"repo := &Repo[User]{",
+				"wiretestCache := NewCache[string, Order](config)",
+				"queueQueue := queue.New[Order]()",
+			},
+			run: `package main
+
+import (
+	"fmt"
+
+	"example.com/wiretest"
+)
+
+func main() {
+	shop := wiretest.InitializeShop()
+	fmt.Println(shop.Users.Cache.Size, shop.Orders.Size, shop.Queue != nil)
+}
+`,
+			wantOutput: "16 16 true\n",
+		},
+		{
+			// Go 1.21 reports that it cannot infer the type arguments at
+			// the calls rather than at the generic providers.
+			name:      "GenericInferredGo121",
+			files:     genericInferredFiles,
+			goVersion: "1.21",
+			want: []string{
+				"cache := NewCache[string, User](config)",
+				"wiretestCache := NewCache[string, Order](config)",
+				"queueQueue := queue.New[Order]()",
+			},
+		},
+		{
+			name: "GenericAmbiguous",
+			files: map[string]string{
+				"providers.go": genericProviders + `
+func NewStringCache[V any](cfg Config) *Cache[string, V] { return NewCache[string, V](cfg) }
+`,
+				"wire.go": injectorFile(`func InitializeCache() *Cache[string, User] {
+	wire.Build(NewConfig, NewCache, NewStringCache)
+	return nil
+}
+`),
+			},
+			wantErr: "multiple generic providers for *example.com/wiretest.Cache[string, example.com/wiretest.User]",
+		},
+		{
+			name: "GenericConstraint",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+type Box[T any] struct {
+	V T
+}
+
+func NewBox[T ~int]() *Box[T] { return &Box[T]{} }
+`,
+				"wire.go": injectorFile(`func InitializeBox() *Box[string] {
+	wire.Build(NewBox)
+	return nil
+}
+`),
+			},
+			wantErr: "cannot instantiate generic provider NewBox to provide *example.com/wiretest.Box[string]",
+		},
+		{
+			name: "GenericAnyType",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+func New[T any]() T {
+	var v T
+	return v
+}
+`,
+				"wire.go": injectorFile(`func InitializeInt() int {
+	wire.Build(New)
+	return 0
+}
+`),
+			},
+			wantErr: "generic provider New must be instantiated: it can provide any type",
+		},
+		{
+			name: "GenericCycle",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+type A[T any] struct{}
+
+type B[T any] struct{}
+
+func NewA[T any](*B[T]) *A[T] { return &A[T]{} }
+
+func NewB[T any](*A[T]) *B[T] { return &B[T]{} }
+`,
+				"wire.go": injectorFile(`func InitializeA() *A[int] {
+	wire.Build(NewA, NewB)
+	return nil
+}
+`),
+			},
+			wantErr: "cycle for *example.com/wiretest.A[int]:\n" +
+				"*example.com/wiretest.A[int] (example.com/wiretest.NewA) ->\n" +
+				"*example.com/wiretest.B[int] (example.com/wiretest.NewB) ->\n" +
+				"*example.com/wiretest.A[int]",
+		},
+		{
+			name: "GenericUnbounded",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+type List[T any] struct {
+	Next *List[*T]
+}
+
+func NewList[T any](next *List[*T]) *List[T] { return &List[T]{Next: next} }
+`,
+				"wire.go": injectorFile(`func InitializeList() *List[int] {
+	wire.Build(NewList)
+	return nil
+}
+`),
+			},
+			wantErr: "generic provider NewList is instantiated more than 32 times",
+		},
+		{
+			name: "Optional",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+import "github.com/almondoo/wire"
+
+type Tracer struct{}
+
+type Logger struct{}
+
+type Timeout int64
+
+type Client struct {
+	Tracer  *Tracer
+	Timeout Timeout
+	Logger  *Logger ` + "`wire:\"optional\"`" + `
+}
+
+func NewTracer() *Tracer { return &Tracer{} }
+
+var ClientSet = wire.NewSet(
+	wire.Optional(new(*Tracer)),
+	wire.Optional(new(Timeout), 30),
+	wire.Struct(new(Client), "*"),
+)
+`,
+				"wire.go": injectorFile(`func InitializeMinimal() *Client {
+	wire.Build(ClientSet)
+	return nil
+}
+
+func InitializeTraced() *Client {
+	wire.Build(ClientSet, NewTracer)
+	return nil
+}
+`),
+			},
+			want: []string{
+				"var tracer *Tracer",
+				"tracer := NewTracer()",
+				"var logger *Logger",
+				"_wireTimeoutValue Timeout = 30",
+			},
+		},
+		{
+			name: "Decorate",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+import "github.com/almondoo/wire"
+
+type Store interface {
+	Get(key string) string
+}
+
+type dbStore struct{}
+
+func (dbStore) Get(key string) string { return key }
+
+type cachedStore struct {
+	Store
+}
+
+type Logger struct{}
+
+func NewStore() Store { return dbStore{} }
+
+func NewLogger() *Logger { return &Logger{} }
+
+func WithCache(s Store) (Store, func(), error) {
+	return cachedStore{s}, func() {}, nil
+}
+
+func WithLogging(s Store, l *Logger) Store { return s }
+
+var LoggingSet = wire.NewSet(NewLogger, wire.Decorate(WithLogging))
+`,
+				"wire.go": injectorFile(`func InitializeStore() (Store, func(), error) {
+	wire.Build(NewStore, LoggingSet, wire.Decorate(WithCache))
+	return nil, nil, nil
+}
+`),
+			},
+			want: []string{
+				"store := NewStore()",
+				"WithLogging(store, logger)",
+				", cleanup, err := WithCache(",
+			},
+			ordered: true,
+		},
+		{
+			name: "Outputs",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+import "github.com/almondoo/wire"
+
+type DB struct{}
+
+type Server struct {
+	DB *DB
+}
+
+type Worker struct {
+	DB *DB
+}
+
+type App struct {
+	wire.Outputs
+	Server *Server
+	Worker *Worker
+}
+
+func NewDB() (*DB, func(), error) { return &DB{}, func() {}, nil }
+
+func NewServer(db *DB) *Server { return &Server{DB: db} }
+
+func NewWorker(db *DB) (*Worker, error) { return &Worker{DB: db}, nil }
+`,
+				"wire.go": injectorFile(`func InitializeApp() (App, func(), error) {
+	wire.Build(NewDB, NewServer, NewWorker)
+	return App{}, nil, nil
+}
+`),
+			},
+			want:  []string{"app := App{\n\t\tServer: server,\n\t\tWorker: worker,\n\t}"},
+			count: map[string]int{"NewDB()": 1},
+		},
+		{
+			// A struct that does not embed wire.Outputs is an ordinary
+			// injector output, even if it has several fields of the same
+			// type.
+			name: "OutputsUnmarked",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+import "time"
+
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+}
+
+func NewTimeouts() *Timeouts { return &Timeouts{Read: time.Second, Write: time.Second} }
+`,
+				"wire.go": injectorFile(`func InitializeTimeouts() *Timeouts {
+	wire.Build(NewTimeouts)
+	return nil
+}
+`),
+			},
+			want: []string{"timeouts := NewTimeouts()"},
+		},
+		{
+			name: "Component",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+import "github.com/almondoo/wire"
+
+type DB struct{}
+
+type Server struct {
+	DB *DB
+}
+
+type Worker struct {
+	DB *DB
+}
+
+type App interface {
+	wire.Component
+	Server() *Server
+	Worker() *Worker
+}
+
+func NewDB() *DB { return &DB{} }
+
+func NewServer(db *DB) *Server { return &Server{DB: db} }
+
+func NewWorker(db *DB) (*Worker, error) { return &Worker{DB: db}, nil }
+`,
+				"wire.go": injectorFile(`func InitializeApp() (App, error) {
+	wire.Build(NewDB, NewServer, NewWorker)
+	return nil, nil
+}
+
+func InitializeTestApp() (App, error) {
+	wire.Build(NewDB, NewServer, NewWorker)
+	return nil, nil
+}
+`),
+			},
+			want: []string{
+				"app := &_wireAppComponent{\n\t\tserver: server,\n\t\tworker: worker,\n\t}",
+				"type _wireAppComponent struct {\n\tserver *Server\n\tworker *Worker\n}",
+				"func (c *_wireAppComponent) Server() *Server {\n\treturn c.server\n}",
+				"func (c *_wireAppComponent) Worker() *Worker {\n\treturn c.worker\n}",
+			},
+			count: map[string]int{"type _wireAppComponent": 1},
+		},
+		{
+			name: "CleanupErrors",
+			files: map[string]string{
+				"providers.go": cleanupErrorsProviders,
+				"wire.go":      cleanupErrorsInjector,
+			},
+			want: []string{
+				"\t\t_ = cleanup3(ctx)\n\t\tcleanup2()\n\t\t_ = cleanup()\n\t\treturn nil, nil, err\n",
+				"return server, func(ctx context.Context) error {\n" +
+					"\t\tvar errs _wireCleanupErrors\n" +
+					"\t\tif cerr := cleanup3(ctx); cerr != nil {\n" +
+					"\t\t\terrs = append(errs, cerr)\n" +
+					"\t\t}\n" +
+					"\t\tcleanup2()\n" +
+					"\t\tif cerr := cleanup(); cerr != nil {\n" +
+					"\t\t\terrs = append(errs, cerr)\n" +
+					"\t\t}\n" +
+					"\t\treturn errs.err()\n" +
+					"\t}, nil",
+				"type _wireCleanupErrors []error\n",
+			},
+			ordered: true,
+			// The injectors of the file share the type that collects the
+			// errors.
+			count: map[string]int{
+				"type _wireCleanupErrors":     1,
+				"var errs _wireCleanupErrors": 2,
+			},
+			// The cleanup function runs every cleanup and reports the errors
+			// of all of them, and the cleanups run in reverse order when a
+			// provider fails.
+			run: `package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"example.com/wiretest"
+)
+
+func main() {
+	ctx := context.Background()
+	_, cleanup, err := wiretest.InitializeServer(ctx)
+	fmt.Println(err)
+	err = cleanup(ctx)
+	fmt.Printf("%q\n", err.Error())
+	fmt.Println(errors.Is(err, wiretest.ExportErr), errors.Is(err, wiretest.CloseDBErr))
+	fmt.Println(wiretest.Events)
+
+	wiretest.Events = nil
+	wiretest.ServerErr = errors.New("no server")
+	_, cleanup, err = wiretest.InitializeServer(ctx)
+	fmt.Println(err, cleanup == nil)
+	fmt.Println(wiretest.Events)
+}
+`,
+			wantOutput: `<nil>
+"export failed\nclose db failed"
+true true
+[close exporter close cache close db]
+no server true
+[close exporter close cache close db]
+`,
+		},
+		{
+			name: "CleanupErrorsWithoutError",
+			files: map[string]string{
+				"providers.go": cleanupErrorsProviders,
+				"wire.go": injectorFile(`func InitializeDB() (*DB, func(), error) {
+	wire.Build(NewDB)
+	return nil, nil, nil
+}
+`),
+			},
+			wantErr: "inject InitializeDB: provider for *example.com/wiretest.DB returns cleanup that can fail but injection cleanup function does not return error",
+		},
+		{
+			name: "Closer",
+			files: map[string]string{
+				"providers.go": closerProviders,
+				"wire.go": injectorFile(`func InitializeServer() (*Server, func() error, error) {
+	wire.Build(Set)
+	return nil, nil, nil
+}
+`),
+			},
+			want: []string{
+				"\tpool, err := NewPool()\n" +
+					"\tif err != nil {\n" +
+					"\t\treturn nil, nil, err\n" +
+					"\t}\n" +
+					"\tcleanup := pool.Close\n",
+				"if cerr := cleanup(); cerr != nil {",
+			},
+		},
+		{
+			name: "CloserWithoutError",
+			files: map[string]string{
+				"providers.go": closerProviders,
+				"wire.go": injectorFile(`func InitializeServerNoErr() (*Server, func(), error) {
+	wire.Build(Set)
+	return nil, nil, nil
+}
+`),
+			},
+			wantErr: "inject InitializeServerNoErr: *example.com/wiretest.Pool is closed by wire.Closer but injection cleanup function does not return error",
+		},
+		{
+			name: "CloserWithoutClose",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+import "github.com/almondoo/wire"
+
+type Pool struct{}
+
+func (*Pool) Close() {}
+
+func NewPool() *Pool { return &Pool{} }
+
+var Set = wire.NewSet(wire.Closer(NewPool))
+`,
+				"wire.go": injectorFile(`func InitializePool() (*Pool, func() error) {
+	wire.Build(Set)
+	return nil, nil
+}
+`),
+			},
+			wantErr: "*example.com/wiretest.Pool provided by NewPool does not have a method Close() error",
+		},
+		{
+			name: "CloserInterface",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+import "github.com/almondoo/wire"
+
+type Conn interface {
+	Close() error
+}
+
+// NewConn returns no connection when there is nothing to connect to.
+func NewConn() Conn { return nil }
+
+var Set = wire.NewSet(wire.Closer(NewConn))
+`,
+				"wire.go": injectorFile(`func InitializeConn() (Conn, func() error) {
+	wire.Build(Set)
+	return nil, nil
+}
+`),
+			},
+			want: []string{
+				"\tconn := NewConn()\n" +
+					"\tcleanup := func() error {\n" +
+					"\t\tif conn == nil {\n" +
+					"\t\t\treturn nil\n" +
+					"\t\t}\n" +
+					"\t\treturn conn.Close()\n" +
+					"\t}\n",
+			},
+			// The Close method value of a nil interface would panic when
+			// the injector creates its cleanup function.
+			run: `package main
+
+import (
+	"fmt"
+
+	"example.com/wiretest"
+)
+
+func main() {
+	conn, cleanup := wiretest.InitializeConn()
+	fmt.Println(conn, cleanup())
+}
+`,
+			wantOutput: "<nil> <nil>\n",
+		},
+		{
+			name: "CheckContext",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+import "context"
+
+type DB struct{}
+
+type Server struct{}
+
+// Opened and Closed count the calls of NewDB and of its cleanup. If
+// CancelInNewDB is set, NewDB calls it.
+var (
+	Opened, Closed int
+	CancelInNewDB  context.CancelFunc
+)
+
+func NewDB(ctx context.Context) (*DB, func(), error) {
+	Opened++
+	if CancelInNewDB != nil {
+		CancelInNewDB()
+	}
+	return &DB{}, func() { Closed++ }, nil
+}
+
+func NewServer(db *DB) *Server { return &Server{} }
+`,
+				"wire.go": contextInjectorFile(`func InitializeServer(ctx context.Context) (*Server, func(), error) {
+	wire.Build(NewDB, NewServer, wire.CheckContext())
+	return nil, nil, nil
+}
+
+func InitializeUnchecked(ctx context.Context) (*Server, func(), error) {
+	wire.Build(NewDB, NewServer)
+	return nil, nil, nil
+}
+`),
+			},
+			want: []string{
+				"func InitializeServer(ctx context.Context) (*Server, func(), error) {\n" +
+					"\tif err := ctx.Err(); err != nil {\n" +
+					"\t\treturn nil, nil, err\n" +
+					"\t}\n" +
+					"\tdb, cleanup, err := NewDB(ctx)\n" +
+					"\tif err != nil {\n" +
+					"\t\treturn nil, nil, err\n" +
+					"\t}\n" +
+					"\tif err := ctx.Err(); err != nil {\n" +
+					"\t\tcleanup()\n" +
+					"\t\treturn nil, nil, err\n" +
+					"\t}\n" +
+					"\tserver := NewServer(db)\n",
+			},
+			// Only InitializeServer checks the context.
+			count: map[string]int{"ctx.Err()": 2},
+			// The injector does not call providers once the context is
+			// done, and cleans up what it created before it noticed.
+			run: `package main
+
+import (
+	"context"
+	"fmt"
+
+	"example.com/wiretest"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := wiretest.InitializeServer(ctx)
+	fmt.Println(err, wiretest.Opened, wiretest.Closed)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	wiretest.CancelInNewDB = cancel
+	_, _, err = wiretest.InitializeServer(ctx)
+	fmt.Println(err, wiretest.Opened, wiretest.Closed)
+}
+`,
+			wantOutput: `context canceled 0 0
+context canceled 1 1
+`,
+		},
+		{
+			name: "CheckContextCleanupContext",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+import "context"
+
+type Exporter struct{}
+
+type Server struct{}
+
+// CancelInNewExporter is called by NewExporter, and ShutdownErr records
+// the error of the context passed to the cleanup of the exporter.
+var (
+	CancelInNewExporter context.CancelFunc
+	ShutdownErr         error
+)
+
+func NewExporter(ctx context.Context) (*Exporter, func(context.Context) error) {
+	CancelInNewExporter()
+	return &Exporter{}, func(ctx context.Context) error {
+		ShutdownErr = ctx.Err()
+		return nil
+	}
+}
+
+func NewServer(e *Exporter) *Server { return &Server{} }
+`,
+				"wire.go": contextInjectorFile(`func InitializeServer(ctx context.Context) (*Server, func(context.Context) error, error) {
+	wire.Build(NewExporter, NewServer, wire.CheckContext())
+	return nil, nil, nil
+}
+`),
+			},
+			want: []string{
+				"\tif err := ctx.Err(); err != nil {\n" +
+					"\t\t_ = cleanup(context.Background())\n" +
+					"\t\treturn nil, nil, err\n" +
+					"\t}\n" +
+					"\tserver := NewServer(exporter)\n",
+			},
+			// The cleanup that runs because the context is done gets a context
+			// that is not.
+			run: `package main
+
+import (
+	"context"
+	"fmt"
+
+	"example.com/wiretest"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	wiretest.CancelInNewExporter = cancel
+	_, _, err := wiretest.InitializeServer(ctx)
+	fmt.Println(err, wiretest.ShutdownErr)
+}
+`,
+			wantOutput: `context canceled <nil>
+`,
+		},
+		{
+			name: "CheckContextWithoutContext",
+			files: map[string]string{
+				"wire.go": injectorFile(`type Server struct{}
+
+func NewServer() *Server { return &Server{} }
+
+func InitializeServer() (*Server, error) {
+	wire.Build(NewServer, wire.CheckContext())
+	return nil, nil
+}
+`),
+			},
+			wantErr: "inject InitializeServer: wire.CheckContext requires the injector to take a context.Context and return an error",
+		},
+		{
+			name: "Parallel",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+import "sync"
+
+type DB struct{}
+
+type Cache struct{}
+
+type Server struct{}
+
+// CacheErr is the error of NewCache. Opened and Closed count the calls of
+// NewDB and of its cleanup.
+var (
+	CacheErr error
+
+	mu             sync.Mutex
+	Opened, Closed int
+)
+
+func NewDB() (*DB, func(), error) {
+	mu.Lock()
+	defer mu.Unlock()
+	Opened++
+	return &DB{}, func() { Closed++ }, nil
+}
+
+func NewCache() (*Cache, error) { return &Cache{}, CacheErr }
+
+func NewServer(db *DB, c *Cache) *Server { return &Server{} }
+`,
+				"wire.go": injectorFile(`func InitializeServer() (*Server, func(), error) {
+	wire.Build(NewDB, NewCache, NewServer, wire.Parallel())
+	return nil, nil, nil
+}
+`),
+			},
+			want: []string{
+				"func InitializeServer() (*Server, func(), error) {\n" +
+					"\tvar (\n" +
+					"\t\tdb       *DB\n" +
+					"\t\tcleanup  func()\n" +
+					"\t\tdbErr    error\n" +
+					"\t\tcache    *Cache\n" +
+					"\t\tcacheErr error\n" +
+					"\t)\n" +
+					"\tvar wg sync.WaitGroup\n" +
+					"\twg.Add(2)\n" +
+					"\tgo func() {\n" +
+					"\t\tdefer wg.Done()\n" +
+					"\t\tdb, cleanup, dbErr = NewDB()\n" +
+					"\t}()\n" +
+					"\tgo func() {\n" +
+					"\t\tdefer wg.Done()\n" +
+					"\t\tcache, cacheErr = NewCache()\n" +
+					"\t}()\n" +
+					"\twg.Wait()\n" +
+					"\tif dbErr != nil || cacheErr != nil {\n" +
+					"\t\tif dbErr == nil {\n" +
+					"\t\t\tcleanup()\n" +
+					"\t\t}\n" +
+					"\t\tif dbErr != nil {\n" +
+					"\t\t\treturn nil, nil, dbErr\n" +
+					"\t\t}\n" +
+					"\t\treturn nil, nil, cacheErr\n" +
+					"\t}\n" +
+					"\tserver := NewServer(db, cache)\n",
+				"\t\"sync\"\n",
+			},
+			// The result of a provider that succeeds is cleaned up when
+			// another provider run at the same time fails.
+			run: `package main
+
+import (
+	"errors"
+	"fmt"
+
+	"example.com/wiretest"
+)
+
+func main() {
+	server, cleanup, err := wiretest.InitializeServer()
+	fmt.Println(server != nil, err)
+	cleanup()
+	fmt.Println(wiretest.Opened, wiretest.Closed)
+
+	wiretest.CacheErr = errors.New("no cache")
+	server, cleanup, err = wiretest.InitializeServer()
+	fmt.Println(server == nil, cleanup == nil, err)
+	fmt.Println(wiretest.Opened, wiretest.Closed)
+}
+`,
+			wantOutput: `true <nil>
+1 1
+true true no cache
+2 2
+`,
+		},
+		{
+			name: "WrapErrors",
+			files: map[string]string{
+				"wire.go": wrapErrorsInjector,
+			},
+			opts: &GenerateOptions{WrapErrors: true},
+			want: []string{
+				"\tdb, err := NewDB()\n" +
+					"\tif err != nil {\n" +
+					"\t\treturn nil, fmt.Errorf(\"wire: NewDB (example.com/wiretest): %w\", err)\n" +
+					"\t}\n",
+				"//go:generate go run -mod=mod github.com/almondoo/wire/cmd/wire gen -wrap_errors\n",
+			},
+		},
+		{
+			name: "WithoutWrapErrors",
+			files: map[string]string{
+				"wire.go": wrapErrorsInjector,
+			},
+			notWant: []string{"fmt.Errorf", "-wrap_errors"},
+		},
+		{
+			name: "Instrument",
+			files: map[string]string{
+				"providers.go": `package wiretest
+
+type DB struct{}
+
+type Server struct {
+	DB *DB
+}
+
+func NewDB() (*DB, error) { return &DB{}, nil }
+`,
+				"wire.go": `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import (
+	"github.com/almondoo/wire"
+	"github.com/almondoo/wire/wirehook"
+)
+
+func InitializeServer(h wirehook.Hooks) (*Server, error) {
+	wire.Build(NewDB, wire.Struct(new(Server), "*"), wire.Instrument())
+	return nil, nil
+}
+
+func InitializeDefault() (*DB, error) {
+	wire.Build(NewDB, wire.Instrument())
+	return nil, nil
+}
+
+func InitializeUninstrumented() (*DB, error) {
+	wire.Build(NewDB)
+	return nil, nil
+}
+`,
+			},
+			want: []string{
+				"func InitializeServer(h wirehook.Hooks) (*Server, error) {\n" +
+					"\th.Start(wirehook.Provider{Name: \"NewDB\", PkgPath: \"example.com/wiretest\"})\n" +
+					"\tdb, err := NewDB()\n" +
+					"\th.End(wirehook.Provider{Name: \"NewDB\", PkgPath: \"example.com/wiretest\"}, err)\n" +
+					"\tif err != nil {\n" +
+					"\t\treturn nil, err\n" +
+					"\t}\n" +
+					"\th.Start(wirehook.Provider{Name: \"Server\", PkgPath: \"example.com/wiretest\"})\n" +
+					"\tserver := &Server{\n" +
+					"\t\tDB: db,\n" +
+					"\t}\n" +
+					"\th.End(wirehook.Provider{Name: \"Server\", PkgPath: \"example.com/wiretest\"}, nil)\n",
+				"func InitializeDefault() (*DB, error) {\n" +
+					"\thooks := wirehook.Default()\n" +
+					"\thooks.Start(wirehook.Provider{Name: \"NewDB\", PkgPath: \"example.com/wiretest\"})\n",
+				"func InitializeUninstrumented() (*DB, error) {\n" +
+					"\tdb, err := NewDB()\n",
+			},
+		},
+		{
+			// Load skips the function bodies of dep, which leaves strings
+			// unused.
+			name: "DependencyBodies",
+			files: map[string]string{
+				"dep/dep.go": `package dep
+
+import (
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/almondoo/wire"
+)
+
+type Config struct {
+	Name    string
+	Timeout time.Duration
+}
+
+type Client struct {
+	Name string
+	W    io.Writer
+}
+
+var registered []string
+
+func init() {
+	registered = append(registered, strings.ToUpper("dep"))
+}
+
+func NewClient(cfg Config, w io.Writer) *Client {
+	return &Client{Name: strings.TrimSpace(cfg.Name), W: w}
+}
+
+var Set = wire.NewSet(
+	NewClient,
+	wire.Value(Config{Name: "dep", Timeout: 5 * time.Second}),
+	wire.InterfaceValue(new(io.Writer), os.Stdout),
+)
+`,
+				"wire.go": `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import (
+	"example.com/wiretest/dep"
+	"github.com/almondoo/wire"
+)
+
+// InitializeClient returns a client configured by dep.
+func InitializeClient() *dep.Client {
+	wire.Build(dep.Set)
+	return nil
+}
+`,
+			},
+			want: []string{
+				"// InitializeClient returns a client configured by dep.\n",
+				"client := dep.NewClient(config, writer)",
+				"dep.Config{Name: \"dep\", Timeout: 5 * time.Second}",
+				"os.Stdout",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			runGenerateCase(t, test)
+		})
+	}
+}
+
+// runGenerateCase writes the fixture module of test, generates its code and
+// checks the result.
+func runGenerateCase(t *testing.T, test generateCase) {
+	dir := t.TempDir()
+	writeIntegrationModule(t, dir)
+	if test.goVersion != "" {
+		goMod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		goMod = bytes.Replace(goMod, []byte("\ngo 1.19\n"), []byte("\ngo "+test.goVersion+"\n"), 1)
+		writeIntegrationFile(t, filepath.Join(dir, "go.mod"), string(goMod))
+	}
+	for name, content := range test.files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		writeIntegrationFile(t, path, content)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
+	defer cancel()
+
+	opts := test.opts
+	if opts == nil {
+		opts = &GenerateOptions{}
+	}
+	results, errs := Generate(ctx, dir, integrationEnv(), []string{"."}, opts)
+	if test.wantErr != "" {
+		for _, res := range results {
+			errs = append(errs, res.Errs...)
+		}
+		assertErrorContains(t, errs, test.wantErr)
+		return
+	}
+	if len(errs) > 0 {
+		t.Fatalf("Generate returned load errors: %v", errs)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d GenerateResults, want 1: %+v", len(results), results)
+	}
+	res := results[0]
+	assertNoErrors(t, res.Errs)
+	content := string(res.Content)
+	offset := 0
+	for _, want := range test.want {
+		i := strings.Index(content[offset:], want)
+		if i < 0 {
+			if test.ordered {
+				t.Errorf("generated content does not contain %q after the previous fragments:\n%s", want, content)
+			} else {
+				t.Errorf("generated content does not contain %q:\n%s", want, content)
+			}
+			continue
+		}
+		if test.ordered {
+			offset += i + len(want)
+		}
+	}
+	for _, s := range test.notWant {
+		if strings.Contains(content, s) {
+			t.Errorf("generated content contains %q:\n%s", s, content)
+		}
+	}
+	for s, want := range test.count {
+		if n := strings.Count(content, s); n != want {
+			t.Errorf("generated content contains %q %d times; want %d:\n%s", s, n, want, content)
+		}
+	}
+
+	if test.run == "" {
+		return
+	}
+	writeIntegrationFile(t, filepath.Join(dir, "wire_gen.go"), content)
+	if err := os.MkdirAll(filepath.Join(dir, "cmd", "run"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeIntegrationFile(t, filepath.Join(dir, "cmd", "run", "main.go"), test.run)
+	cmd := exec.CommandContext(ctx, "go", "run", "./cmd/run")
+	cmd.Dir = dir
+	cmd.Env = integrationEnv()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("run generated code: %v\n%s\ngenerated content:\n%s", err, out, content)
+	}
+	if string(out) != test.wantOutput {
+		t.Errorf("generated code printed:\n%s\nwant:\n%s", out, test.wantOutput)
+	}
+}
+
+// injectorFile returns the source of a file of injectors of the fixture
+// package with the given declarations, which may use package wire.
+func injectorFile(decls string) string {
+	return `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import "github.com/almondoo/wire"
+
+` + decls
+}
+
+// contextInjectorFile is like injectorFile, but the declarations may also
+// use package context.
+func contextInjectorFile(decls string) string {
+	return `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import (
+	"context"
+
+	"github.com/almondoo/wire"
+)
+
+` + decls
+}
+
+// genericInferredFiles are the files of a module whose injector uses generic
+// providers without type arguments.
+var genericInferredFiles = map[string]string{
+	"providers.go": genericProviders,
+	"shop.go": `package wiretest
+
+import "example.com/wiretest/queue"
+
+type Order struct{}
+
+type Shop struct {
+	Users  *Repo[User]
+	Orders *Cache[string, Order]
+	Queue  *queue.Queue[Order]
+}
+`,
+	"queue/queue.go": `package queue
+
+type Queue[T any] struct {
+	items []T
+}
+
+func New[T any]() *Queue[T] { return &Queue[T]{} }
+`,
+	"wire.go": `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import (
+	"example.com/wiretest/queue"
+	"github.com/almondoo/wire"
+)
+
+func InitializeShop() *Shop {
+	wire.Build(
+		NewConfig,
+		wire.NewSet(NewCache),
+		queue.New,
+		wire.Struct(new(Repo[User]), "*"),
+		wire.Struct(new(Shop), "*"),
+	)
+	return nil
+}
+`,
+}
+
+const genericProviders = `package wiretest
+
+type Config struct {
+	Size int
+}
+
+type User struct {
+	Name string
+}
+
+type Cache[K comparable, V any] struct {
+	Size int
+	m    map[K]V
+}
+
+type Repo[T any] struct {
+	Cache *Cache[string, T]
+}
+
+type App struct {
+	Users *Repo[User]
+}
+
+func NewConfig() Config { return Config{Size: 16} }
+
+func NewCache[K comparable, V any](cfg Config) *Cache[K, V] {
+	return &Cache[K, V]{Size: cfg.Size, m: make(map[K]V)}
+}
+
+func NewApp(users *Repo[User]) *App { return &App{Users: users} }
+`
+
+// cleanupErrorsProviders records in Events the cleanups that run. The
+// cleanups of the DB and the exporter fail with CloseDBErr and ExportErr.
+const cleanupErrorsProviders = `package wiretest
+
+import (
+	"context"
+	"errors"
+)
+
+type DB struct{}
+
+type Cache struct{}
+
+type Exporter struct{}
+
+type Server struct{}
+
+var (
+	Events     []string
+	ServerErr  error
+	CloseDBErr = errors.New("close db failed")
+	ExportErr  = errors.New("export failed")
+)
+
+func NewDB() (*DB, func() error, error) {
+	return &DB{}, func() error {
+		Events = append(Events, "close db")
+		return CloseDBErr
+	}, nil
+}
+
+func NewCache() (*Cache, func()) {
+	return &Cache{}, func() { Events = append(Events, "close cache") }
+}
+
+func NewExporter() (*Exporter, func(context.Context) error) {
+	return &Exporter{}, func(context.Context) error {
+		Events = append(Events, "close exporter")
+		return ExportErr
+	}
+}
+
+func NewServer(db *DB, c *Cache, e *Exporter) (*Server, error) { return &Server{}, ServerErr }
+`
+
+var cleanupErrorsInjector = contextInjectorFile(`func InitializeServer(ctx context.Context) (*Server, func(context.Context) error, error) {
+	wire.Build(NewDB, NewCache, NewExporter, NewServer)
+	return nil, nil, nil
+}
+
+func InitializeDB() (*DB, func() error, error) {
+	wire.Build(NewDB)
+	return nil, nil, nil
+}
+`)
+
+const closerProviders = `package wiretest
+
+import "github.com/almondoo/wire"
+
+type Pool struct{}
+
+func (*Pool) Close() error { return nil }
+
+type Server struct{}
+
+func NewPool() (*Pool, error) { return &Pool{}, nil }
+
+func NewServer(p *Pool) *Server { return &Server{} }
+
+var Set = wire.NewSet(wire.Closer(NewPool), NewServer)
+`
+
+var wrapErrorsInjector = injectorFile(`type DB struct{}
+
+func NewDB() (*DB, error) { return &DB{}, nil }
+
+func InitializeDB() (*DB, error) {
+	wire.Build(NewDB)
+	return nil, nil
+}
+`)
+
+func TestGenerateIntegrationOverride(t *testing.T) {
+	dir := t.TempDir()
+	writeIntegrationModule(t, dir)
+	writeIntegrationFile(t, filepath.Join(dir, "providers.go"), `package wiretest
+
+import "github.com/almondoo/wire"
+
+type DB interface {
+	Query() string
+}
+
+type prodDB struct{}
+
+func (prodDB) Query() string { return "prod" }
+
+type fakeDB struct{}
+
+func (fakeDB) Query() string { return "fake" }
+
+type Service struct {
+	DB DB
+}
+
+func NewDB() DB { return prodDB{} }
+
+func NewFakeDB() DB { return fakeDB{} }
+
+func NewService(db DB) *Service { return &Service{DB: db} }
+
+var ProdSet = wire.NewSet(NewDB, NewService)
+
+var TestSet = wire.Override(ProdSet, NewFakeDB)
+`)
+	writeIntegrationFile(t, filepath.Join(dir, "wire.go"), `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import "github.com/almondoo/wire"
+
+func InitializeService() *Service {
+	wire.Build(TestSet)
+	return nil
+}
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
+	defer cancel()
+
+	info, errs := Load(ctx, dir, integrationEnv(), "", []string{"."}, nil)
+	if len(errs) > 0 {
+		t.Fatalf("Load returned errors: %v", errs)
+	}
+	var set *ProviderSet
+	for id, s := range info.Sets {
+		if id.VarName == "TestSet" {
+			set = s
+		}
+	}
+	if set == nil {
+		t.Fatal("Load did not find TestSet")
+	}
+	if len(set.Overrides) != 1 || TypeString(set.Overrides[0].Type) != "example.com/wiretest.DB" {
+		t.Fatalf("TestSet overrides = %+v; want one override of example.com/wiretest.DB", set.Overrides)
+	}
+	if replaced, by := info.Fset.Position(set.Overrides[0].Replaced), info.Fset.Position(set.Overrides[0].By); replaced.Line != 21 || by.Line != 23 {
+		t.Errorf("override positions = %v, %v; want lines 21 and 23", replaced, by)
+	}
+
+	results, errs := Generate(ctx, dir, integrationEnv(), []string{"."}, &GenerateOptions{})
+	if len(errs) > 0 {
+		t.Fatalf("Generate returned load errors: %v", errs)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d GenerateResults, want 1: %+v", len(results), results)
+	}
+	res := results[0]
+	assertNoErrors(t, res.Errs)
+	content := string(res.Content)
+	if !strings.Contains(content, "NewFakeDB()") || strings.Contains(content, "NewDB()") {
+		t.Errorf("generated content does not use NewFakeDB in place of NewDB:\n%s", content)
+	}
+}
+
+func TestGenerateIntegrationJobs(t *testing.T) {
+	dir := t.TempDir()
+	writeIntegrationModule(t, dir)
+	for i := 0; i < 8; i++ {
+		pkgDir := filepath.Join(dir, fmt.Sprintf("p%d", i))
+		if err := os.Mkdir(pkgDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		providers := "func NewDB() *DB { return &DB{} }\n"
+		if i == 3 {
+			// Leave the injector in p3 without a provider for DB.
+			providers = ""
+		}
+		writeIntegrationFile(t, filepath.Join(pkgDir, "providers.go"), fmt.Sprintf(`package p%d
+
+type DB struct{}
+
+type Server struct {
+	DB *DB
+}
+
+func NewServer(db *DB) *Server { return &Server{DB: db} }
+
+%s`, i, providers))
+		set := "NewDB, NewServer"
+		if i == 3 {
+			set = "NewServer"
+		}
+		writeIntegrationFile(t, filepath.Join(pkgDir, "wire.go"), fmt.Sprintf(`//go:build wireinject
+// +build wireinject
+
+package p%d
+
+import "github.com/almondoo/wire"
+
+func InitializeServer() *Server {
+	wire.Build(%s)
+	return nil
+}
+`, i, set))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
+	defer cancel()
+
+	var want []GenerateResult
+	for _, jobs := range []int{1, 4, 0} {
+		opts := &GenerateOptions{Header: []byte("// Header.\n\n"), Jobs: jobs}
+		results, errs := Generate(ctx, dir, integrationEnv(), []string{"./..."}, opts)
+		if len(errs) > 0 {
+			t.Fatalf("Jobs=%d: Generate returned load errors: %v", jobs, errs)
+		}
+		if len(results) != 8 {
+			t.Fatalf("Jobs=%d: got %d GenerateResults, want 8", jobs, len(results))
+		}
+		for i, res := range results {
+			if want := fmt.Sprintf("example.com/wiretest/p%d", i); res.PkgPath != want {
+				t.Errorf("Jobs=%d: results[%d].PkgPath = %q; want %q", jobs, i, res.PkgPath, want)
+			}
+			if i == 3 {
+				if len(res.Errs) != 1 || !strings.Contains(res.Errs[0].Error(), "no provider found for *example.com/wiretest/p3.DB") {
+					t.Errorf("Jobs=%d: results[3].Errs = %v; want missing provider for DB", jobs, res.Errs)
+				}
+				continue
+			}
+			assertNoErrors(t, res.Errs)
+			if !bytes.HasPrefix(res.Content, []byte("// Header.\n\n")) {
+				t.Errorf("Jobs=%d: results[%d].Content does not start with the header:\n%s", jobs, i, res.Content)
+			}
+		}
+		if want == nil {
+			want = results
+			continue
+		}
+		for i := range results {
+			if !bytes.Equal(results[i].Content, want[i].Content) || fmt.Sprint(results[i].Errs) != fmt.Sprint(want[i].Errs) {
+				t.Errorf("Jobs=%d: results[%d] = %+v; want %+v as with Jobs=1", jobs, i, results[i], want[i])
+			}
+		}
+	}
+}
+
+func TestGenerateIntegrationCache(t *testing.T) {
+	dir := t.TempDir()
+	writeIntegrationModule(t, dir)
+	if err := os.Mkdir(filepath.Join(dir, "config"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig := func(name string) {
+		writeIntegrationFile(t, filepath.Join(dir, "config", "config.go"), `package config
+
+import "github.com/almondoo/wire"
+
+type Config struct {
+	Name string
+}
+
+var Set = wire.NewSet(wire.Value(Config{Name: "`+name+`"}))
+`)
+	}
+	writeConfig("first")
+	writeIntegrationFile(t, filepath.Join(dir, "wire.go"), `//go:build wireinject
+// +build wireinject
+
+package wiretest
+
+import (
+	"example.com/wiretest/config"
+	"github.com/almondoo/wire"
+)
+
+func InitializeConfig() config.Config {
+	wire.Build(config.Set)
+	return config.Config{}
+}
+`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), integrationTimeout)
+	defer cancel()
+
+	cacheDir := t.TempDir()
+	generate := func(opts *GenerateOptions) GenerateResult {
+		t.Helper()
+		opts.CacheDir = cacheDir
+		results, errs := Generate(ctx, dir, integrationEnv(), []string{"."}, opts)
+		if len(errs) > 0 {
+			t.Fatalf("Generate returned load errors: %v", errs)
+		}
+		if len(results) != 1 {
+			t.Fatalf("got %d GenerateResults, want 1: %+v", len(results), results)
+		}
+		assertNoErrors(t, results[0].Errs)
+		if want := filepath.Join(dir, "wire_gen.go"); results[0].OutputPath != want {
+			t.Errorf("OutputPath = %q; want %q", results[0].OutputPath, want)
+		}
+		return results[0]
+	}
+
+	first := generate(&GenerateOptions{})
+	if !strings.Contains(string(first.Content), `config.Config{Name: "first"}`) {
+		t.Fatalf("generated content does not contain the value:\n%s", first.Content)
+	}
+	entries := cacheEntries(t, cacheDir)
+	if len(entries) != 1 {
+		t.Fatalf("cache has %d entries; want 1", len(entries))
+	}
+	// Replace the entry, to tell whether Generate returns it or generates
+	// the code again.
+	entry := filepath.Join(cacheDir, entries[0])
+	if err := os.WriteFile(entry, []byte("// cached\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := generate(&GenerateOptions{}); string(got.Content) != "// cached\n" {
+		t.Errorf("Generate with nothing changed returned:\n%s\nwant the cached content", got.Content)
+	}
+	if got := generate(&GenerateOptions{WrapErrors: true}); string(got.Content) == "// cached\n" {
+		t.Error("Generate with other options returned the cached content")
+	}
+	writeConfig("second")
+	got := generate(&GenerateOptions{})
+	if !strings.Contains(string(got.Content), `config.Config{Name: "second"}`) {
+		t.Errorf("Generate after a dependency changed returned:\n%s\nwant the new value", got.Content)
+	}
+}
@@ -19,10 +19,13 @@ import (
 	"errors"
 	"fmt"
 	"go/ast"
+	"go/constant"
+	"go/parser"
 	"go/token"
 	"go/types"
 	"os"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -34,12 +37,32 @@ import (
 // A providerSetSrc captures the source for a type provided by a ProviderSet.
 // Exactly one of the fields will be set.
 type providerSetSrc struct {
-	Provider    *Provider
-	Binding     *IfaceBinding
-	Value       *Value
-	Import      *ProviderSet
-	InjectorArg *InjectorArg
-	Field       *Field
+	Provider     *Provider
+	Binding      *IfaceBinding
+	Value        *Value
+	Import       *ProviderSet
+	InjectorArg  *InjectorArg
+	Field        *Field
+	Multibinding *Multibinding
+	Optional     *Optional
+	Decorator    *Decorator
+
+	// next is the source in Import of a type that Import provides by
+	// instantiating a generic provider, which is not in its srcMap.
+	next *providerSetSrc
+}
+
+// parent returns the source of typ in the provider set that p imports, or
+// nil if p is not an import or the set does not provide typ.
+func (p *providerSetSrc) parent(typ types.Type) *providerSetSrc {
+	if p.Import == nil {
+		return nil
+	}
+	if p.next != nil {
+		return p.next
+	}
+	src, _ := p.Import.srcMap.At(typ).(*providerSetSrc)
+	return src
 }
 
 // description returns a string describing the source of p, including line numbers.
@@ -53,7 +76,9 @@ func (p *providerSetSrc) description(fset *token.FileSet, typ types.Type) string
 	switch {
 	case p.Provider != nil:
 		kind := "provider"
-		if p.Provider.IsStruct {
+		if p.Provider.outputs {
+			kind = "injector outputs"
+		} else if p.Provider.IsStruct {
 			kind = "struct provider"
 		}
 		return fmt.Sprintf("%s %s(%s)", kind, quoted(p.Provider.Name), fset.Position(p.Provider.Pos))
@@ -68,6 +93,12 @@ func (p *providerSetSrc) description(fset *token.FileSet, typ types.Type) string
 		return fmt.Sprintf("argument %s to injector function %s (%s)", args.Tuple.At(p.InjectorArg.Index).Name(), args.Name, fset.Position(args.Pos))
 	case p.Field != nil:
 		return fmt.Sprintf("wire.FieldsOf (%s)", fset.Position(p.Field.Pos))
+	case p.Multibinding != nil:
+		return fmt.Sprintf("%s (%s)", p.Multibinding.marker(), fset.Position(p.Multibinding.Pos))
+	case p.Optional != nil:
+		return fmt.Sprintf("wire.Optional (%s)", fset.Position(p.Optional.Pos))
+	case p.Decorator != nil:
+		return fmt.Sprintf("decorator %s(%s)", quoted(p.Decorator.Provider.Name), fset.Position(p.Decorator.Provider.Pos))
 	}
 	panic("providerSetSrc with no fields set")
 }
@@ -77,15 +108,42 @@ func (p *providerSetSrc) description(fset *token.FileSet, typ types.Type) string
 func (p *providerSetSrc) trace(fset *token.FileSet, typ types.Type) []string {
 	var retval []string
 	// Only Imports need recursion.
-	if p.Import != nil {
-		if parent := p.Import.srcMap.At(typ); parent != nil {
-			retval = append(retval, parent.(*providerSetSrc).trace(fset, typ)...)
-		}
+	if parent := p.parent(typ); parent != nil {
+		retval = append(retval, parent.trace(fset, typ)...)
 	}
 	retval = append(retval, p.description(fset, typ))
 	return retval
 }
 
+// pos returns the position of the provider that p refers to for typ,
+// following imported provider sets.
+func (p *providerSetSrc) pos(typ types.Type) token.Pos {
+	switch {
+	case p.Provider != nil:
+		return p.Provider.Pos
+	case p.Binding != nil:
+		return p.Binding.Pos
+	case p.Value != nil:
+		return p.Value.Pos
+	case p.Import != nil:
+		if parent := p.parent(typ); parent != nil {
+			return parent.pos(typ)
+		}
+		return p.Import.Pos
+	case p.InjectorArg != nil:
+		return p.InjectorArg.Args.Pos
+	case p.Field != nil:
+		return p.Field.Pos
+	case p.Multibinding != nil:
+		return p.Multibinding.Pos
+	case p.Optional != nil:
+		return p.Optional.Pos
+	case p.Decorator != nil:
+		return p.Decorator.Pos
+	}
+	panic("providerSetSrc with no fields set")
+}
+
 // A ProviderSet describes a set of providers.  The zero value is an empty
 // ProviderSet.
 type ProviderSet struct {
@@ -98,14 +156,28 @@ type ProviderSet struct {
 	// variable.
 	VarName string
 
-	Providers []*Provider
-	Bindings  []*IfaceBinding
-	Values    []*Value
-	Fields    []*Field
-	Imports   []*ProviderSet
+	Providers     []*Provider
+	Bindings      []*IfaceBinding
+	Values        []*Value
+	Fields        []*Field
+	Multibindings []*Multibinding
+	Optionals     []*Optional
+	Decorators    []*Decorator
+	Imports       []*ProviderSet
 	// InjectorArgs is only filled in for wire.Build.
 	InjectorArgs *InjectorArgs
 
+	// options holds the injector options passed to wire.Build.
+	options injectorOptions
+
+	// Overrides is only filled in for wire.Override. It lists the types
+	// whose providers in the overridden set were replaced, sorted by type.
+	Overrides []*Override
+
+	// override is true for a set created by wire.Override, whose first
+	// import is the overridden set.
+	override bool
+
 	// providerMap maps from provided type to a *ProvidedType.
 	// It includes all of the imported types.
 	providerMap *typeutil.Map
@@ -113,6 +185,10 @@ type ProviderSet struct {
 	// srcMap maps from provided type to a *providerSetSrc capturing the
 	// Provider, Binding, Value, or Import that provided the type.
 	srcMap *typeutil.Map
+
+	// qualifiers qualifies the types provided under names in the load that
+	// created the set.
+	qualifiers *qualifiers
 }
 
 // Outputs returns a new slice containing the set of possible types the
@@ -130,6 +206,38 @@ func (set *ProviderSet) For(t types.Type) ProvidedType {
 	return *pt.(*ProvidedType)
 }
 
+// injectorOptions holds the options given to a wire.Build call that change
+// how the injector is generated.
+type injectorOptions struct {
+	// checkContext is set by wire.CheckContext.
+	checkContext bool
+	// parallel is set by wire.Parallel.
+	parallel bool
+	// instrument is set by wire.Instrument.
+	instrument bool
+}
+
+// An injectorOption is an option passed to wire.Build.
+type injectorOption struct {
+	// Pos is the position of the call to the option's function.
+	Pos token.Pos
+
+	// apply sets the option.
+	apply func(*injectorOptions)
+}
+
+// An Override records a type whose provider was replaced by wire.Override.
+type Override struct {
+	// Type is the type whose provider was replaced.
+	Type types.Type
+
+	// Replaced is the position of the provider that was replaced.
+	Replaced token.Pos
+
+	// By is the position of the provider that replaced it.
+	By token.Pos
+}
+
 // An IfaceBinding declares that a type should be used to satisfy inputs
 // of the given interface type.
 type IfaceBinding struct {
@@ -177,6 +285,36 @@ type Provider struct {
 	// HasErr reports whether the provider function can return an error.
 	// (Always false for structs.)
 	HasErr bool
+
+	// CleanupErr reports whether the provider's cleanup function returns
+	// an error, as func() error or func(context.Context) error.
+	CleanupErr bool
+
+	// CleanupCtx reports whether the provider's cleanup function takes a
+	// context.Context, as func(context.Context) error.
+	CleanupCtx bool
+
+	// Closer reports whether the provider's cleanup function is the Close
+	// method of the value it provides rather than a value it returns.
+	Closer bool
+
+	// TypeArgs is the list of type arguments a generic function or struct
+	// is instantiated with. It is nil if the provider is not generic.
+	TypeArgs []types.Type
+
+	// outputs is true if this provider is the struct or component
+	// interface of an injector's outputs (see outputsProvider).
+	outputs bool
+
+	// generic is the function of a generic provider that was not
+	// instantiated. Its Args and Out mention the type parameters of the
+	// function, and solve instantiates it for the types it needs (see
+	// ProviderSet.instantiate).
+	generic *types.Func
+
+	// origin is the generic provider that this provider instantiates, if
+	// solve inferred its type arguments.
+	origin *Provider
 }
 
 // ProviderInput describes an incoming edge in the provider graph.
@@ -185,6 +323,10 @@ type ProviderInput struct {
 
 	// If the provider is a struct, FieldName will be the field name to set.
 	FieldName string
+
+	// Optional is true if the input is left as its zero value when no
+	// provider is found for Type.
+	Optional bool
 }
 
 // Value describes a value expression.
@@ -237,6 +379,92 @@ type Field struct {
 	Out []types.Type
 }
 
+// Multibinding describes a slice or map whose elements are contributed by
+// several providers, declared by wire.SliceOf or wire.MapOf.
+type Multibinding struct {
+	// Out is the slice or map type that is provided.
+	Out types.Type
+
+	// Pos is the position of the call to wire.SliceOf or wire.MapOf. If the
+	// multibinding merges the contributions of several provider sets, it is
+	// the position of the provider set that merged them.
+	Pos token.Pos
+
+	// Elems are the types provided by the contributing providers, in
+	// order. Each is a key created by elementKey, so that the contributions
+	// are not provided on their own.
+	Elems []types.Type
+
+	// Keys are the map keys for Elems. It is nil for slices.
+	Keys []string
+
+	// providers and values are the contributions declared by this call to
+	// wire.SliceOf or wire.MapOf. They are added to the enclosing provider
+	// set.
+	providers []*Provider
+	values    []*Value
+
+	// sources are the sources that contributed to a merged multibinding.
+	sources []*providerSetSrc
+}
+
+// marker returns the name of the wire function that declares m.
+func (m *Multibinding) marker() string {
+	if m.Keys != nil {
+		return "wire.MapOf"
+	}
+	return "wire.SliceOf"
+}
+
+// Optional describes a type that may be injected even if no provider is
+// found for it, declared by wire.Optional.
+type Optional struct {
+	// Out is the type that may be left unprovided.
+	Out types.Type
+
+	// Pos is the position of the call to wire.Optional.
+	Pos token.Pos
+
+	// Default is the value used when no provider is found for Out, or nil
+	// if the zero value of Out is used.
+	Default *Value
+}
+
+// Decorator describes a function that wraps the values of its output type,
+// declared by wire.Decorate.
+type Decorator struct {
+	// Provider is the decorator function. Its output type is the type it
+	// decorates, and exactly one of its arguments has the same type: the
+	// value being decorated.
+	Provider *Provider
+
+	// Pos is the position of the call to wire.Decorate.
+	Pos token.Pos
+}
+
+// Decorated returns the type that d decorates.
+func (d *Decorator) Decorated() types.Type {
+	return d.Provider.Out[0]
+}
+
+// decoratedArg returns the index of the argument of d that receives the
+// value being decorated.
+func (d *Decorator) decoratedArg() int {
+	for i, arg := range d.Provider.Args {
+		if types.Identical(arg.Type, d.Decorated()) {
+			return i
+		}
+	}
+	panic("decorator without decorated argument")
+}
+
+// A decoration is a decorator applied to a provided type, along with the
+// source in a provider set that declared it.
+type decoration struct {
+	d   *Decorator
+	src *providerSetSrc
+}
+
 // Load finds all the provider sets in the packages that match the given
 // patterns, as well as the provider sets' transitive dependencies. It
 // may return both errors and Info. The patterns are defined by the
@@ -248,8 +476,12 @@ type Field struct {
 // env is nil or empty, it is interpreted as an empty set of variables.
 // In case of duplicate environment variables, the last one in the list
 // takes precedence.
-func Load(ctx context.Context, wd string, env []string, tags string, patterns []string) (*Info, []error) {
-	pkgs, errs := load(ctx, wd, env, tags, patterns)
+//
+// overlay maps absolute file paths to contents to use instead of the
+// contents of the files on disk, such as the unsaved buffers of an editor. It
+// may be nil.
+func Load(ctx context.Context, wd string, env []string, tags string, patterns []string, overlay map[string][]byte) (*Info, []error) {
+	pkgs, errs := load(ctx, wd, env, tags, patterns, overlay)
 	if len(errs) > 0 {
 		return nil, errs
 	}
@@ -260,6 +492,7 @@ func Load(ctx context.Context, wd string, env []string, tags string, patterns []
 	info := &Info{
 		Fset: fset,
 		Sets: make(map[ProviderSetID]*ProviderSet),
+		pkgs: pkgs,
 	}
 	oc := newObjectCache(pkgs)
 	ec := new(errorCollector)
@@ -332,6 +565,9 @@ func Load(ctx context.Context, wd string, env []string, tags string, patterns []
 				info.Injectors = append(info.Injectors, &Injector{
 					ImportPath: pkg.PkgPath,
 					FuncName:   fn.Name.Name,
+					Pos:        fn.Pos(),
+					Out:        out.out,
+					Set:        set,
 				})
 			}
 		}
@@ -348,37 +584,169 @@ func Load(ctx context.Context, wd string, env []string, tags string, patterns []
 // variables to use when loading the packages specified by patterns. If
 // env is nil or empty, it is interpreted as an empty set of variables.
 // In case of duplicate environment variables, the last one in the list
-// takes precedence.
-func load(ctx context.Context, wd string, env []string, tags string, patterns []string) ([]*packages.Package, []error) {
+// takes precedence. overlay is passed to go/packages, as described by
+// packages.Config.Overlay.
+func load(ctx context.Context, wd string, env []string, tags string, patterns []string, overlay map[string][]byte) ([]*packages.Package, []error) {
+	cfg := loadConfig(ctx, wd, env, tags, overlay)
+	cfg.Mode = packages.LoadAllSyntax
+	escaped := escapePatterns(patterns)
+	if skipDependencyBodies {
+		parseFile, err := rootParseFile(cfg, escaped)
+		if err != nil {
+			return nil, []error{err}
+		}
+		cfg.ParseFile = parseFile
+	}
+	pkgs, err := packages.Load(cfg, escaped...)
+	if err != nil {
+		return nil, []error{err}
+	}
+	var errs []error
+	for _, p := range pkgs {
+		args, calls := genericProviderArgs(p)
+		for _, e := range p.Errors {
+			if e.Kind == packages.TypeError && (args[e.Pos] || calls[e.Pos] && strings.Contains(e.Msg, "cannot infer")) {
+				continue
+			}
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return pkgs, nil
+}
+
+// genericProviderArgs returns the positions of the generic functions that
+// are passed without type arguments to the wire.Build calls in p, or to the
+// wire.NewSet calls in their arguments, and the positions of the calls they
+// are passed to. Go does not allow a generic function to be used without
+// instantiating it, but the Go compiler never builds injector functions, so
+// Wire lets it infer the type arguments of such providers and ignores the
+// type errors about them. Modules that require Go 1.21 or later report that
+// the type arguments cannot be inferred at the call, and older ones report
+// the errors at the functions.
+func genericProviderArgs(p *packages.Package) (args, calls map[string]bool) {
+	if len(p.Errors) == 0 || p.TypesInfo == nil {
+		return nil, nil
+	}
+	isWireCall := func(expr ast.Expr, name string) (*ast.CallExpr, bool) {
+		call, ok := astutil.Unparen(expr).(*ast.CallExpr)
+		if !ok {
+			return nil, false
+		}
+		fn, ok := qualifiedIdentObject(p.TypesInfo, call.Fun).(*types.Func)
+		if !ok || fn.Pkg() == nil || !isWireImport(fn.Pkg().Path()) || fn.Name() != name {
+			return nil, false
+		}
+		return call, true
+	}
+	args = make(map[string]bool)
+	calls = make(map[string]bool)
+	var visitArgs func(call *ast.CallExpr)
+	visitArgs = func(call *ast.CallExpr) {
+		for _, arg := range call.Args {
+			if set, ok := isWireCall(arg, "NewSet"); ok {
+				visitArgs(set)
+				continue
+			}
+			arg = astutil.Unparen(arg)
+			fn, ok := qualifiedIdentObject(p.TypesInfo, arg).(*types.Func)
+			if ok && fn.Type().(*types.Signature).TypeParams().Len() > 0 {
+				args[p.Fset.Position(arg.Pos()).String()] = true
+				calls[p.Fset.Position(call.Pos()).String()] = true
+			}
+		}
+	}
+	for _, f := range p.Syntax {
+		ast.Inspect(f, func(n ast.Node) bool {
+			if e, ok := n.(ast.Expr); ok {
+				if call, ok := isWireCall(e, "Build"); ok {
+					visitArgs(call)
+					return false
+				}
+			}
+			return true
+		})
+	}
+	return args, calls
+}
+
+// loadConfig returns the configuration to load packages with, without a
+// mode. The arguments are those of load.
+func loadConfig(ctx context.Context, wd string, env []string, tags string, overlay map[string][]byte) *packages.Config {
 	cfg := &packages.Config{
 		Context:    ctx,
-		Mode:       packages.LoadAllSyntax,
 		Dir:        wd,
 		Env:        env,
+		Overlay:    overlay,
 		BuildFlags: []string{"-tags=wireinject"},
-		// TODO(light): Use ParseFile to skip function bodies and comments in indirect packages.
 	}
 	if len(tags) > 0 {
 		cfg.BuildFlags[0] += " " + tags
 	}
+	return cfg
+}
+
+// escapePatterns escapes patterns for packages.Load, so that patterns that
+// look like queries of the go/packages driver are taken as patterns.
+func escapePatterns(patterns []string) []string {
 	escaped := make([]string, len(patterns))
 	for i := range patterns {
 		escaped[i] = "pattern=" + patterns[i]
 	}
-	pkgs, err := packages.Load(cfg, escaped...)
+	return escaped
+}
+
+// skipDependencyBodies is whether load skips the function bodies of the
+// packages that are not initial packages. It is only turned off by
+// benchmarks, to measure how much time skipping them saves.
+var skipDependencyBodies = true
+
+// rootParseFile returns a packages.Config.ParseFile function that parses the
+// files of the initial packages in full, and the other files without their
+// comments and function bodies.
+//
+// Wire only reads the package-level declarations of the dependencies of the
+// initial packages: provider set variables, the expressions passed to
+// wire.Value and the signatures of providers all live outside function
+// bodies. Removing the bodies from the syntax keeps them from being
+// type-checked, which is most of the work of loading a large program. The
+// type errors this causes in dependencies, such as unused imports, are not
+// reported, because load only reports errors in the initial packages.
+func rootParseFile(cfg *packages.Config, patterns []string) (func(*token.FileSet, string, []byte) (*ast.File, error), error) {
+	// Listing the files of the initial packages is cheap compared to loading
+	// their syntax and types.
+	listCfg := *cfg
+	listCfg.Mode = packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles
+	roots, err := packages.Load(&listCfg, patterns...)
 	if err != nil {
-		return nil, []error{err}
+		return nil, err
 	}
-	var errs []error
-	for _, p := range pkgs {
-		for _, e := range p.Errors {
-			errs = append(errs, e)
+	rootFiles := make(map[string]bool)
+	for _, p := range roots {
+		for _, f := range p.GoFiles {
+			rootFiles[f] = true
+		}
+		for _, f := range p.CompiledGoFiles {
+			rootFiles[f] = true
 		}
 	}
-	if len(errs) > 0 {
-		return nil, errs
-	}
-	return pkgs, nil
+	return func(fset *token.FileSet, filename string, src []byte) (*ast.File, error) {
+		if rootFiles[filename] {
+			return parser.ParseFile(fset, filename, src, parser.AllErrors|parser.ParseComments)
+		}
+		f, err := parser.ParseFile(fset, filename, src, parser.AllErrors|parser.SkipObjectResolution)
+		if f == nil {
+			return nil, err
+		}
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok {
+				fn.Body = nil
+			}
+		}
+		return f, err
+	}, nil
 }
 
 // Info holds the result of Load.
@@ -391,6 +759,9 @@ type Info struct {
 	// Injectors contains all the injector functions in the initial packages.
 	// The order is undefined.
 	Injectors []*Injector
+
+	// pkgs are the initial packages.
+	pkgs []*packages.Package
 }
 
 // A ProviderSetID identifies a named provider set.
@@ -408,6 +779,15 @@ func (id ProviderSetID) String() string {
 type Injector struct {
 	ImportPath string
 	FuncName   string
+
+	// Pos is the source position of the injector function.
+	Pos token.Pos
+	// Out is the type the injector returns, without its cleanup function
+	// and error.
+	Out types.Type
+	// Set is the provider set passed to wire.Build, including the
+	// injector's arguments.
+	Set *ProviderSet
 }
 
 // String returns the injector name as ""path/to/pkg".Foo".
@@ -421,6 +801,8 @@ type objectCache struct {
 	packages map[string]*packages.Package
 	objects  map[objRef]objCacheEntry
 	hasher   typeutil.Hasher
+	// qualifiers qualifies the types provided under names in this load.
+	qualifiers *qualifiers
 }
 
 type objRef struct {
@@ -438,10 +820,11 @@ func newObjectCache(pkgs []*packages.Package) *objectCache {
 		panic("object cache must have packages to draw from")
 	}
 	oc := &objectCache{
-		fset:     pkgs[0].Fset,
-		packages: make(map[string]*packages.Package),
-		objects:  make(map[objRef]objCacheEntry),
-		hasher:   typeutil.MakeHasher(),
+		fset:       pkgs[0].Fset,
+		packages:   make(map[string]*packages.Package),
+		objects:    make(map[objRef]objCacheEntry),
+		hasher:     typeutil.MakeHasher(),
+		qualifiers: newQualifiers(),
 	}
 	// Depth-first search of all dependencies to gather import path to
 	// packages.Package mapping. go/packages guarantees that for a single
@@ -530,6 +913,12 @@ func (oc *objectCache) processExpr(info *types.Info, pkgPath string, expr ast.Ex
 			return notePosition(exprPos, err)
 		})
 	}
+	if fn, inst := funcInstance(info, expr); fn != nil {
+		p, errs := processNamedFuncProvider(oc.fset, nil, fn, inst, nil)
+		return p, mapErrors(errs, func(err error) error {
+			return notePosition(exprPos, err)
+		})
+	}
 	if call, ok := expr.(*ast.CallExpr); ok {
 		fnObj := qualifiedIdentObject(info, call.Fun)
 		if fnObj == nil {
@@ -565,7 +954,7 @@ func (oc *objectCache) processExpr(info *types.Info, pkgPath string, expr ast.Ex
 			}
 			return v, nil
 		case "Struct":
-			s, err := processStructProvider(oc.fset, info, call)
+			s, err := processStructProvider(oc.fset, oc.qualifiers, info, call)
 			if err != nil {
 				return nil, []error{notePosition(exprPos, err)}
 			}
@@ -576,6 +965,45 @@ func (oc *objectCache) processExpr(info *types.Info, pkgPath string, expr ast.Ex
 				return nil, []error{notePosition(exprPos, err)}
 			}
 			return v, nil
+		case "Named":
+			item, errs := oc.processNamed(info, pkgPath, call)
+			return item, notePositionAll(exprPos, errs)
+		case "NamedArgs":
+			p, errs := processNamedArgs(oc.fset, oc.qualifiers, info, call)
+			return p, notePositionAll(exprPos, errs)
+		case "SliceOf", "MapOf":
+			m, errs := oc.processMultibinding(info, pkgPath, call, fnObj.Name() == "MapOf")
+			return m, notePositionAll(exprPos, errs)
+		case "Optional":
+			o, err := processOptional(oc.fset, info, call)
+			if err != nil {
+				return nil, []error{notePosition(exprPos, err)}
+			}
+			return o, nil
+		case "Decorate":
+			d, errs := processDecorator(oc.fset, info, call)
+			return d, notePositionAll(exprPos, errs)
+		case "Override":
+			pset, errs := oc.processOverride(info, pkgPath, call, varName)
+			return pset, notePositionAll(exprPos, errs)
+		case "Closer":
+			p, errs := oc.processCloser(info, pkgPath, call)
+			return p, notePositionAll(exprPos, errs)
+		case "CheckContext":
+			return &injectorOption{
+				Pos:   call.Pos(),
+				apply: func(opts *injectorOptions) { opts.checkContext = true },
+			}, nil
+		case "Parallel":
+			return &injectorOption{
+				Pos:   call.Pos(),
+				apply: func(opts *injectorOptions) { opts.parallel = true },
+			}, nil
+		case "Instrument":
+			return &injectorOption{
+				Pos:   call.Pos(),
+				apply: func(opts *injectorOptions) { opts.instrument = true },
+			}, nil
 		default:
 			return nil, []error{notePosition(exprPos, errors.New("unknown pattern"))}
 		}
@@ -598,9 +1026,27 @@ func (oc *objectCache) processNewSet(info *types.Info, pkgPath string, call *ast
 		InjectorArgs: args,
 		PkgPath:      pkgPath,
 		VarName:      varName,
+		qualifiers:   oc.qualifiers,
+	}
+	if errs := oc.addSetItems(info, pkgPath, pset, call.Args); len(errs) > 0 {
+		return nil, errs
+	}
+	var errs []error
+	pset.providerMap, pset.srcMap, errs = buildProviderMap(oc.fset, oc.hasher, pset)
+	if len(errs) > 0 {
+		return nil, errs
 	}
+	if errs := verifyAcyclic(pset.providerMap, oc.hasher); len(errs) > 0 {
+		return nil, errs
+	}
+	return pset, nil
+}
+
+// addSetItems processes the arguments to a call to wire.NewSet, wire.Build
+// or wire.Override and adds the resulting items to pset.
+func (oc *objectCache) addSetItems(info *types.Info, pkgPath string, pset *ProviderSet, args []ast.Expr) []error {
 	ec := new(errorCollector)
-	for _, arg := range call.Args {
+	for _, arg := range args {
 		item, errs := oc.processExpr(info, pkgPath, arg, "")
 		if len(errs) > 0 {
 			ec.add(errs...)
@@ -617,14 +1063,55 @@ func (oc *objectCache) processNewSet(info *types.Info, pkgPath string, call *ast
 			pset.Values = append(pset.Values, item)
 		case []*Field:
 			pset.Fields = append(pset.Fields, item...)
+		case *Multibinding:
+			pset.Multibindings = append(pset.Multibindings, item)
+			pset.Providers = append(pset.Providers, item.providers...)
+			pset.Values = append(pset.Values, item.values...)
+		case *Optional:
+			pset.Optionals = append(pset.Optionals, item)
+		case *Decorator:
+			pset.Decorators = append(pset.Decorators, item)
+		case *injectorOption:
+			if pset.InjectorArgs == nil {
+				ec.add(notePosition(oc.fset.Position(item.Pos), errors.New("injector options can only be passed to wire.Build")))
+				continue
+			}
+			item.apply(&pset.options)
 		default:
 			panic("unknown item type")
 		}
 	}
-	if len(ec.errors) > 0 {
-		return nil, ec.errors
+	return ec.errors
+}
+
+// processOverride creates a provider set from a wire.Override call.
+func (oc *objectCache) processOverride(info *types.Info, pkgPath string, call *ast.CallExpr, varName string) (*ProviderSet, []error) {
+	// Assumes that call.Fun is wire.Override.
+
+	if len(call.Args) < 1 {
+		return nil, []error{notePosition(oc.fset.Position(call.Pos()),
+			errors.New("call to Override must specify the provider set to override"))}
+	}
+	item, errs := oc.processExpr(info, pkgPath, call.Args[0], "")
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	base, ok := item.(*ProviderSet)
+	if !ok {
+		return nil, []error{notePosition(oc.fset.Position(call.Pos()),
+			errors.New("first argument to Override must be a provider set"))}
+	}
+	pset := &ProviderSet{
+		Pos:        call.Pos(),
+		PkgPath:    pkgPath,
+		VarName:    varName,
+		Imports:    []*ProviderSet{base},
+		override:   true,
+		qualifiers: oc.qualifiers,
+	}
+	if errs := oc.addSetItems(info, pkgPath, pset, call.Args[1:]); len(errs) > 0 {
+		return nil, errs
 	}
-	var errs []error
 	pset.providerMap, pset.srcMap, errs = buildProviderMap(oc.fset, oc.hasher, pset)
 	if len(errs) > 0 {
 		return nil, errs
@@ -632,6 +1119,20 @@ func (oc *objectCache) processNewSet(info *types.Info, pkgPath string, call *ast
 	if errs := verifyAcyclic(pset.providerMap, oc.hasher); len(errs) > 0 {
 		return nil, errs
 	}
+	base.srcMap.Iterate(func(t types.Type, v interface{}) {
+		src := pset.srcMap.At(t).(*providerSetSrc)
+		if src.Import == base {
+			return
+		}
+		pset.Overrides = append(pset.Overrides, &Override{
+			Type:     t,
+			Replaced: v.(*providerSetSrc).pos(t),
+			By:       src.pos(t),
+		})
+	})
+	sort.Slice(pset.Overrides, func(i, j int) bool {
+		return TypeString(pset.Overrides[i].Type) < TypeString(pset.Overrides[j].Type)
+	})
 	return pset, nil
 }
 
@@ -672,9 +1173,148 @@ func qualifiedIdentObject(info *types.Info, expr ast.Expr) types.Object {
 	}
 }
 
-// processFuncProvider creates a provider for a function declaration.
+// funcInstance returns the generic function that expr instantiates along
+// with its instantiation, or nil if expr is not an instantiated function.
+func funcInstance(info *types.Info, expr ast.Expr) (*types.Func, *types.Instance) {
+	x := unindex(expr)
+	if x == expr {
+		return nil, nil
+	}
+	fn, ok := qualifiedIdentObject(info, x).(*types.Func)
+	if !ok {
+		return nil, nil
+	}
+	id, ok := x.(*ast.Ident)
+	if !ok {
+		id = x.(*ast.SelectorExpr).Sel
+	}
+	inst, ok := info.Instances[id]
+	if !ok {
+		return nil, nil
+	}
+	return fn, &inst
+}
+
+// unindex strips the type arguments from an instantiation expression like
+// F[int] or pkg.T[K, V]. Other expressions are returned unchanged.
+func unindex(expr ast.Expr) ast.Expr {
+	switch expr := expr.(type) {
+	case *ast.IndexExpr:
+		return expr.X
+	case *ast.IndexListExpr:
+		return expr.X
+	default:
+		return expr
+	}
+}
+
+// typeListSlice returns the types in list, or nil if list is empty.
+func typeListSlice(list *types.TypeList) []types.Type {
+	if list.Len() == 0 {
+		return nil
+	}
+	ts := make([]types.Type, list.Len())
+	for i := range ts {
+		ts[i] = list.At(i)
+	}
+	return ts
+}
+
+// processFuncProvider creates a provider for a function declaration. A
+// generic function that is not instantiated creates a generic provider.
 func processFuncProvider(fset *token.FileSet, fn *types.Func) (*Provider, []error) {
+	if fn.Type().(*types.Signature).TypeParams().Len() > 0 {
+		return genericFuncProvider(fset, fn)
+	}
+	return processNamedFuncProvider(fset, nil, fn, nil, nil)
+}
+
+// genericFuncProvider creates a provider for a generic function whose type
+// arguments are inferred from the types that it is used to provide. Every
+// type parameter must appear in the provided type, so that the type
+// arguments can be inferred from it.
+func genericFuncProvider(fset *token.FileSet, fn *types.Func) (*Provider, []error) {
+	sig := fn.Type().(*types.Signature)
+	fpos := fset.Position(fn.Pos())
+	providerSig, err := funcOutput(sig)
+	if err != nil {
+		return nil, []error{notePosition(fpos, fmt.Errorf("wrong signature for provider %s: %v", fn.Name(), err))}
+	}
+	if _, ok := providerSig.out.(*types.TypeParam); ok {
+		return nil, []error{notePosition(fpos, fmt.Errorf("generic provider %s must be instantiated: it can provide any type", fn.Name()))}
+	}
+	tparams := sig.TypeParams()
+	for i := 0; i < tparams.Len(); i++ {
+		if !mentions(providerSig.out, tparams.At(i)) {
+			return nil, []error{notePosition(fpos, fmt.Errorf("generic provider %s must be instantiated: type parameter %s does not appear in %s", fn.Name(), tparams.At(i), types.TypeString(providerSig.out, nil)))}
+		}
+	}
+	params := sig.Params()
+	provider := &Provider{
+		Pkg:        fn.Pkg(),
+		Name:       fn.Name(),
+		Pos:        fn.Pos(),
+		Args:       make([]ProviderInput, params.Len()),
+		Varargs:    sig.Variadic(),
+		Out:        []types.Type{providerSig.out},
+		HasCleanup: providerSig.cleanup,
+		HasErr:     providerSig.err,
+		CleanupErr: providerSig.cleanupErr,
+		CleanupCtx: providerSig.cleanupCtx,
+		generic:    fn,
+	}
+	for i := 0; i < params.Len(); i++ {
+		provider.Args[i] = ProviderInput{Type: params.At(i).Type()}
+	}
+	return provider, nil
+}
+
+// mentions reports whether t refers to the type parameter tparam.
+func mentions(t types.Type, tparam *types.TypeParam) bool {
+	switch t := t.(type) {
+	case *types.TypeParam:
+		return t == tparam
+	case *types.Pointer:
+		return mentions(t.Elem(), tparam)
+	case *types.Slice:
+		return mentions(t.Elem(), tparam)
+	case *types.Array:
+		return mentions(t.Elem(), tparam)
+	case *types.Map:
+		return mentions(t.Key(), tparam) || mentions(t.Elem(), tparam)
+	case *types.Chan:
+		return mentions(t.Elem(), tparam)
+	case *types.Named:
+		args := t.TypeArgs()
+		for i := 0; i < args.Len(); i++ {
+			if mentions(args.At(i), tparam) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// processNamedFuncProvider creates a provider for a function declaration
+// whose leading parameters request the types provided under the given names,
+// qualified by q. q may be nil if there are no names. A generic function
+// must be given the instantiation to provide.
+func processNamedFuncProvider(fset *token.FileSet, q *qualifiers, fn *types.Func, inst *types.Instance, names []string) (*Provider, []error) {
 	sig := fn.Type().(*types.Signature)
+	var typeArgs []types.Type
+	if inst != nil {
+		sig = inst.Type.(*types.Signature)
+		typeArgs = typeListSlice(inst.TypeArgs)
+	} else if sig.TypeParams().Len() > 0 {
+		return nil, []error{notePosition(fset.Position(fn.Pos()), fmt.Errorf("generic provider %s must be instantiated", fn.Name()))}
+	}
+	return funcProvider(fset, q, fn, sig, typeArgs, names)
+}
+
+// funcProvider creates a provider for fn, which has the signature sig once
+// instantiated with typeArgs. The arguments are those of
+// processNamedFuncProvider.
+func funcProvider(fset *token.FileSet, q *qualifiers, fn *types.Func, sig *types.Signature, typeArgs []types.Type, names []string) (*Provider, []error) {
 	fpos := fn.Pos()
 	providerSig, err := funcOutput(sig)
 	if err != nil {
@@ -690,14 +1330,24 @@ func processFuncProvider(fset *token.FileSet, fn *types.Func) (*Provider, []erro
 		Out:        []types.Type{providerSig.out},
 		HasCleanup: providerSig.cleanup,
 		HasErr:     providerSig.err,
+		CleanupErr: providerSig.cleanupErr,
+		CleanupCtx: providerSig.cleanupCtx,
+		TypeArgs:   typeArgs,
 	}
 	for i := 0; i < params.Len(); i++ {
 		provider.Args[i] = ProviderInput{
 			Type: params.At(i).Type(),
 		}
+		if i < len(names) {
+			t, err := q.qualify(provider.Args[i].Type, names[i])
+			if err != nil {
+				return nil, []error{notePosition(fset.Position(fpos), err)}
+			}
+			provider.Args[i].Type = t
+		}
 		for j := 0; j < i; j++ {
 			if types.Identical(provider.Args[i].Type, provider.Args[j].Type) {
-				return nil, []error{notePosition(fset.Position(fpos), fmt.Errorf("provider has multiple parameters of type %s", types.TypeString(provider.Args[j].Type, nil)))}
+				return nil, []error{notePosition(fset.Position(fpos), fmt.Errorf("provider has multiple parameters of type %s", TypeString(provider.Args[j].Type)))}
 			}
 		}
 	}
@@ -716,6 +1366,11 @@ type outputSignature struct {
 	out     types.Type
 	cleanup bool
 	err     bool
+
+	// cleanupErr is true if the cleanup function returns an error.
+	cleanupErr bool
+	// cleanupCtx is true if the cleanup function takes a context.Context.
+	cleanupCtx bool
 }
 
 // funcOutput validates an injector or provider function's return signature.
@@ -728,46 +1383,81 @@ func funcOutput(sig *types.Signature) (outputSignature, error) {
 		return outputSignature{out: results.At(0).Type()}, nil
 	case 2:
 		out := results.At(0).Type()
-		switch t := results.At(1).Type(); {
-		case types.Identical(t, errorType):
+		t := results.At(1).Type()
+		if types.Identical(t, errorType) {
 			return outputSignature{out: out, err: true}, nil
-		case types.Identical(t, cleanupType):
-			return outputSignature{out: out, cleanup: true}, nil
-		default:
-			return outputSignature{}, fmt.Errorf("second return type is %s; must be error or func()", types.TypeString(t, nil))
 		}
+		if cleanupErr, cleanupCtx, ok := cleanupSignature(t); ok {
+			return outputSignature{out: out, cleanup: true, cleanupErr: cleanupErr, cleanupCtx: cleanupCtx}, nil
+		}
+		return outputSignature{}, fmt.Errorf("second return type is %s; must be error or a cleanup function", types.TypeString(t, nil))
 	case 3:
-		if t := results.At(1).Type(); !types.Identical(t, cleanupType) {
-			return outputSignature{}, fmt.Errorf("second return type is %s; must be func()", types.TypeString(t, nil))
+		cleanupErr, cleanupCtx, ok := cleanupSignature(results.At(1).Type())
+		if !ok {
+			return outputSignature{}, fmt.Errorf("second return type is %s; must be a cleanup function", types.TypeString(results.At(1).Type(), nil))
 		}
 		if t := results.At(2).Type(); !types.Identical(t, errorType) {
 			return outputSignature{}, fmt.Errorf("third return type is %s; must be error", types.TypeString(t, nil))
 		}
 		return outputSignature{
-			out:     results.At(0).Type(),
-			cleanup: true,
-			err:     true,
+			out:        results.At(0).Type(),
+			cleanup:    true,
+			err:        true,
+			cleanupErr: cleanupErr,
+			cleanupCtx: cleanupCtx,
 		}, nil
 	default:
 		return outputSignature{}, errors.New("too many return values")
 	}
 }
 
-// processStructLiteralProvider creates a provider for a named struct type.
-// It produces pointer and non-pointer variants via two values in Out.
-//
-// This is a copy of the old processStructProvider, which is deprecated now.
-// It will not support any new feature introduced after v0.2. Please use the new
-// wire.Struct syntax for those.
-func processStructLiteralProvider(fset *token.FileSet, typeName *types.TypeName) (*Provider, []error) {
-	out := typeName.Type()
-	st, ok := out.Underlying().(*types.Struct)
-	if !ok {
-		return nil, []error{fmt.Errorf("%v does not name a struct", typeName)}
+// cleanupSignature reports whether t is the type of a cleanup function:
+// func(), func() error or func(context.Context) error. errs reports whether
+// the function returns an error, and ctx whether it takes a context.Context.
+func cleanupSignature(t types.Type) (errs, ctx, ok bool) {
+	if types.Identical(t, cleanupType) {
+		return false, false, true
 	}
-
-	pos := typeName.Pos()
-	fmt.Fprintf(os.Stderr,
+	sig, ok := t.(*types.Signature)
+	if !ok || sig.Variadic() || sig.Results().Len() != 1 || !types.Identical(sig.Results().At(0).Type(), errorType) {
+		return false, false, false
+	}
+	switch sig.Params().Len() {
+	case 0:
+		return true, false, true
+	case 1:
+		if isContextType(sig.Params().At(0).Type()) {
+			return true, true, true
+		}
+	}
+	return false, false, false
+}
+
+// isContextType reports whether t is context.Context.
+func isContextType(t types.Type) bool {
+	n, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := n.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == "context" && obj.Name() == "Context"
+}
+
+// processStructLiteralProvider creates a provider for a named struct type.
+// It produces pointer and non-pointer variants via two values in Out.
+//
+// This is a copy of the old processStructProvider, which is deprecated now.
+// It will not support any new feature introduced after v0.2. Please use the new
+// wire.Struct syntax for those.
+func processStructLiteralProvider(fset *token.FileSet, typeName *types.TypeName) (*Provider, []error) {
+	out := typeName.Type()
+	st, ok := out.Underlying().(*types.Struct)
+	if !ok {
+		return nil, []error{fmt.Errorf("%v does not name a struct", typeName)}
+	}
+
+	pos := typeName.Pos()
+	fmt.Fprintf(os.Stderr,
 		"Warning: %v, see https://godoc.org/github.com/almondoo/wire#Struct for more information.\n",
 		notePosition(fset.Position(pos),
 			fmt.Errorf("using struct literal to inject %s is deprecated and will be removed in the next release; use wire.Struct instead",
@@ -797,7 +1487,8 @@ func processStructLiteralProvider(fset *token.FileSet, typeName *types.TypeName)
 
 // processStructProvider creates a provider for a named struct type.
 // It produces pointer and non-pointer variants via two values in Out.
-func processStructProvider(fset *token.FileSet, info *types.Info, call *ast.CallExpr) (*Provider, error) {
+// Fields tagged with a name request types qualified by q.
+func processStructProvider(fset *token.FileSet, q *qualifiers, info *types.Info, call *ast.CallExpr) (*Provider, error) {
 	// Assumes that call.Fun is wire.Struct.
 
 	if len(call.Args) < 1 {
@@ -819,7 +1510,7 @@ func processStructProvider(fset *token.FileSet, info *types.Info, call *ast.Call
 	}
 
 	stExpr := call.Args[0].(*ast.CallExpr)
-	typeName := qualifiedIdentObject(info, stExpr.Args[0]) // should be either an identifier or selector
+	typeName := qualifiedIdentObject(info, unindex(stExpr.Args[0])) // should be either an identifier or selector
 	provider := &Provider{
 		Pkg:      typeName.Pkg(),
 		Name:     typeName.Name(),
@@ -827,15 +1518,23 @@ func processStructProvider(fset *token.FileSet, info *types.Info, call *ast.Call
 		IsStruct: true,
 		Out:      []types.Type{structPtr.Elem(), structPtr},
 	}
+	if named, ok := structPtr.Elem().(*types.Named); ok {
+		provider.TypeArgs = typeListSlice(named.TypeArgs())
+	}
 	if allFields(call) {
 		for i := 0; i < st.NumFields(); i++ {
 			if isPrevented(st.Tag(i)) {
 				continue
 			}
 			f := st.Field(i)
+			t, err := q.qualify(f.Type(), fieldQualifier(st.Tag(i)))
+			if err != nil {
+				return nil, notePosition(fset.Position(f.Pos()), err)
+			}
 			provider.Args = append(provider.Args, ProviderInput{
-				Type:      f.Type(),
+				Type:      t,
 				FieldName: f.Name(),
+				Optional:  fieldOptional(st.Tag(i)),
 			})
 		}
 	} else {
@@ -845,9 +1544,15 @@ func processStructProvider(fset *token.FileSet, info *types.Info, call *ast.Call
 			if err != nil {
 				return nil, notePosition(fset.Position(call.Pos()), err)
 			}
+			tag := fieldTag(st, v)
+			t, err := q.qualify(v.Type(), fieldQualifier(tag))
+			if err != nil {
+				return nil, notePosition(fset.Position(call.Pos()), err)
+			}
 			provider.Args[i-1] = ProviderInput{
-				Type:      v.Type(),
+				Type:      t,
 				FieldName: v.Name(),
+				Optional:  fieldOptional(tag),
 			}
 		}
 	}
@@ -855,13 +1560,133 @@ func processStructProvider(fset *token.FileSet, info *types.Info, call *ast.Call
 		for j := 0; j < i; j++ {
 			if types.Identical(provider.Args[i].Type, provider.Args[j].Type) {
 				f := st.Field(j)
-				return nil, notePosition(fset.Position(f.Pos()), fmt.Errorf("provider struct has multiple fields of type %s", types.TypeString(provider.Args[j].Type, nil)))
+				return nil, notePosition(fset.Position(f.Pos()), fmt.Errorf("provider struct has multiple fields of type %s", TypeString(provider.Args[j].Type)))
+			}
+		}
+	}
+	return provider, nil
+}
+
+// outputsProvider returns a provider that assembles the injector output type
+// out from several values. If out is a struct or a pointer to a struct that
+// embeds wire.Outputs, the provider fills in its fields. If out is an
+// interface that embeds wire.Component, the provider has an argument for the
+// result of each method and is not a struct. Otherwise it returns nil.
+// Fields tagged with a name request types qualified by q.
+func outputsProvider(fset *token.FileSet, q *qualifiers, out types.Type) (*Provider, error) {
+	if named, ok := out.(*types.Named); ok {
+		if iface, ok := named.Underlying().(*types.Interface); ok {
+			return componentProvider(fset, named, iface)
+		}
+	}
+	t := out
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok {
+		return nil, nil
+	}
+	st, ok := named.Underlying().(*types.Struct)
+	if !ok {
+		return nil, nil
+	}
+	provider := &Provider{
+		Pkg:      named.Obj().Pkg(),
+		Name:     named.Obj().Name(),
+		Pos:      named.Obj().Pos(),
+		IsStruct: true,
+		Out:      []types.Type{out},
+		TypeArgs: typeListSlice(named.TypeArgs()),
+		outputs:  true,
+	}
+	marked := false
+	var fields []int
+	for i := 0; i < st.NumFields(); i++ {
+		if isOutputsMarker(st.Field(i)) {
+			marked = true
+			continue
+		}
+		if isPrevented(st.Tag(i)) {
+			continue
+		}
+		fields = append(fields, i)
+	}
+	if !marked {
+		return nil, nil
+	}
+	for _, i := range fields {
+		f := st.Field(i)
+		t, err := q.qualify(f.Type(), fieldQualifier(st.Tag(i)))
+		if err != nil {
+			return nil, notePosition(fset.Position(f.Pos()), err)
+		}
+		provider.Args = append(provider.Args, ProviderInput{
+			Type:      t,
+			FieldName: f.Name(),
+			Optional:  fieldOptional(st.Tag(i)),
+		})
+	}
+	for i := 0; i < len(provider.Args); i++ {
+		for j := 0; j < i; j++ {
+			if types.Identical(provider.Args[i].Type, provider.Args[j].Type) {
+				return nil, notePosition(fset.Position(st.Field(fields[i]).Pos()), fmt.Errorf("injector outputs struct has multiple fields of type %s", TypeString(provider.Args[i].Type)))
 			}
 		}
 	}
 	return provider, nil
 }
 
+// componentProvider returns the provider of a component interface, or nil
+// if iface does not embed wire.Component.
+func componentProvider(fset *token.FileSet, named *types.Named, iface *types.Interface) (*Provider, error) {
+	marked := false
+	for i := 0; i < iface.NumEmbeddeds(); i++ {
+		if isWireMarker(iface.EmbeddedType(i), "Component") {
+			marked = true
+		}
+	}
+	if !marked {
+		return nil, nil
+	}
+	provider := &Provider{
+		Pkg:      named.Obj().Pkg(),
+		Name:     named.Obj().Name(),
+		Pos:      named.Obj().Pos(),
+		Out:      []types.Type{named},
+		TypeArgs: typeListSlice(named.TypeArgs()),
+		outputs:  true,
+	}
+	for i := 0; i < iface.NumMethods(); i++ {
+		m := iface.Method(i)
+		sig := m.Type().(*types.Signature)
+		if sig.Params().Len() > 0 || sig.Results().Len() != 1 {
+			return nil, notePosition(fset.Position(m.Pos()), fmt.Errorf("component method %s must have no parameters and return a single value", m.Name()))
+		}
+		provider.Args = append(provider.Args, ProviderInput{
+			Type:      sig.Results().At(0).Type(),
+			FieldName: m.Name(),
+		})
+	}
+	return provider, nil
+}
+
+// isOutputsMarker reports whether f is an embedded wire.Outputs field.
+func isOutputsMarker(f *types.Var) bool {
+	return f.Embedded() && isWireMarker(f.Type(), "Outputs")
+}
+
+// isWireMarker reports whether t is the named type of the wire package with
+// the given name.
+func isWireMarker(t types.Type, name string) bool {
+	n, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := n.Obj()
+	return obj.Pkg() != nil && isWireImport(obj.Pkg().Path()) && obj.Name() == name
+}
+
 func allFields(call *ast.CallExpr) bool {
 	if len(call.Args) != 2 {
 		return false
@@ -874,12 +1699,42 @@ func allFields(call *ast.CallExpr) bool {
 }
 
 // isPrevented checks whether field i is prevented by tag "-".
-// Since this is the only tag used by wire, we can do string comparison
-// without using reflect.
 func isPrevented(tag string) bool {
 	return reflect.StructTag(tag).Get("wire") == "-"
 }
 
+// fieldQualifier returns the name requested by a `wire:"name=..."` tag, or
+// the empty string if the tag does not request a name.
+func fieldQualifier(tag string) string {
+	for _, opt := range strings.Split(reflect.StructTag(tag).Get("wire"), ",") {
+		if name := strings.TrimPrefix(opt, "name="); name != opt {
+			return name
+		}
+	}
+	return ""
+}
+
+// fieldOptional reports whether a `wire:"optional"` tag allows the field to
+// be left as its zero value.
+func fieldOptional(tag string) bool {
+	for _, opt := range strings.Split(reflect.StructTag(tag).Get("wire"), ",") {
+		if opt == "optional" {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldTag returns the tag of the field v of st.
+func fieldTag(st *types.Struct, v *types.Var) string {
+	for i := 0; i < st.NumFields(); i++ {
+		if st.Field(i) == v {
+			return st.Tag(i)
+		}
+	}
+	return ""
+}
+
 // processBind creates an interface binding from a wire.Bind call.
 func processBind(fset *token.FileSet, info *types.Info, call *ast.CallExpr) (*IfaceBinding, error) {
 	// Assumes that call.Fun is wire.Bind.
@@ -933,8 +1788,27 @@ func processValue(fset *token.FileSet, info *types.Info, call *ast.CallExpr) (*V
 	if len(call.Args) != 1 {
 		return nil, notePosition(fset.Position(call.Pos()), errors.New("call to Value takes exactly one argument"))
 	}
+	if !isSimpleValue(info, call.Args[0]) {
+		return nil, notePosition(fset.Position(call.Pos()), errors.New("argument to Value is too complex"))
+	}
+	// Result type can't be an interface type; use wire.InterfaceValue for that.
+	argType := info.TypeOf(call.Args[0])
+	if _, isInterfaceType := argType.Underlying().(*types.Interface); isInterfaceType {
+		return nil, notePosition(fset.Position(call.Pos()), fmt.Errorf("argument to Value may not be an interface value (found %s); use InterfaceValue instead", types.TypeString(argType, nil)))
+	}
+	return &Value{
+		Pos:  call.Args[0].Pos(),
+		Out:  info.TypeOf(call.Args[0]),
+		expr: call.Args[0],
+		info: info,
+	}, nil
+}
+
+// isSimpleValue reports whether expr may be copied to the generated injector
+// by wire.Value: it must not call functions or receive from channels.
+func isSimpleValue(info *types.Info, expr ast.Expr) bool {
 	ok := true
-	ast.Inspect(call.Args[0], func(node ast.Node) bool {
+	ast.Inspect(expr, func(node ast.Node) bool {
 		switch expr := node.(type) {
 		case nil, *ast.ArrayType, *ast.BasicLit, *ast.BinaryExpr, *ast.ChanType, *ast.CompositeLit, *ast.FuncType, *ast.Ident, *ast.IndexExpr, *ast.InterfaceType, *ast.KeyValueExpr, *ast.MapType, *ast.ParenExpr, *ast.SelectorExpr, *ast.SliceExpr, *ast.StarExpr, *ast.StructType, *ast.TypeAssertExpr:
 			// Good!
@@ -955,20 +1829,72 @@ func processValue(fset *token.FileSet, info *types.Info, call *ast.CallExpr) (*V
 		}
 		return true
 	})
+	return ok
+}
+
+// processOptional creates an optional binding from a wire.Optional call.
+func processOptional(fset *token.FileSet, info *types.Info, call *ast.CallExpr) (*Optional, error) {
+	// Assumes that call.Fun is wire.Optional.
+
+	if len(call.Args) < 1 || len(call.Args) > 2 {
+		return nil, notePosition(fset.Position(call.Pos()), errors.New("call to Optional takes one or two arguments"))
+	}
+	typArgType := info.TypeOf(call.Args[0])
+	typPtr, ok := typArgType.(*types.Pointer)
 	if !ok {
-		return nil, notePosition(fset.Position(call.Pos()), errors.New("argument to Value is too complex"))
+		return nil, notePosition(fset.Position(call.Pos()), fmt.Errorf("first argument to Optional must be a pointer to a type; found %s", types.TypeString(typArgType, nil)))
 	}
-	// Result type can't be an interface type; use wire.InterfaceValue for that.
-	argType := info.TypeOf(call.Args[0])
-	if _, isInterfaceType := argType.Underlying().(*types.Interface); isInterfaceType {
-		return nil, notePosition(fset.Position(call.Pos()), fmt.Errorf("argument to Value may not be an interface value (found %s); use InterfaceValue instead", types.TypeString(argType, nil)))
+	o := &Optional{
+		Out: typPtr.Elem(),
+		Pos: call.Pos(),
 	}
-	return &Value{
-		Pos:  call.Args[0].Pos(),
-		Out:  info.TypeOf(call.Args[0]),
-		expr: call.Args[0],
+	if len(call.Args) == 1 {
+		return o, nil
+	}
+	def := call.Args[1]
+	if !isSimpleValue(info, def) {
+		return nil, notePosition(fset.Position(call.Pos()), errors.New("default value for Optional is too complex"))
+	}
+	if defType := info.TypeOf(def); !types.AssignableTo(defType, o.Out) && !constantAssignable(info.Types[def], o.Out) {
+		return nil, notePosition(fset.Position(call.Pos()), fmt.Errorf("default value of type %s is not assignable to %s", types.TypeString(defType, nil), types.TypeString(o.Out, nil)))
+	}
+	o.Default = &Value{
+		Pos:  def.Pos(),
+		Out:  o.Out,
+		expr: def,
 		info: info,
-	}, nil
+	}
+	return o, nil
+}
+
+// constantAssignable reports whether tv, the type and value of an untyped
+// constant that was converted to its default type when passed to a marker
+// function, could have been assigned to t.
+func constantAssignable(tv types.TypeAndValue, t types.Type) bool {
+	if tv.Value == nil {
+		return false
+	}
+	b, ok := t.Underlying().(*types.Basic)
+	if !ok {
+		return false
+	}
+	var kind types.BasicKind
+	var info types.BasicInfo
+	switch tv.Value.Kind() {
+	case constant.Bool:
+		kind, info = types.UntypedBool, types.IsBoolean
+	case constant.String:
+		kind, info = types.UntypedString, types.IsString
+	case constant.Int:
+		kind, info = types.UntypedInt, types.IsNumeric
+	case constant.Float:
+		kind, info = types.UntypedFloat, types.IsFloat|types.IsComplex
+	case constant.Complex:
+		kind, info = types.UntypedComplex, types.IsComplex
+	default:
+		return false
+	}
+	return types.Identical(tv.Type, types.Default(types.Typ[kind])) && b.Info()&info != 0
 }
 
 // processInterfaceValue creates a value from a wire.InterfaceValue call.
@@ -1000,6 +1926,300 @@ func processInterfaceValue(fset *token.FileSet, info *types.Info, call *ast.Call
 	}, nil
 }
 
+// processNamed creates a provider, value or interface binding from a
+// wire.Named call by qualifying the types that its second argument provides.
+func (oc *objectCache) processNamed(info *types.Info, pkgPath string, call *ast.CallExpr) (interface{}, []error) {
+	// Assumes that call.Fun is wire.Named.
+
+	if len(call.Args) != 2 {
+		return nil, []error{notePosition(oc.fset.Position(call.Pos()),
+			errors.New("call to Named takes exactly two arguments"))}
+	}
+	name, ok := stringConstant(info, call.Args[0])
+	if !ok || name == "" {
+		return nil, []error{notePosition(oc.fset.Position(call.Pos()),
+			errors.New("first argument to Named must be a non-empty string constant"))}
+	}
+	item, errs := oc.processExpr(info, pkgPath, call.Args[1], "")
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	alreadyNamed := func(t types.Type) error {
+		if _, q := unqualify(t); q != "" {
+			return notePosition(oc.fset.Position(call.Pos()),
+				fmt.Errorf("%s is already named; cannot name it %q", TypeString(t), name))
+		}
+		return nil
+	}
+	// Items returned by processExpr may be shared through the object cache,
+	// so make copies instead of modifying them.
+	switch item := item.(type) {
+	case *Provider:
+		p := *item
+		p.Out = make([]types.Type, len(item.Out))
+		for i, t := range item.Out {
+			if err := alreadyNamed(t); err != nil {
+				return nil, []error{err}
+			}
+			qt, err := oc.qualifiers.qualify(t, name)
+			if err != nil {
+				return nil, []error{notePosition(oc.fset.Position(call.Pos()), err)}
+			}
+			p.Out[i] = qt
+		}
+		return &p, nil
+	case *Value:
+		if err := alreadyNamed(item.Out); err != nil {
+			return nil, []error{err}
+		}
+		v := *item
+		out, err := oc.qualifiers.qualify(item.Out, name)
+		if err != nil {
+			return nil, []error{notePosition(oc.fset.Position(call.Pos()), err)}
+		}
+		v.Out = out
+		return &v, nil
+	case *IfaceBinding:
+		if err := alreadyNamed(item.Iface); err != nil {
+			return nil, []error{err}
+		}
+		b := *item
+		iface, err := oc.qualifiers.qualify(item.Iface, name)
+		if err != nil {
+			return nil, []error{notePosition(oc.fset.Position(call.Pos()), err)}
+		}
+		provided, err := oc.qualifiers.qualify(item.Provided, name)
+		if err != nil {
+			return nil, []error{notePosition(oc.fset.Position(call.Pos()), err)}
+		}
+		b.Iface, b.Provided = iface, provided
+		return &b, nil
+	default:
+		return nil, []error{notePosition(oc.fset.Position(call.Pos()),
+			errors.New("second argument to Named must be a provider function or a call to Struct, Value, InterfaceValue, NamedArgs or Bind"))}
+	}
+}
+
+// processCloser creates a provider from a wire.Closer call.
+func (oc *objectCache) processCloser(info *types.Info, pkgPath string, call *ast.CallExpr) (*Provider, []error) {
+	// Assumes that call.Fun is wire.Closer.
+
+	if len(call.Args) != 1 {
+		return nil, []error{notePosition(oc.fset.Position(call.Pos()),
+			errors.New("call to Closer takes exactly one argument"))}
+	}
+	item, errs := oc.processExpr(info, pkgPath, call.Args[0], "")
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	provider, ok := item.(*Provider)
+	if !ok || provider.IsStruct {
+		return nil, []error{notePosition(oc.fset.Position(call.Pos()),
+			errors.New("argument to Closer must be a provider function or a call to NamedArgs or Named"))}
+	}
+	if provider.HasCleanup {
+		return nil, []error{notePosition(oc.fset.Position(call.Pos()),
+			fmt.Errorf("provider %s passed to Closer already returns a cleanup function", provider.Name))}
+	}
+	out, _ := unqualify(provider.Out[0])
+	if !hasCloseMethod(out) {
+		return nil, []error{notePosition(oc.fset.Position(call.Pos()),
+			fmt.Errorf("%s provided by %s does not have a method Close() error", types.TypeString(out, nil), provider.Name))}
+	}
+	// Items returned by processExpr may be shared through the object cache,
+	// so make a copy instead of modifying it.
+	p := *provider
+	p.HasCleanup = true
+	p.CleanupErr = true
+	p.Closer = true
+	return &p, nil
+}
+
+// hasCloseMethod reports whether t has a method Close() error.
+func hasCloseMethod(t types.Type) bool {
+	obj, _, _ := types.LookupFieldOrMethod(t, false, nil, "Close")
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return false
+	}
+	sig := fn.Type().(*types.Signature)
+	return sig.Params().Len() == 0 && sig.Results().Len() == 1 && types.Identical(sig.Results().At(0).Type(), errorType)
+}
+
+// processNamedArgs creates a provider from a wire.NamedArgs call, whose
+// names qualify types with q.
+func processNamedArgs(fset *token.FileSet, q *qualifiers, info *types.Info, call *ast.CallExpr) (*Provider, []error) {
+	// Assumes that call.Fun is wire.NamedArgs.
+
+	if len(call.Args) < 1 {
+		return nil, []error{notePosition(fset.Position(call.Pos()),
+			errors.New("call to NamedArgs must specify the provider function"))}
+	}
+	fnExpr := astutil.Unparen(call.Args[0])
+	fn, inst := funcInstance(info, fnExpr)
+	if fn == nil {
+		var ok bool
+		fn, ok = qualifiedIdentObject(info, fnExpr).(*types.Func)
+		if !ok {
+			return nil, []error{notePosition(fset.Position(call.Pos()),
+				errors.New("first argument to NamedArgs must be a provider function"))}
+		}
+	}
+	names := make([]string, 0, len(call.Args)-1)
+	for _, arg := range call.Args[1:] {
+		name, ok := stringConstant(info, arg)
+		if !ok {
+			return nil, []error{notePosition(fset.Position(arg.Pos()),
+				errors.New("arguments to NamedArgs after the provider function must be string constants"))}
+		}
+		names = append(names, name)
+	}
+	if n := fn.Type().(*types.Signature).Params().Len(); len(names) > n {
+		return nil, []error{notePosition(fset.Position(call.Pos()),
+			fmt.Errorf("NamedArgs given %d names, but %s only has %d parameters", len(names), fn.Name(), n))}
+	}
+	return processNamedFuncProvider(fset, q, fn, inst, names)
+}
+
+// processDecorator creates a decorator from a wire.Decorate call.
+func processDecorator(fset *token.FileSet, info *types.Info, call *ast.CallExpr) (*Decorator, []error) {
+	// Assumes that call.Fun is wire.Decorate.
+
+	if len(call.Args) != 1 {
+		return nil, []error{notePosition(fset.Position(call.Pos()),
+			errors.New("call to Decorate takes exactly one argument"))}
+	}
+	fnExpr := astutil.Unparen(call.Args[0])
+	fn, inst := funcInstance(info, fnExpr)
+	if fn == nil {
+		var ok bool
+		fn, ok = qualifiedIdentObject(info, fnExpr).(*types.Func)
+		if !ok {
+			return nil, []error{notePosition(fset.Position(call.Pos()),
+				errors.New("argument to Decorate must be a function"))}
+		}
+	}
+	p, errs := processNamedFuncProvider(fset, nil, fn, inst, nil)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	d := &Decorator{Provider: p, Pos: call.Pos()}
+	for _, arg := range p.Args {
+		if types.Identical(arg.Type, d.Decorated()) {
+			return d, nil
+		}
+	}
+	return nil, []error{notePosition(fset.Position(call.Pos()),
+		fmt.Errorf("decorator %s must have a parameter of its result type %s", fn.Name(), TypeString(d.Decorated())))}
+}
+
+// processMultibinding creates a multibinding from a wire.SliceOf or a
+// wire.MapOf call.
+func (oc *objectCache) processMultibinding(info *types.Info, pkgPath string, call *ast.CallExpr, isMap bool) (*Multibinding, []error) {
+	// Assumes that call.Fun is wire.SliceOf or wire.MapOf.
+
+	fnName := "SliceOf"
+	if isMap {
+		fnName = "MapOf"
+	}
+	if len(call.Args) < 1 {
+		return nil, []error{notePosition(oc.fset.Position(call.Pos()),
+			fmt.Errorf("call to %s must specify the element type", fnName))}
+	}
+	elemPtr, ok := info.TypeOf(call.Args[0]).(*types.Pointer)
+	if !ok {
+		return nil, []error{notePosition(oc.fset.Position(call.Pos()),
+			fmt.Errorf("first argument to %s must be a pointer to the element type; found %s", fnName, types.TypeString(info.TypeOf(call.Args[0]), nil)))}
+	}
+	elem := elemPtr.Elem()
+	m := &Multibinding{
+		Out: types.NewSlice(elem),
+		Pos: call.Pos(),
+	}
+	entries := call.Args[1:]
+	if isMap {
+		m.Out = types.NewMap(types.Typ[types.String], elem)
+		m.Keys = []string{}
+		if len(entries)%2 != 0 {
+			return nil, []error{notePosition(oc.fset.Position(call.Pos()),
+				errors.New("arguments to MapOf after the element type must be pairs of a key and a provider"))}
+		}
+	}
+	ec := new(errorCollector)
+	for i := 0; i < len(entries); i++ {
+		if isMap {
+			key, ok := stringConstant(info, entries[i])
+			if !ok {
+				ec.add(notePosition(oc.fset.Position(entries[i].Pos()),
+					errors.New("map key passed to MapOf must be a string constant")))
+				i++
+				continue
+			}
+			for _, k := range m.Keys {
+				if k == key {
+					ec.add(notePosition(oc.fset.Position(entries[i].Pos()),
+						fmt.Errorf("multiple values for key %q of %s", key, TypeString(m.Out))))
+				}
+			}
+			m.Keys = append(m.Keys, key)
+			i++
+		}
+		arg := entries[i]
+		item, errs := oc.processExpr(info, pkgPath, arg, "")
+		if len(errs) > 0 {
+			ec.add(errs...)
+			continue
+		}
+		// Items returned by processExpr may be shared through the object
+		// cache, so make copies instead of modifying them.
+		switch item := item.(type) {
+		case *Provider:
+			var out types.Type
+			for _, t := range item.Out {
+				if types.AssignableTo(t, elem) {
+					out = t
+					break
+				}
+			}
+			if out == nil {
+				ec.add(notePosition(oc.fset.Position(arg.Pos()),
+					fmt.Errorf("%s provides %s, which is not assignable to %s", item.Name, TypeString(item.Out[0]), types.TypeString(elem, nil))))
+				continue
+			}
+			p := *item
+			p.Out = []types.Type{elementKey(out, m.Out)}
+			m.Elems = append(m.Elems, p.Out[0])
+			m.providers = append(m.providers, &p)
+		case *Value:
+			if !types.AssignableTo(item.Out, elem) {
+				ec.add(notePosition(oc.fset.Position(arg.Pos()),
+					fmt.Errorf("value of type %s is not assignable to %s", TypeString(item.Out), types.TypeString(elem, nil))))
+				continue
+			}
+			v := *item
+			v.Out = elementKey(item.Out, m.Out)
+			m.Elems = append(m.Elems, v.Out)
+			m.values = append(m.values, &v)
+		default:
+			ec.add(notePosition(oc.fset.Position(arg.Pos()),
+				fmt.Errorf("values passed to %s must be provided by a function or a call to Struct, Value, InterfaceValue or NamedArgs", fnName)))
+		}
+	}
+	if len(ec.errors) > 0 {
+		return nil, ec.errors
+	}
+	return m, nil
+}
+
+// stringConstant returns the value of expr if it is a constant string.
+func stringConstant(info *types.Info, expr ast.Expr) (string, bool) {
+	tv, ok := info.Types[expr]
+	if !ok || tv.Value == nil || tv.Value.Kind() != constant.String {
+		return "", false
+	}
+	return constant.StringVal(tv.Value), true
+}
+
 // processFieldsOf creates a slice of fields from a wire.FieldsOf call.
 func processFieldsOf(fset *token.FileSet, info *types.Info, call *ast.CallExpr) ([]*Field, error) {
 	// Assumes that call.Fun is wire.FieldsOf.
@@ -1134,12 +2354,32 @@ func findInjectorBuild(info *types.Info, fn *ast.FuncDecl) (*ast.CallExpr, error
 }
 
 func isWireImport(path string) bool {
+	return unvendor(path) == "github.com/almondoo/wire"
+}
+
+// wireHookPath is the import path of the package called by instrumented
+// injectors.
+const wireHookPath = "github.com/almondoo/wire/wirehook"
+
+// isWireHooksType reports whether t is wirehook.Hooks.
+func isWireHooksType(t types.Type) bool {
+	n, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := n.Obj()
+	return obj.Pkg() != nil && unvendor(obj.Pkg().Path()) == wireHookPath && obj.Name() == "Hooks"
+}
+
+// unvendor returns the import path of a package without its vendor
+// directory, if any.
+func unvendor(path string) string {
 	// TODO(light): This is depending on details of the current loader.
 	const vendorPart = "vendor/"
 	if i := strings.LastIndex(path, vendorPart); i != -1 && (i == 0 || path[i-1] == '/') {
 		path = path[i+len(vendorPart):]
 	}
-	return path == "github.com/almondoo/wire"
+	return path
 }
 
 func isProviderSetType(t types.Type) bool {
@@ -1151,10 +2391,140 @@ func isProviderSetType(t types.Type) bool {
 	return obj.Pkg() != nil && isWireImport(obj.Pkg().Path()) && obj.Name() == "ProviderSet"
 }
 
+// qualifierPkg is the package of the synthetic generic types that qualify
+// types provided under a name given to wire.Named.
+var qualifierPkg = types.NewPackage("", "")
+
+// qualifiers holds the synthetic generic types that qualify types provided
+// under a name given to wire.Named. Each load has its own, so that loads do
+// not share types or need to lock.
+type qualifiers struct {
+	// origins maps a name to the generic type used to qualify types
+	// provided under that name.
+	origins map[string]*types.Named
+}
+
+func newQualifiers() *qualifiers {
+	return &qualifiers{origins: make(map[string]*types.Named)}
+}
+
+// qualify returns the type that represents t provided under name. Qualified
+// types are instances of a synthetic generic type with t as the type
+// argument, so they can be used as keys in a typeutil.Map and compared with
+// types.Identical like any other provided type. If name is empty, qualify
+// returns t.
+func (q *qualifiers) qualify(t types.Type, name string) (types.Type, error) {
+	if name == "" {
+		return t, nil
+	}
+	origin := q.origins[name]
+	if origin == nil {
+		tparam := types.NewTypeParam(types.NewTypeName(token.NoPos, qualifierPkg, "T", nil), types.Universe.Lookup("any").Type())
+		origin = types.NewNamed(types.NewTypeName(token.NoPos, qualifierPkg, name, nil), types.NewStruct(nil, nil), nil)
+		origin.SetTypeParams([]*types.TypeParam{tparam})
+		q.origins[name] = origin
+	}
+	inst, err := types.Instantiate(nil, origin, []types.Type{t}, false)
+	if err != nil {
+		return nil, fmt.Errorf("qualify %s with name %q: %v", types.TypeString(t, nil), name, err)
+	}
+	return inst, nil
+}
+
+// elementPkg is the package of the synthetic generic types that key the
+// values contributed to a wire.SliceOf or wire.MapOf.
+var elementPkg = types.NewPackage("", "")
+
+// elementKey returns a new type that keys a value of type t contributed to
+// the slice or map type collection. Every call returns a type that is not
+// identical to any other type, so that contributions of the same type do
+// not conflict with each other.
+func elementKey(t, collection types.Type) types.Type {
+	tparams := []*types.TypeParam{
+		types.NewTypeParam(types.NewTypeName(token.NoPos, elementPkg, "T", nil), types.Universe.Lookup("any").Type()),
+		types.NewTypeParam(types.NewTypeName(token.NoPos, elementPkg, "C", nil), types.Universe.Lookup("any").Type()),
+	}
+	origin := types.NewNamed(types.NewTypeName(token.NoPos, elementPkg, "element", nil), types.NewStruct(nil, nil), nil)
+	origin.SetTypeParams(tparams)
+	inst, err := types.Instantiate(nil, origin, []types.Type{t, collection}, false)
+	if err != nil {
+		panic(err)
+	}
+	return inst
+}
+
+// stagePkg is the package of the synthetic generic types that key the
+// intermediate values of a decorated type.
+var stagePkg = types.NewPackage("", "")
+
+// stageKey returns a new type that keys the value of type t before it is
+// passed to decorator d. Every call returns a type that is not identical to
+// any other type.
+func stageKey(t types.Type, d *Decorator) types.Type {
+	tparam := types.NewTypeParam(types.NewTypeName(token.NoPos, stagePkg, "T", nil), types.Universe.Lookup("any").Type())
+	name := d.Provider.Pkg.Name() + "." + d.Provider.Name
+	origin := types.NewNamed(types.NewTypeName(token.NoPos, stagePkg, name, nil), types.NewStruct(nil, nil), nil)
+	origin.SetTypeParams([]*types.TypeParam{tparam})
+	inst, err := types.Instantiate(nil, origin, []types.Type{t}, false)
+	if err != nil {
+		panic(err)
+	}
+	return inst
+}
+
+// unqualify returns the type and the name that t was created with by
+// qualify. If t was created by elementKey or stageKey, unqualify returns
+// the type of the contributed or decorated value and the empty string.
+// Otherwise, it returns t and the empty string.
+func unqualify(t types.Type) (types.Type, string) {
+	n, ok := t.(*types.Named)
+	if !ok {
+		return t, ""
+	}
+	switch n.Obj().Pkg() {
+	case qualifierPkg:
+		return n.TypeArgs().At(0), n.Obj().Name()
+	case elementPkg, stagePkg:
+		return n.TypeArgs().At(0), ""
+	default:
+		return t, ""
+	}
+}
+
+// TypeString returns the string form of a type provided by a provider set.
+// It is the same as types.TypeString with a nil qualifier, except that a
+// type provided under a name given to wire.Named is written as
+// `T named "name"` and a value contributed to a slice or map by wire.SliceOf
+// or wire.MapOf is written as `T (element of C)`. The value of a decorated
+// type before it is passed to a decorator is written as
+// `T (before decorator pkg.Decorator)`.
+func TypeString(t types.Type) string {
+	return TypeStringQualified(t, nil)
+}
+
+// TypeStringQualified is like TypeString, but writes package-level objects
+// with qf, like types.TypeString.
+func TypeStringQualified(t types.Type, qf types.Qualifier) string {
+	if n, ok := t.(*types.Named); ok {
+		switch n.Obj().Pkg() {
+		case elementPkg:
+			return fmt.Sprintf("%s (element of %s)", TypeStringQualified(n.TypeArgs().At(0), qf), types.TypeString(n.TypeArgs().At(1), qf))
+		case stagePkg:
+			return fmt.Sprintf("%s (before decorator %s)", TypeStringQualified(n.TypeArgs().At(0), qf), n.Obj().Name())
+		}
+	}
+	if u, name := unqualify(t); name != "" {
+		return fmt.Sprintf("%s named %q", types.TypeString(u, qf), name)
+	}
+	return types.TypeString(t, qf)
+}
+
 // ProvidedType represents a type provided from a source. The source
-// can be a *Provider (a provider function), a *Value (wire.Value), or an
-// *InjectorArgs (arguments to the injector function). The zero value has
-// none of the above, and returns true for IsNil.
+// can be a *Provider (a provider function), a *Value (wire.Value), an
+// *InjectorArgs (arguments to the injector function), a *Field
+// (wire.FieldsOf), a *Multibinding (wire.SliceOf or wire.MapOf) or an
+// *Optional (wire.Optional). The zero value has none of the above, and
+// returns true for IsNil. The provided value may be wrapped by decorators.
 type ProvidedType struct {
 	// t is the provided concrete type.
 	t types.Type
@@ -1162,11 +2532,17 @@ type ProvidedType struct {
 	v *Value
 	a *InjectorArg
 	f *Field
+	m *Multibinding
+	o *Optional
+
+	// d are the decorators that wrap the value, in the order they are
+	// applied.
+	d []decoration
 }
 
 // IsNil reports whether pt is the zero value.
 func (pt ProvidedType) IsNil() bool {
-	return pt.p == nil && pt.v == nil && pt.a == nil && pt.f == nil
+	return pt.p == nil && pt.v == nil && pt.a == nil && pt.f == nil && pt.m == nil && pt.o == nil
 }
 
 // Type returns the output type.
@@ -1176,6 +2552,8 @@ func (pt ProvidedType) IsNil() bool {
 //     whose element type is the struct type.
 //   - For a value, this is the type of the expression.
 //   - For an argument, this is the type of the argument.
+//   - For a multibinding, this is the slice or map type.
+//   - For an optional binding, this is the type that may be left unprovided.
 func (pt ProvidedType) Type() types.Type {
 	return pt.t
 }
@@ -1200,6 +2578,26 @@ func (pt ProvidedType) IsField() bool {
 	return pt.f != nil
 }
 
+// IsMultibinding reports whether pt points to a Multibinding.
+func (pt ProvidedType) IsMultibinding() bool {
+	return pt.m != nil
+}
+
+// IsOptional reports whether pt points to an Optional.
+func (pt ProvidedType) IsOptional() bool {
+	return pt.o != nil
+}
+
+// Decorators returns the decorators that wrap the provided value, in the
+// order they are applied.
+func (pt ProvidedType) Decorators() []*Decorator {
+	var ds []*Decorator
+	for _, d := range pt.d {
+		ds = append(ds, d.d)
+	}
+	return ds
+}
+
 // Provider returns pt as a Provider pointer. It panics if pt does not point
 // to a Provider.
 func (pt ProvidedType) Provider() *Provider {
@@ -1236,6 +2634,24 @@ func (pt ProvidedType) Field() *Field {
 	return pt.f
 }
 
+// Multibinding returns pt as a Multibinding pointer. It panics if pt does
+// not point to a Multibinding.
+func (pt ProvidedType) Multibinding() *Multibinding {
+	if pt.m == nil {
+		panic("ProvidedType does not hold a Multibinding")
+	}
+	return pt.m
+}
+
+// Optional returns pt as an Optional pointer. It panics if pt does not point
+// to an Optional.
+func (pt ProvidedType) Optional() *Optional {
+	if pt.o == nil {
+		panic("ProvidedType does not hold an Optional")
+	}
+	return pt.o
+}
+
 // bindShouldUsePointer loads the wire package the user is importing from their
 // injector. The call is a wire marker function call.
 func bindShouldUsePointer(info *types.Info, call *ast.CallExpr) bool {
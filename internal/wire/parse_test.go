@@ -16,22 +16,32 @@ package wire
 
 import (
 	"go/ast"
+	"go/constant"
+	"go/parser"
 	"go/token"
 	"go/types"
 	"testing"
+
+	"golang.org/x/tools/go/types/typeutil"
 )
 
 func TestFuncOutput(t *testing.T) {
 	intT := types.Typ[types.Int]
 	stringT := types.Typ[types.String]
+	contextPkg := types.NewPackage("context", "context")
+	contextT := types.NewNamed(types.NewTypeName(token.NoPos, contextPkg, "Context", nil), types.NewInterfaceType(nil, nil), nil)
+	errCleanupT := types.NewSignatureType(nil, nil, nil, nil, vars(testErrorType), false)
+	ctxCleanupT := types.NewSignatureType(nil, nil, nil, vars(contextT), vars(testErrorType), false)
 
 	tests := []struct {
-		name        string
-		sig         *types.Signature
-		wantOut     types.Type
-		wantCleanup bool
-		wantErr     bool
-		wantError   string
+		name           string
+		sig            *types.Signature
+		wantOut        types.Type
+		wantCleanup    bool
+		wantCleanupErr bool
+		wantCleanupCtx bool
+		wantErr        bool
+		wantError      string
 	}{
 		{
 			name:    "single return",
@@ -57,6 +67,27 @@ func TestFuncOutput(t *testing.T) {
 			wantCleanup: true,
 			wantErr:     true,
 		},
+		{
+			name:           "return with cleanup returning error",
+			sig:            makeSig(nil, []types.Type{intT, errCleanupT}),
+			wantOut:        intT,
+			wantCleanup:    true,
+			wantCleanupErr: true,
+		},
+		{
+			name:           "return with context cleanup and error",
+			sig:            makeSig(nil, []types.Type{intT, ctxCleanupT, testErrorType}),
+			wantOut:        intT,
+			wantCleanup:    true,
+			wantCleanupErr: true,
+			wantCleanupCtx: true,
+			wantErr:        true,
+		},
+		{
+			name:      "cleanup with string parameter",
+			sig:       makeSig(nil, []types.Type{intT, types.NewSignatureType(nil, nil, nil, vars(stringT), vars(testErrorType), false)}),
+			wantError: "second return type",
+		},
 		{
 			name:      "no return values",
 			sig:       makeSig(nil, nil),
@@ -97,6 +128,12 @@ func TestFuncOutput(t *testing.T) {
 			if out.cleanup != test.wantCleanup {
 				t.Errorf("cleanup = %v; want %v", out.cleanup, test.wantCleanup)
 			}
+			if out.cleanupErr != test.wantCleanupErr {
+				t.Errorf("cleanupErr = %v; want %v", out.cleanupErr, test.wantCleanupErr)
+			}
+			if out.cleanupCtx != test.wantCleanupCtx {
+				t.Errorf("cleanupCtx = %v; want %v", out.cleanupCtx, test.wantCleanupCtx)
+			}
 			if out.err != test.wantErr {
 				t.Errorf("err = %v; want %v", out.err, test.wantErr)
 			}
@@ -110,6 +147,9 @@ func TestProcessFuncProvider(t *testing.T) {
 	intT := types.Typ[types.Int]
 	stringT := types.Typ[types.String]
 	boolT := types.Typ[types.Bool]
+	typeParam := types.NewTypeParam(types.NewTypeName(token.NoPos, pkg, "T", nil), types.NewInterfaceType(nil, nil))
+	genericSig := types.NewSignatureType(nil, nil, []*types.TypeParam{typeParam}, nil,
+		types.NewTuple(types.NewVar(token.NoPos, pkg, "", typeParam)), false)
 
 	tests := []struct {
 		name     string
@@ -143,6 +183,11 @@ func TestProcessFuncProvider(t *testing.T) {
 			wantArgs: 1,
 			wantOut:  stringT,
 		},
+		{
+			name:    "uninstantiated generic provider",
+			fn:      makeFunc(pkg, "NewGeneric", genericSig),
+			wantErr: "generic provider NewGeneric must be instantiated",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -306,6 +351,111 @@ func TestIsPrevented(t *testing.T) {
 	}
 }
 
+func TestFieldQualifier(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{"", ""},
+		{`wire:"-"`, ""},
+		{`wire:"name=replica"`, "replica"},
+		{`json:"db" wire:"name=replica"`, "replica"},
+	}
+	for _, test := range tests {
+		t.Run(test.tag, func(t *testing.T) {
+			if got := fieldQualifier(test.tag); got != test.want {
+				t.Errorf("fieldQualifier(%q) = %q; want %q", test.tag, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFieldOptional(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want bool
+	}{
+		{``, false},
+		{`wire:"optional"`, true},
+		{`wire:"name=replica,optional"`, true},
+		{`wire:"name=optional"`, false},
+		{`json:"optional"`, false},
+	}
+	for _, test := range tests {
+		if got := fieldOptional(test.tag); got != test.want {
+			t.Errorf("fieldOptional(%q) = %t; want %t", test.tag, got, test.want)
+		}
+	}
+}
+
+func TestConstantAssignable(t *testing.T) {
+	pkg := testPkg("example.com/test", "test")
+	timeout := types.NewNamed(types.NewTypeName(token.NoPos, pkg, "Timeout", nil), types.Typ[types.Int64], nil)
+	tests := []struct {
+		name string
+		tv   types.TypeAndValue
+		t    types.Type
+		want bool
+	}{
+		{"untyped int to named int64", types.TypeAndValue{Type: types.Typ[types.Int], Value: constant.MakeInt64(30)}, timeout, true},
+		{"untyped int to float", types.TypeAndValue{Type: types.Typ[types.Int], Value: constant.MakeInt64(1)}, types.Typ[types.Float64], true},
+		{"untyped int to string", types.TypeAndValue{Type: types.Typ[types.Int], Value: constant.MakeInt64(1)}, types.Typ[types.String], false},
+		{"typed int64 constant", types.TypeAndValue{Type: types.Typ[types.Int64], Value: constant.MakeInt64(1)}, timeout, false},
+		{"not a constant", types.TypeAndValue{Type: types.Typ[types.Int]}, timeout, false},
+	}
+	for _, test := range tests {
+		if got := constantAssignable(test.tv, test.t); got != test.want {
+			t.Errorf("%s: constantAssignable = %t; want %t", test.name, got, test.want)
+		}
+	}
+}
+
+func TestQualify(t *testing.T) {
+	intT := types.Typ[types.Int]
+	q := newQualifiers()
+
+	if got := mustQualify(t, q, intT, ""); got != intT {
+		t.Errorf("qualify(int, \"\") = %v; want int", got)
+	}
+	a, b := mustQualify(t, q, intT, "a"), mustQualify(t, q, intT, "a")
+	if !types.Identical(a, b) {
+		t.Error("types qualified with the same name are not identical")
+	}
+	if hasher := typeutil.MakeHasher(); hasher.Hash(a) != hasher.Hash(b) {
+		t.Error("types qualified with the same name hash differently")
+	}
+	if types.Identical(a, intT) || types.Identical(a, mustQualify(t, q, intT, "b")) {
+		t.Error("qualified type is identical to a differently qualified type")
+	}
+	if u, name := unqualify(a); u != intT || name != "a" {
+		t.Errorf("unqualify(qualify(int, \"a\")) = %v, %q; want int, \"a\"", u, name)
+	}
+	if u, name := unqualify(intT); u != intT || name != "" {
+		t.Errorf("unqualify(int) = %v, %q; want int, \"\"", u, name)
+	}
+	if got, want := TypeString(a), `int named "a"`; got != want {
+		t.Errorf("TypeString = %q; want %q", got, want)
+	}
+
+	// Each load qualifies types with its own generic types.
+	other := mustQualify(t, newQualifiers(), intT, "a")
+	if types.Identical(a, other) {
+		t.Error("types qualified by different loads are identical")
+	}
+	if u, name := unqualify(other); u != intT || name != "a" {
+		t.Errorf("unqualify of a type qualified by another load = %v, %q; want int, \"a\"", u, name)
+	}
+}
+
+func mustQualify(t *testing.T, q *qualifiers, typ types.Type, name string) types.Type {
+	t.Helper()
+	qt, err := q.qualify(typ, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return qt
+}
+
 func TestAllFields(t *testing.T) {
 	t.Run("wildcard", func(t *testing.T) {
 		call := &ast.CallExpr{
@@ -345,6 +495,44 @@ func TestAllFields(t *testing.T) {
 	})
 }
 
+func TestFuncInstance(t *testing.T) {
+	const src = `package p
+
+func New[K comparable, V any]() map[K]V { return nil }
+
+func NewInt() int { return 0 }
+
+var (
+	a = New[string, bool]
+	b = NewInt
+)
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "p.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{
+		Uses:      make(map[*ast.Ident]types.Object),
+		Instances: make(map[*ast.Ident]types.Instance),
+	}
+	if _, err := new(types.Config).Check("p", fset, []*ast.File{f}, info); err != nil {
+		t.Fatal(err)
+	}
+	values := f.Decls[len(f.Decls)-1].(*ast.GenDecl).Specs
+
+	fn, inst := funcInstance(info, values[0].(*ast.ValueSpec).Values[0])
+	if fn == nil || fn.Name() != "New" {
+		t.Fatalf("funcInstance(New[string, bool]) = %v; want New", fn)
+	}
+	if got := typeListSlice(inst.TypeArgs); len(got) != 2 || got[0] != types.Typ[types.String] || got[1] != types.Typ[types.Bool] {
+		t.Errorf("type arguments = %v; want [string bool]", got)
+	}
+	if fn, _ := funcInstance(info, values[1].(*ast.ValueSpec).Values[0]); fn != nil {
+		t.Errorf("funcInstance(NewInt) = %v; want nil", fn)
+	}
+}
+
 func TestQualifiedIdentObject(t *testing.T) {
 	pkg := testPkg("example.com/test", "test")
 	obj := types.NewVar(token.NoPos, pkg, "Foo", types.Typ[types.Int])
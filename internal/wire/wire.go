@@ -28,9 +28,11 @@ import (
 	"go/types"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode"
 	"unicode/utf8"
 
@@ -66,6 +68,26 @@ type GenerateOptions struct {
 	Header           []byte
 	PrefixOutputFile string
 	Tags             string
+	// WrapErrors makes generated injectors wrap the errors returned by
+	// provider functions with the name and package of the provider, as in
+	// "wire: NewDB (example.com/db): connection refused". The wrapped
+	// errors still match the original ones with errors.Is and errors.As.
+	WrapErrors bool
+	// Overlay maps absolute file paths to contents to use instead of the
+	// contents of the files on disk, as described by
+	// packages.Config.Overlay. It may be nil.
+	Overlay map[string][]byte
+	// Jobs is the maximum number of packages to generate concurrently. If it
+	// is zero or negative, it is runtime.GOMAXPROCS(0). The results and
+	// their errors do not depend on it.
+	Jobs int
+	// CacheDir is the directory of the cache of generated code. If it is
+	// not empty, Generate returns the code it generated before for the
+	// packages whose files and dependencies did not change since, without
+	// loading and solving them again. Entries that were not used in five
+	// days are removed. DefaultCacheDir returns the directory the wire
+	// command uses.
+	CacheDir string
 }
 
 // Generate performs dependency injection for the packages that match the given
@@ -84,41 +106,83 @@ func Generate(ctx context.Context, wd string, env []string, patterns []string, o
 	if opts == nil {
 		opts = &GenerateOptions{}
 	}
-	pkgs, errs := load(ctx, wd, env, opts.Tags, patterns)
+	if opts.CacheDir != "" {
+		return generateCached(ctx, wd, env, patterns, opts)
+	}
+	pkgs, errs := load(ctx, wd, env, opts.Tags, patterns, opts.Overlay)
 	if len(errs) > 0 {
 		return nil, errs
 	}
+	return generatePackages(pkgs, opts), nil
+}
+
+// generatePackages generates the injectors for pkgs, running up to
+// opts.Jobs packages concurrently.
+func generatePackages(pkgs []*packages.Package, opts *GenerateOptions) []GenerateResult {
 	generated := make([]GenerateResult, len(pkgs))
-	for i, pkg := range pkgs {
-		generated[i].PkgPath = pkg.PkgPath
-		outDir, err := detectOutputDir(pkg.GoFiles)
-		if err != nil {
-			generated[i].Errs = append(generated[i].Errs, err)
-			continue
-		}
-		generated[i].OutputPath = filepath.Join(outDir, opts.PrefixOutputFile+"wire_gen.go")
-		g := newGen(pkg)
-		injectorFiles, errs := generateInjectors(g, pkg)
-		if len(errs) > 0 {
-			generated[i].Errs = errs
-			continue
-		}
-		copyNonInjectorDecls(g, injectorFiles, pkg.TypesInfo)
-		goSrc := g.frame(opts.Tags)
-		if len(opts.Header) > 0 {
-			goSrc = append(opts.Header, goSrc...)
-		}
-		fmtSrc, err := format.Source(goSrc)
-		if err != nil {
-			// This is likely a bug from a poorly generated source file.
-			// Add an error but also the unformatted source.
-			generated[i].Errs = append(generated[i].Errs, err)
-		} else {
-			goSrc = fmtSrc
-		}
-		generated[i].Content = goSrc
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.GOMAXPROCS(0)
+	}
+	if jobs > len(pkgs) {
+		jobs = len(pkgs)
+	}
+	// Each package is generated with its own gen and objectCache, and its
+	// result is stored at its index, so the results are in the same order
+	// however the packages are scheduled.
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for j := 0; j < jobs; j++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				generated[i] = generatePackage(pkgs[i], opts)
+			}
+		}()
 	}
-	return generated, nil
+	for i := range pkgs {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return generated
+}
+
+// generatePackage generates the injectors for a package. It may be called
+// concurrently for different packages.
+func generatePackage(pkg *packages.Package, opts *GenerateOptions) GenerateResult {
+	res := GenerateResult{PkgPath: pkg.PkgPath}
+	outDir, err := detectOutputDir(pkg.GoFiles)
+	if err != nil {
+		res.Errs = append(res.Errs, err)
+		return res
+	}
+	res.OutputPath = filepath.Join(outDir, opts.PrefixOutputFile+"wire_gen.go")
+	g := newGen(pkg)
+	g.wrapErrors = opts.WrapErrors
+	injectorFiles, errs := generateInjectors(g, pkg)
+	if len(errs) > 0 {
+		res.Errs = errs
+		return res
+	}
+	copyNonInjectorDecls(g, injectorFiles, pkg.TypesInfo)
+	goSrc := g.frame(opts)
+	if len(opts.Header) > 0 {
+		// Copy the header, since appending to it could write to the same
+		// array for several packages.
+		goSrc = append(append([]byte(nil), opts.Header...), goSrc...)
+	}
+	fmtSrc, err := format.Source(goSrc)
+	if err != nil {
+		// This is likely a bug from a poorly generated source file.
+		// Add an error but also the unformatted source.
+		res.Errs = append(res.Errs, err)
+	} else {
+		goSrc = fmtSrc
+	}
+	res.Content = goSrc
+	return res
 }
 
 func detectOutputDir(paths []string) (string, error) {
@@ -246,6 +310,36 @@ type gen struct {
 	imports     map[string]importInfo
 	anonImports map[string]bool
 	values      map[ast.Expr]string
+	components  []*component
+	// cleanupErrors is the name of the generated type that collects the
+	// errors of cleanup functions, or empty if no injector needs it yet.
+	cleanupErrors string
+	// wrapErrors is true if errors from provider functions are wrapped
+	// with the name of the provider.
+	wrapErrors bool
+}
+
+// A component is a struct type generated to implement a wire.Component
+// interface.
+type component struct {
+	// iface is the component interface.
+	iface *types.Named
+	// name is the name of the generated struct type.
+	name string
+	// fields are the names of the struct fields, in the order of the
+	// interface's methods.
+	fields []string
+}
+
+// component returns the generated implementation of the component
+// interface iface, or nil if there is none yet.
+func (g *gen) component(iface types.Type) *component {
+	for _, c := range g.components {
+		if types.Identical(c.iface, iface) {
+			return c
+		}
+	}
+	return nil
 }
 
 func newGen(pkg *packages.Package) *gen {
@@ -258,16 +352,25 @@ func newGen(pkg *packages.Package) *gen {
 }
 
 // frame bakes the built up source body into an unformatted Go source file.
-func (g *gen) frame(tags string) []byte {
+func (g *gen) frame(opts *GenerateOptions) []byte {
 	if g.buf.Len() == 0 {
 		return nil
 	}
 	var buf bytes.Buffer
-	if len(tags) > 0 {
-		tags = fmt.Sprintf(" gen -tags \"%s\"", tags)
+	// The go:generate directive repeats the options that change the
+	// generated code.
+	var args string
+	if len(opts.Tags) > 0 {
+		args += fmt.Sprintf(" -tags \"%s\"", opts.Tags)
+	}
+	if opts.WrapErrors {
+		args += " -wrap_errors"
+	}
+	if len(args) > 0 {
+		args = " gen" + args
 	}
 	buf.WriteString("// Code generated by Wire. DO NOT EDIT.\n\n")
-	buf.WriteString("//go:generate go run -mod=mod github.com/almondoo/wire/cmd/wire" + tags + "\n")
+	buf.WriteString("//go:generate go run -mod=mod github.com/almondoo/wire/cmd/wire" + args + "\n")
 	buf.WriteString("//+build !wireinject\n\n")
 	buf.WriteString("package ")
 	buf.WriteString(g.pkg.Name)
@@ -328,19 +431,48 @@ func (g *gen) inject(pos token.Pos, name string, sig *types.Signature, set *Prov
 		name     string
 		expr     ast.Expr
 		typeInfo *types.Info
+		// typ is the declared type of the variable, or nil if the variable
+		// has the type of expr.
+		typ types.Type
 	}
 	var pendingVars []pendingVar
 	ec := new(errorCollector)
+	if set.options.checkContext {
+		hasContext := false
+		for i := 0; i < params.Len(); i++ {
+			hasContext = hasContext || isContextType(params.At(i).Type())
+		}
+		if !hasContext || !injectSig.err {
+			ec.add(notePosition(
+				g.pkg.Fset.Position(pos),
+				fmt.Errorf("inject %s: wire.CheckContext requires the injector to take a context.Context and return an error", name)))
+		}
+	}
 	for i := range calls {
 		c := &calls[i]
 		if c.hasCleanup && !injectSig.cleanup {
-			ts := types.TypeString(c.out, nil)
+			ts := TypeString(c.out)
 			ec.add(notePosition(
 				g.pkg.Fset.Position(pos),
 				fmt.Errorf("inject %s: provider for %s returns cleanup but injection does not return cleanup function", name, ts)))
+		} else if c.closer && !injectSig.cleanupErr {
+			ts := TypeString(c.out)
+			ec.add(notePosition(
+				g.pkg.Fset.Position(pos),
+				fmt.Errorf("inject %s: %s is closed by wire.Closer but injection cleanup function does not return error", name, ts)))
+		} else if c.cleanupErr && !injectSig.cleanupErr {
+			ts := TypeString(c.out)
+			ec.add(notePosition(
+				g.pkg.Fset.Position(pos),
+				fmt.Errorf("inject %s: provider for %s returns cleanup that can fail but injection cleanup function does not return error", name, ts)))
+		} else if c.cleanupCtx && !injectSig.cleanupCtx {
+			ts := TypeString(c.out)
+			ec.add(notePosition(
+				g.pkg.Fset.Position(pos),
+				fmt.Errorf("inject %s: provider for %s returns cleanup that takes a context.Context but injection cleanup function does not", name, ts)))
 		}
 		if c.hasErr && !injectSig.err {
-			ts := types.TypeString(c.out, nil)
+			ts := TypeString(c.out)
 			ec.add(notePosition(
 				g.pkg.Fset.Position(pos),
 				fmt.Errorf("inject %s: provider for %s returns error but injection not allowed to fail", name, ts)))
@@ -348,13 +480,17 @@ func (g *gen) inject(pos token.Pos, name string, sig *types.Signature, set *Prov
 		if c.kind == valueExpr {
 			if err := accessibleFrom(c.valueTypeInfo, c.valueExpr, g.pkg.PkgPath); err != nil {
 				// TODO(light): Display line number of value expression.
-				ts := types.TypeString(c.out, nil)
+				ts := TypeString(c.out)
 				ec.add(notePosition(
 					g.pkg.Fset.Position(pos),
 					fmt.Errorf("inject %s: value %s can't be used: %v", name, ts, err)))
 			}
 			if g.values[c.valueExpr] == "" {
 				t := c.valueTypeInfo.TypeOf(c.valueExpr)
+				var typ types.Type
+				if out, _ := unqualify(c.out); c.declareOut && !types.Identical(t, out) {
+					typ, t = out, out
+				}
 
 				name := typeVariableName(t, "", func(name string) string { return "_wire" + export(name) + "Value" }, g.nameInFileScope)
 				g.values[c.valueExpr] = name
@@ -362,14 +498,52 @@ func (g *gen) inject(pos token.Pos, name string, sig *types.Signature, set *Prov
 					name:     name,
 					expr:     c.valueExpr,
 					typeInfo: c.valueTypeInfo,
+					typ:      typ,
 				})
 			}
 		}
 	}
+	// An injector that returns a component interface needs a struct type
+	// implementing it, which is shared by the injectors of the package.
+	var newComponent *component
+	if n := len(calls); n > 0 && calls[n-1].kind == componentLit && g.component(calls[n-1].out) == nil {
+		iface := calls[n-1].out.(*types.Named)
+		methods := iface.Underlying().(*types.Interface)
+		newComponent = &component{
+			iface: iface,
+			name:  typeVariableName(iface, "", func(name string) string { return "_wire" + export(name) + "Component" }, g.nameInFileScope),
+		}
+		for i := 0; i < methods.NumMethods(); i++ {
+			m := methods.Method(i)
+			if !m.Exported() && m.Pkg() != g.pkg.Types {
+				ec.add(notePosition(
+					g.pkg.Fset.Position(pos),
+					fmt.Errorf("inject %s: component %s has unexported method %s from another package", name, TypeString(iface), m.Name())))
+				continue
+			}
+			newComponent.fields = append(newComponent.fields, disambiguate(unexport(m.Name()), func(n string) bool {
+				if obj, _, _ := types.LookupFieldOrMethod(iface, true, g.pkg.Types, n); obj != nil {
+					return true
+				}
+				for _, f := range newComponent.fields {
+					if f == n {
+						return true
+					}
+				}
+				return false
+			}))
+		}
+		if len(ec.errors) == 0 {
+			g.components = append(g.components, newComponent)
+		}
+	}
 	if len(ec.errors) > 0 {
 		return ec.errors
 	}
 
+	// The type that collects the errors of cleanup functions is shared by
+	// the injectors of the file and emitted after the first that uses it.
+	hadCleanupErrors := g.cleanupErrors != ""
 	// Perform one pass to collect all imports, followed by the real pass.
 	injectPass(name, sig, calls, set, doc, &injectorGen{
 		g:       g,
@@ -384,15 +558,80 @@ func (g *gen) inject(pos token.Pos, name string, sig *types.Signature, set *Prov
 	if len(pendingVars) > 0 {
 		g.p("var (\n")
 		for _, pv := range pendingVars {
-			g.p("\t%s = ", pv.name)
+			g.p("\t%s", pv.name)
+			if pv.typ != nil {
+				g.p(" %s", types.TypeString(pv.typ, g.qualifyPkg))
+			}
+			g.p(" = ")
 			g.writeAST(pv.typeInfo, pv.expr)
 			g.p("\n")
 		}
 		g.p(")\n\n")
 	}
+	if newComponent != nil {
+		g.componentType(newComponent)
+	}
+	if !hadCleanupErrors && g.cleanupErrors != "" {
+		g.cleanupErrorsType()
+	}
 	return nil
 }
 
+// componentType emits the struct type implementing a component interface,
+// along with its methods.
+func (g *gen) componentType(c *component) {
+	methods := c.iface.Underlying().(*types.Interface)
+	g.p("// %s implements %s.\n", c.name, types.TypeString(c.iface, g.qualifyPkg))
+	g.p("type %s struct {\n", c.name)
+	for i, f := range c.fields {
+		g.p("\t%s %s\n", f, types.TypeString(componentResult(methods.Method(i)), g.qualifyPkg))
+	}
+	g.p("}\n\n")
+	for i, f := range c.fields {
+		m := methods.Method(i)
+		g.p("func (c *%s) %s() %s {\n", c.name, m.Name(), types.TypeString(componentResult(m), g.qualifyPkg))
+		g.p("\treturn c.%s\n", f)
+		g.p("}\n\n")
+	}
+}
+
+// cleanupErrorsType emits the type that collects the errors of the cleanup
+// functions called by the cleanup function of an injector. It behaves like
+// the error returned by errors.Join, which is not used so that the
+// generated code builds with Go 1.19.
+func (g *gen) cleanupErrorsType() {
+	name := g.cleanupErrors
+	g.p("// %s is the error of the cleanup function of an injector when\n", name)
+	g.p("// several of the cleanup functions it calls fail.\n")
+	g.p("type %s []error\n\n", name)
+	g.p("// err returns nil if there are no errors, the error if there is one,\n")
+	g.p("// and errs otherwise.\n")
+	g.p("func (errs %s) err() error {\n", name)
+	g.p("\tswitch len(errs) {\n")
+	g.p("\tcase 0:\n")
+	g.p("\t\treturn nil\n")
+	g.p("\tcase 1:\n")
+	g.p("\t\treturn errs[0]\n")
+	g.p("\t}\n")
+	g.p("\treturn errs\n")
+	g.p("}\n\n")
+	g.p("func (errs %s) Error() string {\n", name)
+	g.p("\ts := errs[0].Error()\n")
+	g.p("\tfor _, err := range errs[1:] {\n")
+	g.p("\t\ts += \"\\n\" + err.Error()\n")
+	g.p("\t}\n")
+	g.p("\treturn s\n")
+	g.p("}\n\n")
+	g.p("func (errs %s) Unwrap() []error {\n", name)
+	g.p("\treturn errs\n")
+	g.p("}\n\n")
+}
+
+// componentResult returns the type returned by a method of a component.
+func componentResult(m *types.Func) types.Type {
+	return m.Type().(*types.Signature).Results().At(0).Type()
+}
+
 // rewritePkgRefs rewrites any package references in an AST into references for the
 // generated package.
 func (g *gen) rewritePkgRefs(info *types.Info, node ast.Node) ast.Node {
@@ -562,6 +801,14 @@ func (g *gen) nameInFileScope(name string) bool {
 			return true
 		}
 	}
+	for _, c := range g.components {
+		if c.name == name {
+			return true
+		}
+	}
+	if g.cleanupErrors == name {
+		return true
+	}
 	_, obj := g.pkg.Types.Scope().LookupParent(name, token.NoPos)
 	return obj != nil
 }
@@ -578,16 +825,41 @@ func (g *gen) p(format string, args ...interface{}) {
 type injectorGen struct {
 	g *gen
 
-	paramNames   []string
-	localNames   []string
-	cleanupNames []string
-	errVar       string
+	paramNames []string
+	localNames []string
+	cleanups   []cleanupVar
+	errVar     string
+	// ctxParam is the name of the injector's first context.Context
+	// argument, or the empty string if there is none.
+	ctxParam string
+	// checkContext is true if ctxParam is checked for cancellation before
+	// each provider function call.
+	checkContext bool
+	// otherNames are the names of other local variables, such as the
+	// errors of provider functions called concurrently.
+	otherNames []string
+	// hooks is the name of the wirehook.Hooks called around provider calls,
+	// or the empty string if the injector is not instrumented.
+	hooks string
+	// wgVar is the name of the sync.WaitGroup used to wait for provider
+	// functions called concurrently, or the empty string if it has not been
+	// declared yet.
+	wgVar string
 
 	// discard causes ig.p and ig.writeAST to no-op. Useful to run
 	// generation for side-effects like filling in g.imports.
 	discard bool
 }
 
+// A cleanupVar is a local variable holding a cleanup function.
+type cleanupVar struct {
+	name string
+	// errs is true if the cleanup function returns an error.
+	errs bool
+	// ctx is true if the cleanup function takes a context.Context.
+	ctx bool
+}
+
 // injectPass generates an injector given the output from analysis.
 // The sig passed in should be verified.
 func injectPass(name string, sig *types.Signature, calls []call, set *ProviderSet, doc *ast.CommentGroup, ig *injectorGen) {
@@ -597,6 +869,7 @@ func injectPass(name string, sig *types.Signature, calls []call, set *ProviderSe
 		// This should be checked by the caller already.
 		panic(err)
 	}
+	ig.checkContext = set.options.checkContext
 	if doc != nil {
 		for _, c := range doc.List {
 			ig.p("%s\n", c.Text)
@@ -615,6 +888,9 @@ func injectPass(name string, sig *types.Signature, calls []call, set *ProviderSe
 			a = disambiguate(a, ig.nameInInjector)
 		}
 		ig.paramNames = append(ig.paramNames, a)
+		if ig.ctxParam == "" && isContextType(pi.Type()) {
+			ig.ctxParam = a
+		}
 		if sig.Variadic() && i == params.Len()-1 {
 			// Keep the varargs signature instead of a slice for the last argument if the
 			// injector is variadic.
@@ -626,29 +902,25 @@ func injectPass(name string, sig *types.Signature, calls []call, set *ProviderSe
 	outTypeString := types.TypeString(injectSig.out, ig.g.qualifyPkg)
 	switch {
 	case injectSig.cleanup && injectSig.err:
-		ig.p(") (%s, func(), error) {\n", outTypeString)
+		ig.p(") (%s, %s, error) {\n", outTypeString, ig.cleanupType(injectSig))
 	case injectSig.cleanup:
-		ig.p(") (%s, func()) {\n", outTypeString)
+		ig.p(") (%s, %s) {\n", outTypeString, ig.cleanupType(injectSig))
 	case injectSig.err:
 		ig.p(") (%s, error) {\n", outTypeString)
 	default:
 		ig.p(") %s {\n", outTypeString)
 	}
-	for i := range calls {
-		c := &calls[i]
-		lname := typeVariableName(c.out, "v", unexport, ig.nameInInjector)
-		ig.localNames = append(ig.localNames, lname)
-		switch c.kind {
-		case structProvider:
-			ig.structProviderCall(lname, c)
-		case funcProviderCall:
-			ig.funcProviderCall(lname, c, injectSig)
-		case valueExpr:
-			ig.valueExpr(lname, c)
-		case selectorExpr:
-			ig.fieldExpr(lname, c)
-		default:
-			panic("unknown kind")
+	if set.options.instrument {
+		ig.instrument(params, calls)
+	}
+	if set.options.parallel {
+		ig.parallelCalls(calls, injectSig)
+	} else {
+		for i := range calls {
+			c := &calls[i]
+			lname := typeVariableName(c.out, "v", unexport, ig.nameInInjector)
+			ig.localNames = append(ig.localNames, lname)
+			ig.call(lname, c, injectSig)
 		}
 	}
 	if len(calls) == 0 {
@@ -657,11 +929,7 @@ func injectPass(name string, sig *types.Signature, calls []call, set *ProviderSe
 		ig.p("\treturn %s", ig.localNames[len(calls)-1])
 	}
 	if injectSig.cleanup {
-		ig.p(", func() {\n")
-		for i := len(ig.cleanupNames) - 1; i >= 0; i-- {
-			ig.p("\t\t%s()\n", ig.cleanupNames[i])
-		}
-		ig.p("\t}")
+		ig.cleanupFunc(injectSig)
 	}
 	if injectSig.err {
 		ig.p(", nil")
@@ -669,19 +937,113 @@ func injectPass(name string, sig *types.Signature, calls []call, set *ProviderSe
 	ig.p("\n}\n\n")
 }
 
-func (ig *injectorGen) funcProviderCall(lname string, c *call, injectSig outputSignature) {
-	ig.p("\t%s", lname)
-	prevCleanup := len(ig.cleanupNames)
-	if c.hasCleanup {
-		cname := disambiguate("cleanup", ig.nameInInjector)
-		ig.cleanupNames = append(ig.cleanupNames, cname)
-		ig.p(", %s", cname)
+// instrument sets up the hooks called around provider calls, looking them
+// up if the injector does not take them as an argument.
+func (ig *injectorGen) instrument(params *types.Tuple, calls []call) {
+	for i, name := range ig.paramNames {
+		if isWireHooksType(params.At(i).Type()) {
+			ig.hooks = name
+			return
+		}
 	}
-	if c.hasErr {
-		ig.p(", %s", ig.errVar)
+	for i := range calls {
+		if k := calls[i].kind; k == funcProviderCall || k == structProvider {
+			ig.hooks = disambiguate("hooks", ig.nameInInjector)
+			ig.otherNames = append(ig.otherNames, ig.hooks)
+			ig.p("\t%s := %s()\n", ig.hooks, ig.g.qualifiedID("wirehook", wireHookPath, "Default"))
+			return
+		}
 	}
-	ig.p(" := ")
-	ig.p("%s(", ig.g.qualifiedID(c.pkg.Name(), c.pkg.Path(), c.name))
+}
+
+// hookStart emits the call to the Start hook for c, if the injector is
+// instrumented. indent is the indentation of the statement.
+func (ig *injectorGen) hookStart(c *call, indent string) {
+	if ig.hooks == "" {
+		return
+	}
+	ig.p("%s%s.Start(%s)\n", indent, ig.hooks, ig.hookProvider(c))
+}
+
+// hookEnd emits the call to the End hook for c with the error expression
+// err, if the injector is instrumented. indent is the indentation of the
+// statement.
+func (ig *injectorGen) hookEnd(c *call, err string, indent string) {
+	if ig.hooks == "" {
+		return
+	}
+	ig.p("%s%s.End(%s, %s)\n", indent, ig.hooks, ig.hookProvider(c), err)
+}
+
+// hookProvider returns the wirehook.Provider literal that identifies the
+// provider of c.
+func (ig *injectorGen) hookProvider(c *call) string {
+	return fmt.Sprintf("%s{Name: %q, PkgPath: %q}", ig.g.qualifiedID("wirehook", wireHookPath, "Provider"), c.name, c.pkg.Path())
+}
+
+// errorReturn emits the statements that return the error expression err
+// from an injector after calling the first n cleanup functions in reverse
+// order. ctxDone reports whether the injector returns because its context
+// is done.
+func (ig *injectorGen) errorReturn(injectSig outputSignature, n int, ctxDone bool, err string) {
+	// Errors from cleanup functions are dropped in favor of err.
+	for i := n - 1; i >= 0; i-- {
+		ig.p("\t\t%s\n", ig.cleanupStmt(ig.cleanups[i], ctxDone))
+	}
+	ig.returnErr(injectSig, err)
+}
+
+// checkContextErr emits the statements that return the error of the injector's
+// context if it is done, after calling all cleanup functions so far.
+func (ig *injectorGen) checkContextErr(injectSig outputSignature) {
+	ig.p("\tif %s := %s.Err(); %s != nil {\n", ig.errVar, ig.ctxParam, ig.errVar)
+	ig.errorReturn(injectSig, len(ig.cleanups), true, ig.errVar)
+	ig.p("\t}\n")
+}
+
+// providerErr returns the expression for the error in errVar returned by
+// the provider function of c, wrapped with the name of the provider if
+// errors are wrapped.
+func (ig *injectorGen) providerErr(c *call, errVar string) string {
+	if !ig.g.wrapErrors {
+		return errVar
+	}
+	// The package path may contain a percent sign.
+	format := strings.ReplaceAll("wire: "+c.name+" ("+c.pkg.Path()+")", "%", "%%") + ": %w"
+	return fmt.Sprintf("%s(%s, %s)", ig.g.qualifiedID("fmt", "fmt", "Errorf"), strconv.Quote(format), errVar)
+}
+
+// cleanupStmt returns the statement that calls a cleanup function because
+// the injector failed, dropping any error it returns. ctxDone reports
+// whether the injector failed because its context is done, in which case a
+// cleanup function that takes a context receives context.Background()
+// instead.
+func (ig *injectorGen) cleanupStmt(c cleanupVar, ctxDone bool) string {
+	switch {
+	case c.ctx && ctxDone:
+		return "_ = " + c.name + "(" + ig.g.qualifiedID("context", "context", "Background") + "())"
+	case c.ctx:
+		return "_ = " + c.name + "(" + ig.errorPathContext() + ")"
+	case c.errs:
+		return "_ = " + c.name + "()"
+	default:
+		return c.name + "()"
+	}
+}
+
+// returnErr emits the statement that returns the error in errVar from the
+// injector.
+func (ig *injectorGen) returnErr(injectSig outputSignature, errVar string) {
+	ig.p("\t\treturn %s", zeroValue(injectSig.out, ig.g.qualifyPkg))
+	if injectSig.cleanup {
+		ig.p(", nil")
+	}
+	ig.p(", %s\n", errVar)
+}
+
+// callExpr emits the call expression of a provider function call.
+func (ig *injectorGen) callExpr(c *call) {
+	ig.p("%s%s(", ig.g.qualifiedID(c.pkg.Name(), c.pkg.Path(), c.name), ig.typeArgs(c.typeArgs))
 	for i, a := range c.args {
 		if i > 0 {
 			ig.p(", ")
@@ -695,29 +1057,346 @@ func (ig *injectorGen) funcProviderCall(lname string, c *call, injectSig outputS
 	if c.varargs {
 		ig.p("...")
 	}
-	ig.p(")\n")
+	ig.p(")")
+}
+
+func (ig *injectorGen) funcProviderCall(lname string, c *call, injectSig outputSignature) {
+	if ig.checkContext {
+		ig.checkContextErr(injectSig)
+	}
+	ig.hookStart(c, "\t")
+	ig.p("\t%s", lname)
+	prevCleanup := len(ig.cleanups)
+	if c.hasCleanup && !c.closer {
+		cname := disambiguate("cleanup", ig.nameInInjector)
+		ig.cleanups = append(ig.cleanups, cleanupVar{name: cname, errs: c.cleanupErr, ctx: c.cleanupCtx})
+		ig.p(", %s", cname)
+	}
+	if c.hasErr {
+		ig.p(", %s", ig.errVar)
+	}
+	ig.p(" := ")
+	ig.callExpr(c)
+	ig.p("\n")
+	if c.hasErr {
+		ig.hookEnd(c, ig.errVar, "\t")
+	} else {
+		ig.hookEnd(c, "nil", "\t")
+	}
 	if c.hasErr {
 		ig.p("\tif %s != nil {\n", ig.errVar)
+		ig.errorReturn(injectSig, prevCleanup, false, ig.providerErr(c, ig.errVar))
+		ig.p("\t}\n")
+	}
+	if c.closer {
+		// The value is only closed once it is created successfully.
+		ig.closerCleanup(lname, c)
+	}
+}
+
+// closerCleanup emits the cleanup function of the value in lname, which is
+// provided by a wire.Closer provider. Taking the Close method value of an
+// interface that holds nil panics, so the cleanup of an interface closes it
+// only if it is not nil.
+func (ig *injectorGen) closerCleanup(lname string, c *call) {
+	cname := disambiguate("cleanup", ig.nameInInjector)
+	ig.cleanups = append(ig.cleanups, cleanupVar{name: cname, errs: true})
+	if !closerIsInterface(c) {
+		ig.p("\t%s := %s.Close\n", cname, lname)
+		return
+	}
+	ig.p("\t%s := func() error {\n", cname)
+	ig.p("\t\tif %s == nil {\n", lname)
+	ig.p("\t\t\treturn nil\n")
+	ig.p("\t\t}\n")
+	ig.p("\t\treturn %s.Close()\n", lname)
+	ig.p("\t}\n")
+}
+
+// closerIsInterface reports whether the value provided by c, a wire.Closer
+// provider, is an interface, which may be nil.
+func closerIsInterface(c *call) bool {
+	out, _ := unqualify(c.out)
+	return types.IsInterface(out)
+}
+
+// call emits the code for a step of an injector.
+func (ig *injectorGen) call(lname string, c *call, injectSig outputSignature) {
+	switch c.kind {
+	case structProvider:
+		ig.structProviderCall(lname, c)
+	case funcProviderCall:
+		ig.funcProviderCall(lname, c, injectSig)
+	case valueExpr:
+		ig.valueExpr(lname, c)
+	case selectorExpr:
+		ig.fieldExpr(lname, c)
+	case collectionLit:
+		ig.collectionLit(lname, c)
+	case zeroValueExpr:
+		ig.zeroValueExpr(lname, c)
+	case componentLit:
+		ig.componentLit(lname, c)
+	default:
+		panic("unknown kind")
+	}
+}
+
+// parallelCalls emits the steps of an injector, calling independent
+// provider functions concurrently. The calls are grouped into waves: each
+// call is in the wave after the last wave of its arguments, so the calls of
+// a wave only depend on the calls of earlier waves. Waves are emitted in
+// order, and the calls of each wave in the order of calls, so the output is
+// deterministic.
+func (ig *injectorGen) parallelCalls(calls []call, injectSig outputSignature) {
+	var waves [][]int
+	wave := make([]int, len(calls))
+	for i := range calls {
+		w := 0
+		for _, a := range calls[i].args {
+			if a < len(ig.paramNames) {
+				continue
+			}
+			if aw := wave[a-len(ig.paramNames)] + 1; aw > w {
+				w = aw
+			}
+		}
+		wave[i] = w
+		if w == len(waves) {
+			waves = append(waves, nil)
+		}
+		waves[w] = append(waves[w], i)
+	}
+	ig.localNames = make([]string, len(calls))
+	for _, w := range waves {
+		// Only provider functions are worth running concurrently. The other
+		// steps are cheap expressions.
+		var funcs []int
+		for _, i := range w {
+			if calls[i].kind == funcProviderCall {
+				funcs = append(funcs, i)
+				continue
+			}
+			ig.localNames[i] = typeVariableName(calls[i].out, "v", unexport, ig.nameInInjector)
+			ig.call(ig.localNames[i], &calls[i], injectSig)
+		}
+		if len(funcs) == 1 {
+			i := funcs[0]
+			ig.localNames[i] = typeVariableName(calls[i].out, "v", unexport, ig.nameInInjector)
+			ig.funcProviderCall(ig.localNames[i], &calls[i], injectSig)
+		} else if len(funcs) > 1 {
+			ig.goCalls(calls, funcs, injectSig)
+		}
+	}
+}
+
+// goCalls emits the calls to the provider functions calls[i] for each i in
+// idxs in separate goroutines and waits for them to finish. If any of them
+// fails, the injector cleans up all values created so far and returns the
+// first error in the order of idxs.
+func (ig *injectorGen) goCalls(calls []call, idxs []int, injectSig outputSignature) {
+	if ig.checkContext {
+		ig.checkContextErr(injectSig)
+	}
+	type goCall struct {
+		c       *call
+		lname   string
+		cleanup *cleanupVar
+		errVar  string
+	}
+	prevCleanup := len(ig.cleanups)
+	gcs := make([]goCall, len(idxs))
+	ig.p("\tvar (\n")
+	for j, i := range idxs {
+		c := &calls[i]
+		out, _ := unqualify(c.out)
+		lname := typeVariableName(c.out, "v", unexport, ig.nameInInjector)
+		ig.localNames[i] = lname
+		gcs[j] = goCall{c: c, lname: lname}
+		ig.p("\t\t%s %s\n", lname, types.TypeString(out, ig.g.qualifyPkg))
+		if c.hasCleanup && !c.closer {
+			cv := cleanupVar{name: disambiguate("cleanup", ig.nameInInjector), errs: c.cleanupErr, ctx: c.cleanupCtx}
+			ig.cleanups = append(ig.cleanups, cv)
+			gcs[j].cleanup = &cv
+			ig.p("\t\t%s %s\n", cv.name, ig.cleanupVarType(cv))
+		}
+		if c.hasErr {
+			gcs[j].errVar = disambiguate(lname+"Err", ig.nameInInjector)
+			ig.otherNames = append(ig.otherNames, gcs[j].errVar)
+			ig.p("\t\t%s error\n", gcs[j].errVar)
+		}
+	}
+	ig.p("\t)\n")
+	if ig.wgVar == "" {
+		ig.wgVar = disambiguate("wg", ig.nameInInjector)
+		ig.otherNames = append(ig.otherNames, ig.wgVar)
+		ig.p("\tvar %s %s\n", ig.wgVar, ig.g.qualifiedID("sync", "sync", "WaitGroup"))
+	}
+	ig.p("\t%s.Add(%d)\n", ig.wgVar, len(gcs))
+	for _, gc := range gcs {
+		ig.p("\tgo func() {\n")
+		ig.p("\t\tdefer %s.Done()\n", ig.wgVar)
+		ig.hookStart(gc.c, "\t\t")
+		ig.p("\t\t%s", gc.lname)
+		if gc.cleanup != nil {
+			ig.p(", %s", gc.cleanup.name)
+		}
+		if gc.errVar != "" {
+			ig.p(", %s", gc.errVar)
+		}
+		ig.p(" = ")
+		ig.callExpr(gc.c)
+		ig.p("\n")
+		if gc.errVar != "" {
+			ig.hookEnd(gc.c, gc.errVar, "\t\t")
+		} else {
+			ig.hookEnd(gc.c, "nil", "\t\t")
+		}
+		ig.p("\t}()\n")
+	}
+	ig.p("\t%s.Wait()\n", ig.wgVar)
+	var errVars []string
+	var errCalls []*call
+	for _, gc := range gcs {
+		if gc.errVar != "" {
+			errVars = append(errVars, gc.errVar)
+			errCalls = append(errCalls, gc.c)
+		}
+	}
+	if len(errVars) > 0 {
+		ig.p("\tif %s != nil {\n", strings.Join(errVars, " != nil || "))
+		// Clean up the values of this wave that were created, then the
+		// values of earlier waves.
+		for j := len(gcs) - 1; j >= 0; j-- {
+			gc := gcs[j]
+			var stmt string
+			switch {
+			case gc.cleanup != nil:
+				stmt = ig.cleanupStmt(*gc.cleanup, false)
+			case gc.c.closer && closerIsInterface(gc.c):
+				stmt = "if " + gc.lname + " != nil {\n\t\t\t_ = " + gc.lname + ".Close()\n\t\t}"
+			case gc.c.closer:
+				stmt = "_ = " + gc.lname + ".Close()"
+			default:
+				continue
+			}
+			if gc.errVar == "" {
+				ig.p("\t\t%s\n", stmt)
+				continue
+			}
+			ig.p("\t\tif %s == nil {\n", gc.errVar)
+			ig.p("\t\t\t%s\n", stmt)
+			ig.p("\t\t}\n")
+		}
 		for i := prevCleanup - 1; i >= 0; i-- {
-			ig.p("\t\t%s()\n", ig.cleanupNames[i])
+			ig.p("\t\t%s\n", ig.cleanupStmt(ig.cleanups[i], false))
 		}
-		ig.p("\t\treturn %s", zeroValue(injectSig.out, ig.g.qualifyPkg))
-		if injectSig.cleanup {
-			ig.p(", nil")
+		last := len(errVars) - 1
+		for j, e := range errVars[:last] {
+			ig.p("\t\tif %s != nil {\n", e)
+			ig.p("\t")
+			ig.returnErr(injectSig, ig.providerErr(errCalls[j], e))
+			ig.p("\t\t}\n")
 		}
-		// TODO(light): Give information about failing provider.
-		ig.p(", err\n")
+		ig.returnErr(injectSig, ig.providerErr(errCalls[last], errVars[last]))
 		ig.p("\t}\n")
 	}
+	for _, gc := range gcs {
+		if gc.c.closer {
+			ig.closerCleanup(gc.lname, gc.c)
+		}
+	}
+}
+
+// cleanupVarType returns the type of a cleanup function variable.
+func (ig *injectorGen) cleanupVarType(c cleanupVar) string {
+	return ig.cleanupType(outputSignature{cleanup: true, cleanupErr: c.errs, cleanupCtx: c.ctx})
+}
+
+// cleanupType returns the type of the cleanup function returned by an
+// injector.
+func (ig *injectorGen) cleanupType(injectSig outputSignature) string {
+	switch {
+	case injectSig.cleanupCtx:
+		return "func(" + ig.g.qualifiedID("context", "context", "Context") + ") error"
+	case injectSig.cleanupErr:
+		return "func() error"
+	default:
+		return "func()"
+	}
+}
+
+// cleanupFunc emits the cleanup function returned by an injector, which
+// calls the cleanup functions of the providers in reverse order. If the
+// cleanup function returns an error, it returns the errors of all the
+// cleanup functions that failed, collected by the type emitted by
+// cleanupErrorsType.
+func (ig *injectorGen) cleanupFunc(injectSig outputSignature) {
+	if !injectSig.cleanupErr {
+		ig.p(", func() {\n")
+		for i := len(ig.cleanups) - 1; i >= 0; i-- {
+			ig.p("\t\t%s()\n", ig.cleanups[i].name)
+		}
+		ig.p("\t}")
+		return
+	}
+	if injectSig.cleanupCtx {
+		ig.p(", func(ctx %s) error {\n", ig.g.qualifiedID("context", "context", "Context"))
+	} else {
+		ig.p(", func() error {\n")
+	}
+	errs := false
+	for _, c := range ig.cleanups {
+		errs = errs || c.errs
+	}
+	if errs {
+		if ig.g.cleanupErrors == "" {
+			ig.g.cleanupErrors = disambiguate("_wireCleanupErrors", ig.g.nameInFileScope)
+		}
+		ig.p("\t\tvar errs %s\n", ig.g.cleanupErrors)
+	}
+	for i := len(ig.cleanups) - 1; i >= 0; i-- {
+		c := ig.cleanups[i]
+		switch {
+		case c.ctx:
+			ig.p("\t\tif cerr := %s(ctx); cerr != nil {\n", c.name)
+			ig.p("\t\t\terrs = append(errs, cerr)\n")
+			ig.p("\t\t}\n")
+		case c.errs:
+			ig.p("\t\tif cerr := %s(); cerr != nil {\n", c.name)
+			ig.p("\t\t\terrs = append(errs, cerr)\n")
+			ig.p("\t\t}\n")
+		default:
+			ig.p("\t\t%s()\n", c.name)
+		}
+	}
+	if errs {
+		ig.p("\t\treturn errs.err()\n")
+	} else {
+		ig.p("\t\treturn nil\n")
+	}
+	ig.p("\t}")
+}
+
+// errorPathContext returns the context passed to cleanup functions that are
+// called because a provider failed: the injector's first context.Context
+// argument, or context.Background() if there is none.
+func (ig *injectorGen) errorPathContext() string {
+	if ig.ctxParam != "" {
+		return ig.ctxParam
+	}
+	return ig.g.qualifiedID("context", "context", "Background") + "()"
 }
 
 func (ig *injectorGen) structProviderCall(lname string, c *call) {
+	ig.hookStart(c, "\t")
 	ig.p("\t%s", lname)
 	ig.p(" := ")
-	if _, ok := c.out.(*types.Pointer); ok {
+	out, _ := unqualify(c.out)
+	if _, ok := out.(*types.Pointer); ok {
 		ig.p("&")
 	}
-	ig.p("%s{\n", ig.g.qualifiedID(c.pkg.Name(), c.pkg.Path(), c.name))
+	ig.p("%s%s{\n", ig.g.qualifiedID(c.pkg.Name(), c.pkg.Path(), c.name), ig.typeArgs(c.typeArgs))
 	for i, a := range c.args {
 		ig.p("\t\t%s: ", c.fieldNames[i])
 		if a < len(ig.paramNames) {
@@ -728,6 +1407,20 @@ func (ig *injectorGen) structProviderCall(lname string, c *call) {
 		ig.p(",\n")
 	}
 	ig.p("\t}\n")
+	ig.hookEnd(c, "nil", "\t")
+}
+
+// typeArgs formats the type arguments of an instantiation, or returns the
+// empty string if there are none.
+func (ig *injectorGen) typeArgs(args []types.Type) string {
+	if len(args) == 0 {
+		return ""
+	}
+	strs := make([]string, len(args))
+	for i, t := range args {
+		strs[i] = types.TypeString(t, ig.g.qualifyPkg)
+	}
+	return "[" + strings.Join(strs, ", ") + "]"
 }
 
 func (ig *injectorGen) valueExpr(lname string, c *call) {
@@ -747,6 +1440,50 @@ func (ig *injectorGen) fieldExpr(lname string, c *call) {
 	}
 }
 
+func (ig *injectorGen) zeroValueExpr(lname string, c *call) {
+	out, _ := unqualify(c.out)
+	ig.p("\tvar %s %s\n", lname, types.TypeString(out, ig.g.qualifyPkg))
+}
+
+func (ig *injectorGen) componentLit(lname string, c *call) {
+	comp := ig.g.component(c.out)
+	ig.p("\t%s := &%s{\n", lname, comp.name)
+	for i, a := range c.args {
+		ig.p("\t\t%s: ", comp.fields[i])
+		if a < len(ig.paramNames) {
+			ig.p("%s", ig.paramNames[a])
+		} else {
+			ig.p("%s", ig.localNames[a-len(ig.paramNames)])
+		}
+		ig.p(",\n")
+	}
+	ig.p("\t}\n")
+}
+
+func (ig *injectorGen) collectionLit(lname string, c *call) {
+	out, _ := unqualify(c.out)
+	ig.p("\t%s := %s{", lname, types.TypeString(out, ig.g.qualifyPkg))
+	if len(c.args) > 0 {
+		ig.p("\n")
+	}
+	for i, a := range c.args {
+		ig.p("\t\t")
+		if c.mapKeys != nil {
+			ig.p("%s: ", strconv.Quote(c.mapKeys[i]))
+		}
+		if a < len(ig.paramNames) {
+			ig.p("%s", ig.paramNames[a])
+		} else {
+			ig.p("%s", ig.localNames[a-len(ig.paramNames)])
+		}
+		ig.p(",\n")
+	}
+	if len(c.args) > 0 {
+		ig.p("\t")
+	}
+	ig.p("}\n")
+}
+
 // nameInInjector reports whether name collides with any other identifier
 // in the current injector.
 func (ig *injectorGen) nameInInjector(name string) bool {
@@ -763,7 +1500,12 @@ func (ig *injectorGen) nameInInjector(name string) bool {
 			return true
 		}
 	}
-	for _, l := range ig.cleanupNames {
+	for _, c := range ig.cleanups {
+		if c.name == name {
+			return true
+		}
+	}
+	for _, l := range ig.otherNames {
 		if l == name {
 			return true
 		}
@@ -811,10 +1553,15 @@ func zeroValue(t types.Type, qf types.Qualifier) string {
 // names is unambiguous, it used; otherwise, the first derived name is
 // disambiguated using disambiguate().
 func typeVariableName(t types.Type, defaultName string, transform func(string) string, collides func(string) bool) string {
+	var names []string
+	// Prefer the name given to wire.Named for a qualified type.
+	t, qualifier := unqualify(t)
+	if token.IsIdentifier(qualifier) {
+		names = append(names, qualifier)
+	}
 	if p, ok := t.(*types.Pointer); ok {
 		t = p.Elem()
 	}
-	var names []string
 	switch t := t.(type) {
 	case *types.Basic:
 		if t.Name() != "" {
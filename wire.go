@@ -30,19 +30,20 @@ type ProviderSet struct{}
 
 // NewSet creates a new provider set that includes the providers in its
 // arguments. Each argument is a function value, a provider set, a call to
-// Struct, a call to Bind, a call to Value, a call to InterfaceValue or a call
-// to FieldsOf.
+// Struct, a call to Bind, a call to Value, a call to InterfaceValue, a call
+// to FieldsOf, a call to Named, a call to NamedArgs, a call to SliceOf, a
+// call to MapOf, a call to Optional or a call to Decorate.
 //
 // Passing a function value to NewSet declares that the function's first
 // return value type will be provided by calling the function. The arguments
 // to the function will come from the providers for their types. As such, all
 // the function's parameters must be of non-identical types. The function may
 // optionally return an error as its last return value and a cleanup function
-// as the second return value. A cleanup function must be of type func() and is
-// guaranteed to be called before the cleanup function of any of the
-// provider's inputs. If any provider returns an error, the injector function
-// will call all the appropriate cleanup functions and return the error from
-// the injector function.
+// as the second return value. A cleanup function must be of type func(),
+// func() error or func(context.Context) error, and is guaranteed to be called
+// before the cleanup function of any of the provider's inputs. If any provider
+// returns an error, the injector function will call all the appropriate
+// cleanup functions and return the error from the injector function.
 //
 // Passing a ProviderSet to NewSet is the same as if the set's contents
 // were passed as arguments to NewSet directly.
@@ -74,7 +75,13 @@ func NewSet(...interface{}) ProviderSet {
 // cleanup function, and the optional last return value is an error. If any of
 // the provider functions in the injector function's provider set return errors
 // or cleanup functions, the corresponding return value must be present in the
-// injector function template.
+// injector function template. The injector's cleanup function calls the
+// cleanup functions of its providers in reverse order. If any of them returns
+// an error, the injector's cleanup function must be of type func() error, and
+// if any of them takes a context.Context, it must be of type
+// func(context.Context) error and passes its argument along. It calls all of
+// them and returns the errors of all the ones that failed, joined like
+// errors.Join does.
 //
 // Examples:
 //
@@ -90,6 +97,65 @@ func Build(...interface{}) string {
 	return "implementation not generated, run wire"
 }
 
+// An InjectorOption changes how Wire generates an injector. Injector options
+// may only be passed to Build.
+type InjectorOption struct{}
+
+// CheckContext makes the injector check its context.Context argument for
+// cancellation before each call to a provider function. If the context is
+// done, the injector calls the cleanup functions of the values created so
+// far and returns the context's error, instead of creating the remaining
+// values. The injector must take a context.Context argument and return an
+// error.
+//
+// Example:
+//
+//	func initServer(ctx context.Context) (*Server, func(), error) {
+//		wire.Build(ServerSet, wire.CheckContext())
+//		return nil, nil, nil
+//	}
+func CheckContext() InjectorOption {
+	return InjectorOption{}
+}
+
+// Parallel makes the injector call provider functions that do not depend on
+// each other concurrently, in separate goroutines. The calls are grouped
+// into waves: the provider functions of a wave only depend on values created
+// by earlier waves, and the injector waits for a wave to finish before
+// starting the next one. If any provider function of a wave fails, the
+// injector calls the cleanup functions of the values created so far and
+// returns the error of the first failed provider in the order Wire would
+// otherwise have called them.
+//
+// Provider functions called concurrently must be safe to call concurrently
+// with each other.
+//
+// Example:
+//
+//	func initServer(cfg *Config) (*Server, func(), error) {
+//		wire.Build(ServerSet, wire.Parallel())
+//		return nil, nil, nil
+//	}
+func Parallel() InjectorOption {
+	return InjectorOption{}
+}
+
+// Instrument makes the injector call the Start and End methods of a
+// wirehook.Hooks around each call to a provider function and each struct
+// provider, for instance to measure how long the providers take. The injector
+// uses its first wirehook.Hooks argument, or wirehook.Default() if it has
+// none.
+//
+// Example:
+//
+//	func initServer(hooks wirehook.Hooks) (*Server, error) {
+//		wire.Build(ServerSet, wire.Instrument())
+//		return nil, nil
+//	}
+func Instrument() InjectorOption {
+	return InjectorOption{}
+}
+
 // A Binding maps an interface to a concrete type.
 type Binding struct{}
 
@@ -162,10 +228,60 @@ type StructProvider struct{}
 //	}
 //	var Set = wire.NewSet(wire.Struct(new(S), "MyFoo")) -> inject only S.MyFoo
 //	var Set = wire.NewSet(wire.Struct(new(S), "*")) -> inject all fields
+//
+// A field tagged with `wire:"name=foo"` is filled in with the value of its
+// type provided under the name "foo" (see Named). A field tagged with
+// `wire:"optional"` is left as its zero value if no provider in the set
+// provides its type. Options are separated by commas, as in
+// `wire:"name=foo,optional"`.
 func Struct(structType interface{}, fieldNames ...string) StructProvider {
 	return StructProvider{}
 }
 
+// Outputs marks a struct type as the outputs of an injector. An injector
+// whose return type is a struct, or a pointer to a struct, that embeds Outputs
+// produces every field of the struct from one shared graph, instead of
+// requiring a provider for the struct itself. Each value is created only
+// once even if several fields depend on it, and the injector's cleanup
+// function and error cover every field. Fields are injected like the fields
+// of a Struct provider with "*", including their tags.
+//
+// Example:
+//
+//	type App struct {
+//		wire.Outputs
+//		Server *Server
+//		Worker *Worker
+//	}
+//
+//	func InitializeApp() (App, func(), error) {
+//		wire.Build(AppSet)
+//		return App{}, nil, nil
+//	}
+type Outputs struct{}
+
+// Component marks an interface type as a component. An injector whose return
+// type is an interface that embeds Component returns a generated
+// implementation of the interface, instead of requiring a provider for it.
+// Each method of the interface must take no arguments and return a single
+// value, which the injector creates from one shared graph like the fields of
+// an Outputs struct. The methods return the values created by the injector
+// rather than creating new ones on each call.
+//
+// Example:
+//
+//	type App interface {
+//		wire.Component
+//		Server() *Server
+//		Worker() *Worker
+//	}
+//
+//	func InitializeApp() (App, func(), error) {
+//		wire.Build(AppSet)
+//		return nil, nil, nil
+//	}
+type Component interface{}
+
 // StructFields is a collection of the fields from a struct.
 type StructFields struct{}
 
@@ -194,3 +310,176 @@ type StructFields struct{}
 func FieldsOf(structType interface{}, fieldNames ...string) StructFields {
 	return StructFields{}
 }
+
+// A CloserProvider is a provider whose value is closed by the injector's
+// cleanup function.
+type CloserProvider struct{}
+
+// Closer declares that the value created by the provider function fn is
+// cleaned up by calling its Close method, which must have the signature
+// Close() error as in io.Closer. This saves writing a cleanup function that
+// only closes the value. fn must not return a cleanup function itself.
+//
+// Because Close returns an error, an injector using fn must return a cleanup
+// function of type func() error or func(context.Context) error. If fn
+// provides an interface, the value is only closed if it is not nil.
+//
+// fn may also be a call to NamedArgs or Named.
+//
+// Example:
+//
+//	func NewDB(cfg *Config) (*sql.DB, error) { /* ... */ }
+//
+//	var DBSet = wire.NewSet(wire.Closer(NewDB))
+func Closer(fn interface{}) CloserProvider {
+	return CloserProvider{}
+}
+
+// A NamedProvider is a provider whose outputs are qualified by a name.
+type NamedProvider struct{}
+
+// Named declares that the types produced by provider are provided under the
+// given name instead of being provided unqualified. This allows several
+// providers of the same type to coexist in a provider set, as long as each
+// is given a different name. A named type is only injected into provider
+// parameters requested with NamedArgs and into struct fields tagged with
+// `wire:"name=..."`.
+//
+// provider may be a function, a call to Struct, a call to Value, a call to
+// InterfaceValue, a call to NamedArgs, or a call to Bind. For Bind, both the
+// interface and the concrete type are qualified by name.
+//
+// Example:
+//
+//	var DBSet = wire.NewSet(
+//		NewPrimaryDB,
+//		wire.Named("replica", NewReplicaDB))
+func Named(name string, provider interface{}) NamedProvider {
+	return NamedProvider{}
+}
+
+// A NamedArgsProvider is a provider function whose parameters request named
+// types.
+type NamedArgsProvider struct{}
+
+// NamedArgs declares that the parameters of the provider function fn request
+// types provided under the given names. The names are matched to fn's
+// parameters by position; an empty name or a missing trailing name requests
+// the parameter's unqualified type.
+//
+// Example:
+//
+//	func NewStore(primary, replica *sql.DB) *Store { /* ... */ }
+//
+//	var Set = wire.NewSet(
+//		NewPrimaryDB,
+//		wire.Named("replica", NewReplicaDB),
+//		wire.NamedArgs(NewStore, "", "replica"))
+func NamedArgs(fn interface{}, names ...string) NamedArgsProvider {
+	return NamedArgsProvider{}
+}
+
+// A Multibinding collects the values of several providers into a slice or a
+// map.
+type Multibinding struct{}
+
+// SliceOf declares that the values produced by the given providers are
+// collected into a slice. The first argument must be a pointer to the
+// element type T, and SliceOf provides []T. Each remaining argument is a
+// function, a call to Struct, a call to Value, a call to InterfaceValue or a
+// call to NamedArgs, whose provided type must be assignable to T. The values
+// contributed this way are not provided on their own.
+//
+// Unlike other providers, several provider sets may contribute to the same
+// slice type: when they are combined, the slice holds the contributions of
+// imported sets first, in the order the sets are listed, followed by the
+// set's own contributions in the order they are listed.
+//
+// Example:
+//
+//	var HandlerSet = wire.NewSet(
+//		wire.SliceOf(new(http.Handler), NewUserHandler, NewOrderHandler))
+func SliceOf(elem interface{}, providers ...interface{}) Multibinding {
+	return Multibinding{}
+}
+
+// MapOf is like SliceOf, but collects the values into a map[string]T. The
+// arguments after the pointer to T alternate between a string constant key
+// and the provider contributing the value for that key. It is an error for
+// two contributions to use the same key.
+//
+// Example:
+//
+//	var CheckSet = wire.NewSet(
+//		wire.MapOf(new(HealthChecker),
+//			"db", NewDBChecker,
+//			"cache", NewCacheChecker))
+func MapOf(elem interface{}, entries ...interface{}) Multibinding {
+	return Multibinding{}
+}
+
+// An OptionalBinding declares that a type may be left unprovided.
+type OptionalBinding struct{}
+
+// Optional declares that the type pointed to by typ may be injected even if
+// no provider in the set provides it. In that case, the injector uses the
+// given default value, or the zero value of the type if no default is given.
+// The default value is subject to the same restrictions as the argument to
+// Value, and must be assignable to the type.
+//
+// An Optional declaration never conflicts with a provider of the same type:
+// if the type is provided by another member of the set or of a set that
+// includes it, that provider is used instead.
+//
+// Example:
+//
+//	var LibSet = wire.NewSet(
+//		wire.Optional(new(*Tracer)),
+//		wire.Optional(new(Clock), systemClock{}),
+//		NewClient)
+func Optional(typ interface{}, defaultValue ...interface{}) OptionalBinding {
+	return OptionalBinding{}
+}
+
+// A Decorator wraps a value that is provided by another provider.
+type Decorator struct{}
+
+// Decorate declares that fn wraps every value of its first return value
+// type T before it is injected. fn must have exactly one parameter of type
+// T, which receives the value being decorated; its other parameters are
+// injected like those of any provider function. Like a provider function,
+// fn may return a cleanup function and an error.
+//
+// Several decorators may apply to the same type. They are applied in the
+// order they are declared: the decorators of included provider sets are
+// applied first, in the order the sets are listed, followed by the set's
+// own decorators in the order they are listed. The value of type T that is
+// injected is the result of the last decorator. A decorator applies to T
+// wherever T is provided from, so a provider set may decorate a type that
+// is only provided by a set that includes it.
+//
+// Example:
+//
+//	func WithLogging(h http.Handler, l *log.Logger) http.Handler { /* ... */ }
+//
+//	var Set = wire.NewSet(NewHandler, wire.Decorate(WithLogging))
+func Decorate(fn interface{}) Decorator {
+	return Decorator{}
+}
+
+// Override creates a provider set that includes the given provider set,
+// except that the remaining arguments replace its providers for the same
+// types instead of conflicting with them. The remaining arguments are
+// interpreted the same as the arguments to NewSet, and may still conflict
+// with each other. Decorators declared in the overridden set also apply to
+// the replacements. The wire show command lists the replaced providers.
+//
+// Override is meant for tests that swap a few dependencies of a production
+// provider set for fakes.
+//
+// Example:
+//
+//	var TestSet = wire.Override(ProdSet, NewFakeDB, wire.Value(testConfig))
+func Override(set ProviderSet, overrides ...interface{}) ProviderSet {
+	return ProviderSet{}
+}
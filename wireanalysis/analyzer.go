@@ -0,0 +1,150 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wireanalysis defines an Analyzer that reports the errors the wire
+// command would report for a package, such as invalid providers, missing
+// providers, conflicting bindings and dependency cycles. It lets go vet and
+// other drivers of golang.org/x/tools/go/analysis check Wire provider sets
+// and injectors without running the wire command.
+package wireanalysis
+
+import (
+	"context"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/almondoo/wire/internal/wire"
+	"golang.org/x/tools/go/analysis"
+)
+
+const doc = `report errors in Wire provider sets and injectors
+
+The wire analyzer reports the errors that wire check would report for a
+package, at the positions of the offending provider sets, providers and
+injectors. Injectors are usually declared in files with the wireinject build
+tag, so pass -tags=wireinject to go vet to report errors at their positions.`
+
+// Analyzer reports errors in the Wire provider sets and injectors of a
+// package.
+//
+// The drivers of golang.org/x/tools/go/analysis only provide the syntax of
+// the package being analyzed, but Wire needs the syntax of the provider sets
+// it imports. So for each package that imports Wire, Analyzer loads the
+// package and its dependencies as the wire command does. The files of the
+// package are loaded with the contents the driver provides, such as the
+// unsaved buffers of an editor, and those of its dependencies from disk.
+var Analyzer = &analysis.Analyzer{
+	Name: "wire",
+	Doc:  doc,
+	URL:  "https://pkg.go.dev/github.com/almondoo/wire/wireanalysis",
+	Run:  run,
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	if len(pass.Files) == 0 || !importsWire(pass.Pkg) {
+		return nil, nil
+	}
+	files := make(map[string]*token.File)
+	for _, f := range pass.Files {
+		tf := pass.Fset.File(f.Pos())
+		if strings.HasSuffix(tf.Name(), "_test.go") {
+			// Wire does not load test files, and the errors in the other
+			// files are reported for the package without its tests.
+			return nil, nil
+		}
+		files[tf.Name()] = tf
+	}
+	dir := filepath.Dir(pass.Fset.File(pass.Files[0].Pos()).Name())
+	overlay, err := readFiles(pass)
+	if err != nil {
+		return nil, err
+	}
+	_, errs := load(context.Background(), dir, os.Environ(), "", []string{"."}, overlay)
+	reported := make(map[string]bool)
+	for _, err := range errs {
+		p, err := wire.ErrorPosition(err)
+		if !p.IsValid() {
+			// The error is about the package as a whole, as when it cannot
+			// be loaded.
+			pass.Reportf(pass.Files[0].Package, "%v", err)
+			continue
+		}
+		if filepath.Dir(p.Filename) != dir {
+			// The error is in a provider set imported from another
+			// package, which reports it itself.
+			continue
+		}
+		// Load reports an error in a provider set once for each injector
+		// or set that includes it.
+		k := p.String() + ": " + err.Error()
+		if reported[k] {
+			continue
+		}
+		reported[k] = true
+		tf := files[p.Filename]
+		if tf == nil || p.Line > tf.LineCount() {
+			// The file is excluded from the package being analyzed by its
+			// build tags, as an injector file without -tags=wireinject.
+			pass.Reportf(pass.Files[0].Package, "%s:%d:%d: %v", filepath.Base(p.Filename), p.Line, p.Column, err)
+			continue
+		}
+		pos := tf.LineStart(p.Line)
+		if p.Column > 1 {
+			pos += token.Pos(p.Column - 1)
+		}
+		pass.Reportf(pos, "%v", err)
+	}
+	return nil, nil
+}
+
+// load is wire.Load, replaced by tests.
+var load = wire.Load
+
+// readFiles returns the contents of the Go files of the package being
+// analyzed, including those excluded by build tags, read with pass.ReadFile.
+// It returns nil if the driver does not provide ReadFile.
+func readFiles(pass *analysis.Pass) (map[string][]byte, error) {
+	if pass.ReadFile == nil {
+		return nil, nil
+	}
+	names := append([]string(nil), pass.IgnoredFiles...)
+	for _, f := range pass.Files {
+		names = append(names, pass.Fset.File(f.Pos()).Name())
+	}
+	files := make(map[string][]byte)
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".go") {
+			continue
+		}
+		content, err := pass.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = content
+	}
+	return files, nil
+}
+
+// importsWire reports whether pkg imports the wire package directly.
+func importsWire(pkg *types.Package) bool {
+	for _, imp := range pkg.Imports() {
+		if imp.Path() == "github.com/almondoo/wire" || strings.HasSuffix(imp.Path(), "/vendor/github.com/almondoo/wire") {
+			return true
+		}
+	}
+	return false
+}
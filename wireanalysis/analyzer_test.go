@@ -0,0 +1,189 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wireanalysis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/almondoo/wire/internal/wire"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/packages"
+)
+
+// runAnalyzer runs Analyzer on the package in dir, a directory of the
+// testdata module, and returns its diagnostics as "line: message". The
+// package is loaded from source rather than with analysistest, whose loader
+// depends on the export data format of the Go toolchain. buffers maps the
+// names of files of the package, relative to dir, to contents that replace
+// them, like the unsaved buffers of an editor.
+func runAnalyzer(t *testing.T, dir string, buffers map[string]string) []string {
+	t.Helper()
+	abs, err := filepath.Abs(filepath.Join("testdata", dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay := make(map[string][]byte)
+	for name, content := range buffers {
+		overlay[filepath.Join(abs, name)] = []byte(content)
+	}
+	cfg := &packages.Config{
+		Mode:    packages.LoadAllSyntax,
+		Dir:     abs,
+		Env:     append(os.Environ(), "GOPROXY=off"),
+		Overlay: overlay,
+	}
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pkgs) != 1 || len(pkgs[0].Errors) > 0 {
+		t.Fatalf("load %s: %v", dir, pkgs[0].Errors)
+	}
+	pkg := pkgs[0]
+	var diags []string
+	pass := &analysis.Pass{
+		Analyzer:     Analyzer,
+		Fset:         pkg.Fset,
+		Files:        pkg.Syntax,
+		IgnoredFiles: pkg.IgnoredFiles,
+		Pkg:          pkg.Types,
+		TypesInfo:    pkg.TypesInfo,
+		Report: func(d analysis.Diagnostic) {
+			diags = append(diags, fmt.Sprintf("%d: %s", pkg.Fset.Position(d.Pos).Line, d.Message))
+		},
+		ReadFile: func(filename string) ([]byte, error) {
+			if content, ok := overlay[filename]; ok {
+				return content, nil
+			}
+			return os.ReadFile(filename)
+		},
+	}
+	if _, err := Analyzer.Run(pass); err != nil {
+		t.Fatalf("run %s: %v", dir, err)
+	}
+	return diags
+}
+
+func TestAnalyzer(t *testing.T) {
+	tests := []struct {
+		name    string
+		dir     string
+		buffers map[string]string
+		want    []string
+	}{
+		{
+			dir: "ok",
+		},
+		{
+			// The error is in the buffer of the editor rather than in the
+			// file on disk.
+			name: "ok_buffer",
+			dir:  "ok",
+			buffers: map[string]string{
+				"ok.go": `package ok
+
+import "github.com/almondoo/wire"
+
+type Foo struct{}
+
+func NewFoo() *Foo { return &Foo{} }
+
+func InitializeFoo() *Foo {
+	wire.Build()
+	return nil
+}
+`,
+			},
+			want: []string{
+				`9: inject InitializeFoo: no provider found for *example.com/wiretest/ok.Foo`,
+			},
+		},
+		{
+			dir: "errors",
+			want: []string{
+				`15: wrong signature for provider NoResult: no return values`,
+				`19: cycle for *example.com/wiretest/errors.Baz`,
+				`21: inject InitializeBar: no provider found for *example.com/wiretest/errors.Bar`,
+			},
+		},
+		{
+			// The injector is in a file excluded without the wireinject
+			// build tag, so the error is reported at the package clause.
+			dir: "tagged",
+			want: []string{
+				`1: wire.go:8:1: inject InitializeFoo: no provider found for *example.com/wiretest/tagged.Foo`,
+			},
+		},
+		{
+			// The buffer of the injector file, which is excluded without the
+			// wireinject build tag, fixes the error.
+			name: "tagged_buffer",
+			dir:  "tagged",
+			buffers: map[string]string{
+				"wire.go": `//go:build wireinject
+// +build wireinject
+
+package tagged
+
+import "github.com/almondoo/wire"
+
+func InitializeBar() *Bar {
+	wire.Build(Set)
+	return nil
+}
+`,
+			},
+		},
+	}
+	for _, test := range tests {
+		name := test.name
+		if name == "" {
+			name = test.dir
+		}
+		t.Run(name, func(t *testing.T) {
+			got := runAnalyzer(t, test.dir, test.buffers)
+			if len(got) != len(test.want) {
+				t.Fatalf("got diagnostics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+			}
+			for i := range got {
+				if !strings.HasPrefix(got[i], test.want[i]) {
+					t.Errorf("diagnostic %d = %q, want prefix %q", i, got[i], test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAnalyzerErrorWithoutPosition(t *testing.T) {
+	defer func(f func(context.Context, string, []string, string, []string, map[string][]byte) (*wire.Info, []error)) {
+		load = f
+	}(load)
+	load = func(context.Context, string, []string, string, []string, map[string][]byte) (*wire.Info, []error) {
+		return nil, []error{errors.New("go list failed")}
+	}
+	// The error is reported at the package clause instead of failing the
+	// analysis.
+	got := runAnalyzer(t, "ok", nil)
+	want := []string{"1: go list failed"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got diagnostics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
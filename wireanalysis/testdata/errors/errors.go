@@ -0,0 +1,24 @@
+package errors
+
+import "github.com/almondoo/wire"
+
+type Foo struct{}
+
+type Bar struct{}
+
+type Baz struct{}
+
+func NewFoo(*Baz) *Foo { return &Foo{} }
+
+func NewBaz(*Foo) *Baz { return &Baz{} }
+
+func NoResult() {}
+
+var BadSet = wire.NewSet(NoResult)
+
+var CycleSet = wire.NewSet(NewFoo, NewBaz)
+
+func InitializeBar() *Bar {
+	wire.Build()
+	return nil
+}
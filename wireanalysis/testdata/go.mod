@@ -0,0 +1,7 @@
+module example.com/wiretest
+
+go 1.19
+
+require github.com/almondoo/wire v0.0.0-00010101000000-000000000000
+
+replace github.com/almondoo/wire => ../..
@@ -0,0 +1,12 @@
+package ok
+
+import "github.com/almondoo/wire"
+
+type Foo struct{}
+
+func NewFoo() *Foo { return &Foo{} }
+
+func InitializeFoo() *Foo {
+	wire.Build(NewFoo)
+	return nil
+}
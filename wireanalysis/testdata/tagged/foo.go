@@ -0,0 +1,11 @@
+package tagged
+
+import "github.com/almondoo/wire"
+
+type Foo struct{}
+
+type Bar struct{}
+
+func NewBar() *Bar { return &Bar{} }
+
+var Set = wire.NewSet(NewBar)
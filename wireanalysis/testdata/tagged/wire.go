@@ -0,0 +1,11 @@
+//go:build wireinject
+// +build wireinject
+
+package tagged
+
+import "github.com/almondoo/wire"
+
+func InitializeFoo() *Foo {
+	wire.Build(Set)
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wirehook contains the hooks called by injectors generated with
+// wire.Instrument. It is imported by the generated code at run time, unlike
+// package wire, which is only used as input to the Wire code generation tool.
+package wirehook
+
+import "sync/atomic"
+
+// Provider identifies a provider called by an injector.
+type Provider struct {
+	// Name is the name of the provider function or struct type.
+	Name string
+	// PkgPath is the import path of the package that declares the provider.
+	PkgPath string
+}
+
+// String returns the provider's package path and name, as in
+// "example.com/db.NewDB".
+func (p Provider) String() string {
+	return p.PkgPath + "." + p.Name
+}
+
+// Hooks is called by instrumented injectors around each provider call.
+// Start is called right before the provider is called and End right after it
+// returns, with the error it returned or nil. Injectors passed wire.Parallel
+// call providers concurrently, so their Hooks must be safe for concurrent use.
+type Hooks interface {
+	Start(p Provider)
+	End(p Provider, err error)
+}
+
+// hooksBox wraps Hooks so that Hooks of different types can be stored in the
+// same atomic.Value.
+type hooksBox struct {
+	h Hooks
+}
+
+var defaultHooks atomic.Value // of hooksBox
+
+// SetDefault sets the Hooks used by instrumented injectors that do not take
+// a Hooks argument. A nil h disables them.
+func SetDefault(h Hooks) {
+	defaultHooks.Store(hooksBox{h})
+}
+
+// Default returns the Hooks set by SetDefault, or Hooks that do nothing if
+// there are none.
+func Default() Hooks {
+	if b, _ := defaultHooks.Load().(hooksBox); b.h != nil {
+		return b.h
+	}
+	return nopHooks{}
+}
+
+type nopHooks struct{}
+
+func (nopHooks) Start(Provider)      {}
+func (nopHooks) End(Provider, error) {}
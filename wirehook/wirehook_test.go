@@ -0,0 +1,156 @@
+// Copyright 2018 The Wire Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wirehook
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+// recordHooks records the calls of Start and End.
+type recordHooks struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (h *recordHooks) Start(p Provider) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls = append(h.calls, "start "+p.String())
+}
+
+func (h *recordHooks) End(p Provider, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	msg := "end " + p.String()
+	if err != nil {
+		msg += ": " + err.Error()
+	}
+	h.calls = append(h.calls, msg)
+}
+
+// countHooks counts the calls of Start. It is a different type than
+// recordHooks, so storing both in the same atomic.Value would panic without
+// hooksBox.
+type countHooks struct {
+	mu     sync.Mutex
+	starts int
+}
+
+func (h *countHooks) Start(Provider) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.starts++
+}
+
+func (h *countHooks) End(Provider, error) {}
+
+// resetDefault restores the default Hooks at the end of the test.
+func resetDefault(t *testing.T) {
+	t.Helper()
+	t.Cleanup(func() { SetDefault(nil) })
+}
+
+func TestProviderString(t *testing.T) {
+	p := Provider{Name: "NewDB", PkgPath: "example.com/db"}
+	if got, want := p.String(), "example.com/db.NewDB"; got != want {
+		t.Errorf("String() = %q; want %q", got, want)
+	}
+}
+
+func TestDefault(t *testing.T) {
+	resetDefault(t)
+	p := Provider{Name: "NewDB", PkgPath: "example.com/db"}
+
+	// Before SetDefault is called, and after it is called with nil, Default
+	// returns Hooks that do nothing rather than nil, so that injectors can
+	// call them unconditionally.
+	h := Default()
+	if _, ok := h.(nopHooks); !ok {
+		t.Fatalf("Default() before SetDefault = %T; want nopHooks", h)
+	}
+	h.Start(p)
+	h.End(p, errors.New("no db"))
+
+	rec := new(recordHooks)
+	SetDefault(rec)
+	if got := Default(); got != Hooks(rec) {
+		t.Fatalf("Default() = %v; want the Hooks passed to SetDefault", got)
+	}
+	Default().Start(p)
+	Default().End(p, errors.New("no db"))
+	want := []string{"start example.com/db.NewDB", "end example.com/db.NewDB: no db"}
+	if len(rec.calls) != len(want) || rec.calls[0] != want[0] || rec.calls[1] != want[1] {
+		t.Errorf("calls = %q; want %q", rec.calls, want)
+	}
+
+	// Hooks of another type replace them.
+	count := new(countHooks)
+	SetDefault(count)
+	if got := Default(); got != Hooks(count) {
+		t.Fatalf("Default() after second SetDefault = %v; want the new Hooks", got)
+	}
+
+	SetDefault(nil)
+	if h := Default(); h == nil {
+		t.Fatal("Default() after SetDefault(nil) = nil; want Hooks that do nothing")
+	} else if _, ok := h.(nopHooks); !ok {
+		t.Fatalf("Default() after SetDefault(nil) = %T; want nopHooks", h)
+	}
+}
+
+func TestDefaultConcurrent(t *testing.T) {
+	resetDefault(t)
+	p := Provider{Name: "NewDB", PkgPath: "example.com/db"}
+	rec := new(recordHooks)
+	count := new(countHooks)
+
+	// Goroutines swap Hooks of different types, and nil, while others call
+	// the current default like instrumented injectors do.
+	const n = 100
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				switch (i + j) % 3 {
+				case 0:
+					SetDefault(rec)
+				case 1:
+					SetDefault(count)
+				default:
+					SetDefault(nil)
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				h := Default()
+				switch h.(type) {
+				case *recordHooks, *countHooks, nopHooks:
+				default:
+					t.Errorf("Default() = %T; want one of the Hooks passed to SetDefault", h)
+					return
+				}
+				h.Start(p)
+				h.End(p, nil)
+			}
+		}()
+	}
+	wg.Wait()
+}